@@ -0,0 +1,257 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/atotto/clipboard"
+	"github.com/pwiecz/go-fltk"
+)
+
+const (
+	// The command's output is added to the history as a new entry and copied.
+	ACTION_OUTPUT_ENTRY = "entry"
+	// The command's output is shown in a message box.
+	ACTION_OUTPUT_SHOW = "show"
+	// The command's output is only logged.
+	ACTION_OUTPUT_NONE = "none"
+
+	DEFAULT_ACTION_OUTPUT  = ACTION_OUTPUT_ENTRY
+	DEFAULT_ACTION_TIMEOUT = 10 * time.Second
+
+	// Actions and hooks are run with this shell.
+	ACTION_SHELL = "/bin/sh"
+)
+
+// A user-defined shell command that receives an entry's value on stdin. Actions
+// are run from the actions menu, and hooks are run whenever something is
+// captured.
+type CommandAction struct {
+	// Shown in the actions menu.
+	Name string `json:"name"`
+	// Run with /bin/sh -c. The entry is described by the CLIP_ID, CLIP_HASH,
+	// CLIP_SIZE, CLIP_TIME, CLIP_COUNT and CLIP_PINNED environment variables.
+	Command string `json:"command"`
+	// A regular expression that entries must match for the command to run.
+	// Empty matches every entry.
+	Match string `json:"match,omitempty"`
+	// entry, show or none. Only used by actions - the output of hooks is
+	// always logged.
+	Output string `json:"output,omitempty"`
+	// How long the command may run before it is killed, such as 30s.
+	Timeout string `json:"timeout,omitempty"`
+}
+
+var (
+	actionPatternsMu sync.Mutex
+	// Caches compiled match patterns, keyed by the pattern.
+	actionPatterns = make(map[string]*regexp.Regexp)
+)
+
+// Returns true if the action applies to value. Invalid patterns never match.
+func (a CommandAction) matches(value string) bool {
+	if a.Match == "" {
+		return true
+	}
+
+	actionPatternsMu.Lock()
+	defer actionPatternsMu.Unlock()
+
+	re, ok := actionPatterns[a.Match]
+	if !ok {
+		var err error
+		re, err = regexp.Compile(a.Match)
+		if err != nil {
+			log.Printf("invalid match pattern %q for %v: %v", a.Match, a.Name, err.Error())
+		}
+		actionPatterns[a.Match] = re
+	}
+
+	return re != nil && re.MatchString(value)
+}
+
+// Returns how long the action may run for.
+func (a CommandAction) timeout() time.Duration {
+	if a.Timeout == "" {
+		return DEFAULT_ACTION_TIMEOUT
+	}
+
+	d, err := time.ParseDuration(a.Timeout)
+	if err != nil || d <= 0 {
+		log.Printf("invalid timeout %q for %v, using %v", a.Timeout, a.Name, DEFAULT_ACTION_TIMEOUT)
+		return DEFAULT_ACTION_TIMEOUT
+	}
+
+	return d
+}
+
+// Returns the environment variables describing e.
+func entryEnv(e ClipboardEntry) []string {
+	t := ""
+	if !e.Time.IsZero() {
+		t = e.Time.Format(time.RFC3339)
+	}
+
+	return []string{
+		fmt.Sprintf("CLIP_ID=%v", e.ID),
+		fmt.Sprintf("CLIP_HASH=%v", e.Hash),
+		fmt.Sprintf("CLIP_SIZE=%v", entrySize(e)),
+		fmt.Sprintf("CLIP_TIME=%v", t),
+		fmt.Sprintf("CLIP_COUNT=%v", useCount(e)),
+		fmt.Sprintf("CLIP_PINNED=%v", e.Pinned),
+	}
+}
+
+// Runs the action with value on stdin, and returns its output.
+func (a CommandAction) run(value string, e ClipboardEntry) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), a.timeout())
+	defer cancel()
+
+	stdout := new(bytes.Buffer)
+	stderr := new(bytes.Buffer)
+	code, err := runCommand(ctx, ACTION_SHELL, []string{"-c", a.Command}, entryEnv(e), strings.NewReader(value), stdout, stderr)
+	if ctx.Err() == context.DeadlineExceeded {
+		return "", fmt.Errorf("timed out after %v", a.timeout())
+	}
+	if err != nil {
+		msg := strings.TrimSpace(stderr.String())
+		if msg == "" {
+			msg = err.Error()
+		}
+		return "", fmt.Errorf("exited with code %v: %v", code, msg)
+	}
+
+	return stdout.String(), nil
+}
+
+// Runs the configured hooks for a captured entry in the background.
+func runHooks(e ClipboardEntry) {
+	if len(appConf.Hooks) == 0 {
+		return
+	}
+
+	value := entryValue(e)
+	for _, h := range appConf.Hooks {
+		if !h.matches(value) {
+			continue
+		}
+
+		go func(h CommandAction) {
+			out, err := h.run(value, e)
+			if err != nil {
+				log.Printf("hook %v failed: %v", h.Name, err.Error())
+				return
+			}
+
+			if out = strings.TrimSpace(out); out != "" {
+				log.Printf("hook %v: %v", h.Name, out)
+			}
+		}(h)
+	}
+}
+
+// Rebuilds m with the actions that match at least one selected entry.
+func addActionItems(m *fltk.MenuButton) {
+	m.Clear()
+
+	indices := selectedIndices()
+	for _, a := range appConf.Actions {
+		for _, j := range indices {
+			if a.matches(entryValue(appConf.Log[j])) {
+				m.Add(a.Name, func() { runAction(a) })
+				break
+			}
+		}
+	}
+}
+
+// Shows the actions menu for the selected entries.
+func popupActions(m *fltk.MenuButton) {
+	addActionItems(m)
+	if m.Size() == 0 {
+		setStatus("no actions for the selected entries")
+		return
+	}
+
+	m.Popup()
+}
+
+// Runs a in the background for each selected entry that it matches, and
+// handles the output once it finishes.
+func runAction(a CommandAction) {
+	entries := []ClipboardEntry{}
+	for _, j := range selectedIndices() {
+		if a.matches(entryValue(appConf.Log[j])) {
+			entries = append(entries, appConf.Log[j])
+		}
+	}
+
+	setStatus(fmt.Sprintf("running %v", a.Name))
+
+	go func() {
+		outputs := []string{}
+		var failure error
+		for _, e := range entries {
+			out, err := a.run(entryValue(e), e)
+			if err != nil {
+				failure = err
+				break
+			}
+			outputs = append(outputs, out)
+		}
+
+		// widgets may only be modified from the ui thread
+		fltk.Awake(func() {
+			finishAction(a, outputs, failure)
+		})
+	}()
+}
+
+// Handles the outputs of an action according to its output setting.
+func finishAction(a CommandAction, outputs []string, err error) {
+	if err != nil {
+		log.Printf("action %v failed: %v", a.Name, err.Error())
+		setStatus(fmt.Sprintf("%v failed", a.Name))
+		fltk.MessageBox("Error", fmt.Sprintf("%v failed: %v", a.Name, err.Error()))
+		return
+	}
+
+	result := strings.TrimSuffix(strings.Join(outputs, ""), "\n")
+
+	switch a.Output {
+	case ACTION_OUTPUT_NONE:
+		log.Printf("action %v: %v", a.Name, result)
+	case ACTION_OUTPUT_SHOW:
+		fltk.MessageBox(a.Name, result)
+	default:
+		if result == "" {
+			setStatus(fmt.Sprintf("%v produced no output", a.Name))
+			return
+		}
+
+		for _, out := range outputs {
+			addValue(strings.TrimSuffix(out, "\n"))
+		}
+		loadHistory()
+		reconstruct()
+
+		// the outputs were added separately, so capturing the joined result
+		// would add a duplicate of them
+		if len(outputs) > 1 {
+			ignoreCapture(result)
+		}
+		err = clipboard.WriteAll(result)
+		if err != nil {
+			fltk.MessageBox("Error", fmt.Sprintf("Failed to write to clipboard: %v", err.Error()))
+			return
+		}
+	}
+
+	setStatus(fmt.Sprintf("%v finished", a.Name))
+}
@@ -0,0 +1,496 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	_ "embed"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/atotto/clipboard"
+)
+
+const (
+	// The port on the loopback interface that the api listens on, unless
+	// overridden by port in the api config.
+	DEFAULT_API_PORT = 7733
+	// The token that api requests must present is kept in this file next to
+	// the config file.
+	API_TOKEN_FILE_NAME = "api-token"
+	// The number of entries listed when a request doesn't provide a limit,
+	// and the most that may be requested at once.
+	DEFAULT_API_LIMIT = 50
+	MAX_API_LIMIT     = 1000
+	// How often a comment is sent on idle event streams, so that proxies and
+	// clients don't time them out.
+	API_KEEPALIVE_INTERVAL = 30 * time.Second
+	// How many captures are buffered for a slow event stream before they are
+	// dropped.
+	API_EVENT_BUFFER = 16
+	// Room for the fields of a push request besides its value.
+	API_PUSH_OVERHEAD_BYTES = 64 * 1024
+)
+
+// The OpenAPI description of the api, served at /v1/openapi.json.
+//
+//go:embed assets/openapi.json
+var apiSpec []byte
+
+// Configures the http api on the loopback interface.
+type APIConfig struct {
+	// If true, the api is served while the app is running.
+	Enabled bool `json:"enabled"`
+	// The port to listen on.
+	Port int `json:"port"`
+}
+
+// An entry as represented by the api. Unlike the config file, the value is
+// always the full value, even for entries stored as blobs.
+type apiEntry struct {
+	ID     string    `json:"id"`
+	Value  string    `json:"value"`
+	Size   int       `json:"size"`
+	Time   time.Time `json:"time,omitempty"`
+	Count  int       `json:"count"`
+	Pinned bool      `json:"pinned"`
+	Kind   string    `json:"kind,omitempty"`
+	Tags   []string  `json:"tags"`
+}
+
+// The body of a request to add an entry.
+type apiPush struct {
+	Value string   `json:"value"`
+	Tags  []string `json:"tags"`
+	// If true, the value is also copied to the clipboard.
+	Copy bool `json:"copy"`
+}
+
+var (
+	apiServer *http.Server
+	apiToken  string
+	// Channels of the open event streams, which receive every capture.
+	apiSubscribersMu sync.Mutex
+	apiSubscribers   = map[chan ClipboardEntry]struct{}{}
+)
+
+// Returns the path of the api token file.
+func apiTokenPath() string {
+	return filepath.Join(filepath.Dir(configFilePath), API_TOKEN_FILE_NAME)
+}
+
+// Reads the api token, generating and saving a new one if there is none.
+func loadAPIToken() (string, error) {
+	b, err := os.ReadFile(apiTokenPath())
+	if err == nil && strings.TrimSpace(string(b)) != "" {
+		return strings.TrimSpace(string(b)), nil
+	}
+
+	t := make([]byte, 32)
+	_, _ = rand.Read(t)
+	token := hex.EncodeToString(t)
+
+	err = os.MkdirAll(filepath.Dir(apiTokenPath()), 0o700)
+	if err == nil {
+		err = os.WriteFile(apiTokenPath(), []byte(token+"\n"), 0o600)
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to write api token to %v: %v", apiTokenPath(), err.Error())
+	}
+
+	log.Printf("generated a new api token in %v", apiTokenPath())
+
+	return token, nil
+}
+
+// Starts serving the api on the loopback interface.
+func startAPI() error {
+	if configFilePath == "" {
+		return fmt.Errorf("there is no config directory to keep the api token in")
+	}
+
+	token, err := loadAPIToken()
+	if err != nil {
+		return err
+	}
+	apiToken = token
+
+	if appConf.API.Port == 0 {
+		appConf.API.Port = DEFAULT_API_PORT
+	}
+
+	l, err := net.Listen("tcp", net.JoinHostPort("127.0.0.1", strconv.Itoa(appConf.API.Port)))
+	if err != nil {
+		return fmt.Errorf("failed to listen for api requests on port %v: %v", appConf.API.Port, err.Error())
+	}
+
+	apiServer = &http.Server{
+		Handler:           apiHandler(),
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	log.Printf("serving the api on http://%v", l.Addr())
+
+	go func() {
+		err := apiServer.Serve(l)
+		if err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Printf("api server stopped: %v", err.Error())
+		}
+	}()
+
+	return nil
+}
+
+// Stops serving the api, closing open event streams.
+func stopAPI() {
+	if apiServer == nil {
+		return
+	}
+
+	err := apiServer.Close()
+	if err != nil {
+		log.Printf("failed to stop api server: %v", err.Error())
+	}
+	apiServer = nil
+}
+
+// Returns the handler that serves every api endpoint.
+func apiHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /v1/openapi.json", handleAPISpec)
+	mux.Handle("GET /v1/entries", apiAuth(handleAPIList))
+	mux.Handle("POST /v1/entries", apiAuth(handleAPIPush))
+	mux.Handle("GET /v1/entries/{id}", apiAuth(handleAPIGet))
+	mux.Handle("DELETE /v1/entries/{id}", apiAuth(handleAPIDelete))
+	mux.Handle("GET /v1/events", apiAuth(handleAPIEvents))
+
+	return mux
+}
+
+// Rejects requests that don't present the api token, either as a bearer token
+// or, for clients such as EventSource that can't set headers, as the token
+// query parameter.
+func apiAuth(next http.HandlerFunc) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok {
+			token = r.URL.Query().Get("token")
+		}
+
+		if apiToken == "" || subtle.ConstantTimeCompare([]byte(token), []byte(apiToken)) != 1 {
+			apiError(w, http.StatusUnauthorized, "missing or invalid token")
+			return
+		}
+
+		next(w, r)
+	})
+}
+
+// Writes v as the json response body.
+func apiJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+
+	err := json.NewEncoder(w).Encode(v)
+	if err != nil {
+		log.Printf("failed to write api response: %v", err.Error())
+	}
+}
+
+// Writes an error response.
+func apiError(w http.ResponseWriter, status int, msg string) {
+	apiJSON(w, status, map[string]string{"error": msg})
+}
+
+// Converts an entry to its api representation.
+func toAPIEntry(e ClipboardEntry) apiEntry {
+	v := entryValue(e)
+	tags := e.Tags
+	if tags == nil {
+		tags = []string{}
+	}
+
+	return apiEntry{
+		ID:     e.ID,
+		Value:  v,
+		Size:   len(v),
+		Time:   e.Time,
+		Count:  useCount(e),
+		Pinned: e.Pinned,
+		Kind:   e.Kind,
+		Tags:   tags,
+	}
+}
+
+// Returns up to limit entries matching query and having tag, newest first
+// after skipping offset entries, along with the number of matching entries.
+// Empty values match every entry.
+func queryEntries(query, tag string, offset, limit int) ([]ClipboardEntry, int, error) {
+	if storeEnabled() {
+		entries, err := storeLoad(query, tag, offset, limit)
+		if err != nil {
+			return nil, 0, err
+		}
+
+		total, _ := storeStats(query, tag)
+		slices.Reverse(entries)
+
+		return entries, total, nil
+	}
+
+	q := strings.ToLower(query)
+	matches := []ClipboardEntry{}
+	for i := len(appConf.Log) - 1; i >= 0; i-- {
+		e := appConf.Log[i]
+		if tag != "" && !hasTag(e, tag) {
+			continue
+		}
+		if q != "" && !strings.Contains(strings.ToLower(e.Value), q) {
+			continue
+		}
+		matches = append(matches, e)
+	}
+
+	total := len(matches)
+	if offset > total {
+		offset = total
+	}
+
+	return matches[offset:minz(offset+limit, total)], total, nil
+}
+
+// Returns the entry with the provided id, from memory or the store.
+func findEntry(id string) (ClipboardEntry, bool) {
+	if i := entryIndex(id); i >= 0 {
+		return appConf.Log[i], true
+	}
+
+	return storeFindID(id)
+}
+
+// Serves the OpenAPI description, which doesn't require the token.
+func handleAPISpec(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	_, _ = w.Write(apiSpec)
+}
+
+// Lists or searches entries, newest first.
+func handleAPIList(w http.ResponseWriter, r *http.Request) {
+	params := r.URL.Query()
+
+	offset := 0
+	limit := DEFAULT_API_LIMIT
+	var err error
+	if v := params.Get("offset"); v != "" {
+		offset, err = strconv.Atoi(v)
+		if err != nil || offset < 0 {
+			apiError(w, http.StatusBadRequest, "offset must be a non-negative integer")
+			return
+		}
+	}
+	if v := params.Get("limit"); v != "" {
+		limit, err = strconv.Atoi(v)
+		if err != nil || limit < 1 || limit > MAX_API_LIMIT {
+			apiError(w, http.StatusBadRequest, fmt.Sprintf("limit must be between 1 and %v", MAX_API_LIMIT))
+			return
+		}
+	}
+
+	var entries []ClipboardEntry
+	var total int
+	onUIThread(func() {
+		entries, total, err = queryEntries(params.Get("q"), normalizeTag(params.Get("tag")), offset, limit)
+	})
+	if err != nil {
+		apiError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	result := make([]apiEntry, len(entries))
+	for i, e := range entries {
+		result[i] = toAPIEntry(e)
+	}
+
+	apiJSON(w, http.StatusOK, map[string]any{"total": total, "entries": result})
+}
+
+// Returns a single entry.
+func handleAPIGet(w http.ResponseWriter, r *http.Request) {
+	var e ClipboardEntry
+	var ok bool
+	onUIThread(func() {
+		e, ok = findEntry(r.PathValue("id"))
+	})
+	if !ok {
+		apiError(w, http.StatusNotFound, "no entry with that id")
+		return
+	}
+
+	apiJSON(w, http.StatusOK, toAPIEntry(e))
+}
+
+// Returns the largest push request body that is read. Values above the entry
+// limit are truncated or stored as blobs unless the policy skips them, so
+// those may be up to the history budget. Escaping may double the size of a
+// value in json.
+func apiMaxPushBytes() int64 {
+	n := appConf.MaxEntryBytes
+	if appConf.MaxEntryPolicy != POLICY_SKIP && appConf.MaxHistoryBytes > n {
+		n = appConf.MaxHistoryBytes
+	}
+	if n <= 0 {
+		n = DEFAULT_MAX_HISTORY_BYTES
+	}
+
+	return 2*int64(n) + API_PUSH_OVERHEAD_BYTES
+}
+
+// Adds a value to the history, or moves an existing duplicate of it to the
+// top, and optionally copies it to the clipboard. Pushed values are treated
+// like captured ones, so they are deduplicated, and run the hooks and
+// notifications.
+func handleAPIPush(w http.ResponseWriter, r *http.Request) {
+	var p apiPush
+	err := json.NewDecoder(http.MaxBytesReader(w, r.Body, apiMaxPushBytes())).Decode(&p)
+	if err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			apiError(w, http.StatusRequestEntityTooLarge, "the request body exceeds the size limits")
+			return
+		}
+
+		apiError(w, http.StatusBadRequest, fmt.Sprintf("invalid request body: %v", err.Error()))
+		return
+	}
+
+	if p.Value == "" {
+		apiError(w, http.StatusBadRequest, "value must not be empty")
+		return
+	}
+
+	// handlers don't run on the ui thread, which owns the history
+	var e ClipboardEntry
+	added := false
+	onUIThread(func() {
+		e, added = captureValue(p.Value, p.Tags)
+		if !added {
+			return
+		}
+
+		loadHistory()
+		reconstruct()
+	})
+	if !added {
+		apiError(w, http.StatusUnprocessableEntity, "the value exceeds the size limits")
+		return
+	}
+
+	if p.Copy {
+		err = clipboard.WriteAll(p.Value)
+		if err != nil {
+			apiError(w, http.StatusInternalServerError, fmt.Sprintf("failed to write to clipboard: %v", err.Error()))
+			return
+		}
+	}
+
+	apiJSON(w, http.StatusCreated, toAPIEntry(e))
+}
+
+// Deletes an entry, moving it to the trash. The deletion can be undone in the
+// app.
+func handleAPIDelete(w http.ResponseWriter, r *http.Request) {
+	ok := false
+	onUIThread(func() {
+		var e ClipboardEntry
+		e, ok = findEntry(r.PathValue("id"))
+		if !ok {
+			return
+		}
+
+		deleteEntries(UNDO_DELETE, []ClipboardEntry{e})
+		reconstruct()
+	})
+	if !ok {
+		apiError(w, http.StatusNotFound, "no entry with that id")
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Streams every capture as a server-sent event until the client disconnects.
+func handleAPIEvents(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		apiError(w, http.StatusInternalServerError, "streaming is not supported")
+		return
+	}
+
+	ch := make(chan ClipboardEntry, API_EVENT_BUFFER)
+	apiSubscribersMu.Lock()
+	apiSubscribers[ch] = struct{}{}
+	apiSubscribersMu.Unlock()
+
+	defer func() {
+		apiSubscribersMu.Lock()
+		delete(apiSubscribers, ch)
+		apiSubscribersMu.Unlock()
+	}()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	keepalive := time.NewTicker(API_KEEPALIVE_INTERVAL)
+	defer keepalive.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-keepalive.C:
+			_, err := fmt.Fprint(w, ": keepalive\n\n")
+			if err != nil {
+				return
+			}
+		case e := <-ch:
+			b, err := json.Marshal(toAPIEntry(e))
+			if err != nil {
+				continue
+			}
+
+			_, err = fmt.Fprintf(w, "event: capture\nid: %v\ndata: %s\n\n", e.ID, b)
+			if err != nil {
+				return
+			}
+		}
+
+		flusher.Flush()
+	}
+}
+
+// Sends a captured entry to every open event stream. Streams that aren't
+// keeping up miss it rather than holding up capturing.
+func publishCapture(e ClipboardEntry) {
+	apiSubscribersMu.Lock()
+	defer apiSubscribersMu.Unlock()
+
+	for ch := range apiSubscribers {
+		select {
+		case ch <- e:
+		default:
+		}
+	}
+}
@@ -0,0 +1,366 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+const testAPIToken = "test-token"
+
+// Serves the api over a fresh history of n entries, with "entry 1" the oldest.
+// Work that would run on the ui thread runs directly, one call at a time.
+func startTestAPI(t *testing.T, n int) *httptest.Server {
+	t.Helper()
+
+	oldConf, oldToken, oldUI := appConf, apiToken, onUIThread
+	oldUndo, oldRedo := undoStack, redoStack
+	t.Cleanup(func() {
+		appConf, apiToken, onUIThread = oldConf, oldToken, oldUI
+		undoStack, redoStack = oldUndo, oldRedo
+		invalidateDedupeIndex()
+	})
+
+	var mu sync.Mutex
+	onUIThread = func(f func()) {
+		mu.Lock()
+		defer mu.Unlock()
+		f()
+	}
+
+	apiToken = testAPIToken
+	appConf = AppConfig{MaxEntries: 100, DedupeMode: DEDUPE_CONSECUTIVE, TrashDays: 1}
+	now := time.Now()
+	for i := 1; i <= n; i++ {
+		appConf.Log = append(appConf.Log, ClipboardEntry{
+			ID:    fmt.Sprintf("id%v", i),
+			Value: fmt.Sprintf("entry %v", i),
+			Hash:  hashValue(fmt.Sprintf("entry %v", i)),
+			Time:  now.Add(time.Duration(i-n) * time.Minute),
+			Count: 1,
+		})
+	}
+	invalidateDedupeIndex()
+
+	s := httptest.NewServer(apiHandler())
+	t.Cleanup(s.Close)
+
+	return s
+}
+
+// Sends an authenticated request and returns the response.
+func apiRequest(t *testing.T, s *httptest.Server, method, path, body string) *http.Response {
+	t.Helper()
+
+	req, err := http.NewRequest(method, s.URL+path, strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Authorization", "Bearer "+testAPIToken)
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { resp.Body.Close() })
+
+	return resp
+}
+
+// Decodes a json response body into v, after checking its status.
+func decodeAPI(t *testing.T, resp *http.Response, status int, v any) {
+	t.Helper()
+
+	if resp.StatusCode != status {
+		b, _ := io.ReadAll(resp.Body)
+		t.Fatalf("got status %v, want %v: %s", resp.StatusCode, status, b)
+	}
+
+	if v == nil {
+		return
+	}
+
+	err := json.NewDecoder(resp.Body).Decode(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+// The body of a list response.
+type apiList struct {
+	Total   int        `json:"total"`
+	Entries []apiEntry `json:"entries"`
+}
+
+// Returns the values of the listed entries.
+func (l apiList) values() []string {
+	values := []string{}
+	for _, e := range l.Entries {
+		values = append(values, e.Value)
+	}
+
+	return values
+}
+
+func TestAPIAuth(t *testing.T) {
+	s := startTestAPI(t, 1)
+
+	for _, path := range []string{"/v1/entries", "/v1/entries?token=wrong", "/v1/entries/id1", "/v1/events"} {
+		resp, err := http.Get(s.URL + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+
+		if resp.StatusCode != http.StatusUnauthorized {
+			t.Errorf("%v without a valid token got status %v", path, resp.StatusCode)
+		}
+	}
+
+	req, _ := http.NewRequest(http.MethodGet, s.URL+"/v1/entries", nil)
+	req.Header.Set("Authorization", "Bearer wrong")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("a wrong bearer token got status %v", resp.StatusCode)
+	}
+
+	resp, err = http.Get(s.URL + "/v1/entries?token=" + testAPIToken)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("the token query parameter got status %v", resp.StatusCode)
+	}
+
+	// the description of the api is public
+	resp, err = http.Get(s.URL + "/v1/openapi.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("openapi.json got status %v", resp.StatusCode)
+	}
+}
+
+func TestAPIList(t *testing.T) {
+	s := startTestAPI(t, 12)
+
+	tests := []struct {
+		path  string
+		total int
+		want  []string
+	}{
+		{"/v1/entries?limit=3", 12, []string{"entry 12", "entry 11", "entry 10"}},
+		{"/v1/entries?offset=3&limit=2", 12, []string{"entry 9", "entry 8"}},
+		{"/v1/entries?offset=11", 12, []string{"entry 1"}},
+		{"/v1/entries?offset=20", 12, []string{}},
+		{"/v1/entries?q=ENTRY+1&limit=2", 4, []string{"entry 12", "entry 11"}},
+		{"/v1/entries?q=entry+1&offset=2", 4, []string{"entry 10", "entry 1"}},
+		{"/v1/entries?q=missing", 0, []string{}},
+	}
+
+	for _, test := range tests {
+		var l apiList
+		decodeAPI(t, apiRequest(t, s, http.MethodGet, test.path, ""), http.StatusOK, &l)
+
+		if l.Total != test.total || !slices.Equal(l.values(), test.want) {
+			t.Errorf("%v listed %v of %v, want %v of %v", test.path, l.values(), l.Total, test.want, test.total)
+		}
+	}
+
+	for _, path := range []string{"/v1/entries?limit=0", "/v1/entries?limit=5000", "/v1/entries?offset=-1", "/v1/entries?offset=x"} {
+		decodeAPI(t, apiRequest(t, s, http.MethodGet, path, ""), http.StatusBadRequest, nil)
+	}
+}
+
+func TestAPIGet(t *testing.T) {
+	s := startTestAPI(t, 2)
+
+	var e apiEntry
+	decodeAPI(t, apiRequest(t, s, http.MethodGet, "/v1/entries/id2", ""), http.StatusOK, &e)
+	if e.ID != "id2" || e.Value != "entry 2" || e.Size != 7 {
+		t.Errorf("got %+v", e)
+	}
+
+	decodeAPI(t, apiRequest(t, s, http.MethodGet, "/v1/entries/missing", ""), http.StatusNotFound, nil)
+}
+
+func TestAPIPushAndDelete(t *testing.T) {
+	s := startTestAPI(t, 2)
+
+	var e apiEntry
+	decodeAPI(t, apiRequest(t, s, http.MethodPost, "/v1/entries", `{"value": "pushed", "tags": ["Work", "todo"]}`), http.StatusCreated, &e)
+	if e.ID == "" || e.Value != "pushed" || !slices.Equal(e.Tags, []string{"todo", "work"}) {
+		t.Errorf("pushed %+v", e)
+	}
+
+	var l apiList
+	decodeAPI(t, apiRequest(t, s, http.MethodGet, "/v1/entries?tag=work", ""), http.StatusOK, &l)
+	if l.Total != 1 || l.Entries[0].ID != e.ID {
+		t.Errorf("listing by tag got %+v", l)
+	}
+
+	decodeAPI(t, apiRequest(t, s, http.MethodPost, "/v1/entries", `{"value": ""}`), http.StatusBadRequest, nil)
+	decodeAPI(t, apiRequest(t, s, http.MethodPost, "/v1/entries", `not json`), http.StatusBadRequest, nil)
+
+	decodeAPI(t, apiRequest(t, s, http.MethodDelete, "/v1/entries/"+e.ID, ""), http.StatusNoContent, nil)
+	decodeAPI(t, apiRequest(t, s, http.MethodGet, "/v1/entries/"+e.ID, ""), http.StatusNotFound, nil)
+	decodeAPI(t, apiRequest(t, s, http.MethodDelete, "/v1/entries/"+e.ID, ""), http.StatusNotFound, nil)
+
+	// deletions can be undone in the app
+	if len(undoStack) != 1 {
+		t.Errorf("deleting added %v undo steps, want 1", len(undoStack))
+	}
+	if len(appConf.Trash) != 1 || appConf.Trash[0].ID != e.ID {
+		t.Errorf("the deleted entry wasn't moved to the trash")
+	}
+}
+
+func TestAPIPushDedupe(t *testing.T) {
+	s := startTestAPI(t, 0)
+
+	oldPath := configFilePath
+	t.Cleanup(func() {
+		closeStore()
+		historyDB = nil
+		configFilePath = oldPath
+	})
+
+	configFilePath = filepath.Join(t.TempDir(), "config.json")
+	err := openStore()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// only the newest two of three entries are loaded
+	appConf.MaxEntries = 2
+	appConf.DedupeMode = DEDUPE_GLOBAL
+	now := time.Now()
+	for i := 1; i <= 3; i++ {
+		storePut(ClipboardEntry{
+			ID:    fmt.Sprintf("id%v", i),
+			Value: fmt.Sprintf("entry %v", i),
+			Hash:  hashValue(fmt.Sprintf("entry %v", i)),
+			Time:  now.Add(time.Duration(i-3) * time.Minute),
+			Count: 1,
+		})
+	}
+	loadHistory()
+
+	var e apiEntry
+	decodeAPI(t, apiRequest(t, s, http.MethodPost, "/v1/entries", `{"value": "entry 1"}`), http.StatusCreated, &e)
+	if e.ID != "id1" || e.Count != 2 {
+		t.Errorf("pushing a stored value got %+v, want id1 moved to the top", e)
+	}
+
+	if total, _ := storeStats("", ""); total != 3 {
+		t.Errorf("the store has %v entries after pushing a duplicate, want 3", total)
+	}
+	if appConf.Log[len(appConf.Log)-1].ID != "id1" {
+		t.Errorf("the pushed duplicate isn't the newest loaded entry")
+	}
+}
+
+func TestAPIPushTooLarge(t *testing.T) {
+	s := startTestAPI(t, 0)
+
+	appConf.MaxEntryBytes = 16
+	appConf.MaxEntryPolicy = POLICY_SKIP
+
+	value := strings.Repeat("x", int(apiMaxPushBytes()))
+	decodeAPI(t, apiRequest(t, s, http.MethodPost, "/v1/entries", `{"value": "`+value+`"}`), http.StatusRequestEntityTooLarge, nil)
+
+	// values that fit in the body are still checked against the entry limit
+	decodeAPI(t, apiRequest(t, s, http.MethodPost, "/v1/entries", `{"value": "more than sixteen bytes"}`), http.StatusUnprocessableEntity, nil)
+
+	if len(appConf.Log) != 0 {
+		t.Errorf("rejected values were added to the history")
+	}
+}
+
+func TestAPIEvents(t *testing.T) {
+	s := startTestAPI(t, 1)
+
+	req, _ := http.NewRequest(http.MethodGet, s.URL+"/v1/events?token="+testAPIToken, nil)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("Content-Type") != "text/event-stream" {
+		t.Fatalf("got status %v and content type %v", resp.StatusCode, resp.Header.Get("Content-Type"))
+	}
+
+	// the stream is subscribed once its headers were sent
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		apiSubscribersMu.Lock()
+		n := len(apiSubscribers)
+		apiSubscribersMu.Unlock()
+
+		if n > 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("the event stream didn't subscribe")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// captures and pushed entries are both streamed
+	publishCapture(ClipboardEntry{ID: "captured", Value: "from the clipboard"})
+	var pushed apiEntry
+	decodeAPI(t, apiRequest(t, s, http.MethodPost, "/v1/entries", `{"value": "from the api"}`), http.StatusCreated, &pushed)
+
+	events := make(chan apiEntry)
+	go func() {
+		defer close(events)
+
+		r := bufio.NewReader(resp.Body)
+		event := ""
+		for {
+			line, err := r.ReadString('\n')
+			if err != nil {
+				return
+			}
+
+			line = strings.TrimRight(line, "\n")
+			if v, ok := strings.CutPrefix(line, "event: "); ok {
+				event = v
+			}
+			if v, ok := strings.CutPrefix(line, "data: "); ok && event == "capture" {
+				var e apiEntry
+				if json.Unmarshal([]byte(v), &e) == nil {
+					events <- e
+				}
+			}
+		}
+	}()
+
+	for _, want := range []string{"from the clipboard", "from the api"} {
+		select {
+		case e := <-events:
+			if e.Value != want {
+				t.Errorf("streamed %q, want %q", e.Value, want)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("timed out waiting for %q", want)
+		}
+	}
+}
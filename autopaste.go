@@ -0,0 +1,176 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+const (
+	// Uses xdotool on X11 if it is installed and XTest otherwise, or wtype on
+	// Wayland.
+	PASTE_HELPER_AUTO = "auto"
+	// Uses the XTest extension directly. Requires libXtst.
+	PASTE_HELPER_XTEST   = "xtest"
+	PASTE_HELPER_XDOTOOL = "xdotool"
+	PASTE_HELPER_WTYPE   = "wtype"
+
+	DEFAULT_PASTE_HELPER = PASTE_HELPER_AUTO
+	DEFAULT_PASTE_KEYS   = "ctrl+v"
+
+	// How long to wait after hiding the window, and after focusing the
+	// previous window, before pasting, so that the window manager has caught
+	// up.
+	AUTO_PASTE_DELAY = 150 * time.Millisecond
+)
+
+// Terminals paste with ctrl+shift+v, since ctrl+v is passed to the shell. Keys
+// are matched against the lower-cased WM_CLASS of the focused window.
+var defaultPasteKeys = map[string]string{
+	"alacritty":      "ctrl+shift+v",
+	"foot":           "ctrl+shift+v",
+	"gnome-terminal": "ctrl+shift+v",
+	"kitty":          "ctrl+shift+v",
+	"konsole":        "ctrl+shift+v",
+	"terminator":     "ctrl+shift+v",
+	"tilix":          "ctrl+shift+v",
+	"urxvt":          "shift+Insert",
+	"wezterm":        "ctrl+shift+v",
+	"xfce4-terminal": "ctrl+shift+v",
+	"xterm":          "shift+Insert",
+}
+
+// The window that had focus before the window was shown by the global hotkey,
+// and its WM_CLASS. They are only valid while previousWindowKnown is true,
+// which is the case until the window is hidden again.
+var (
+	previousWindow      uint64
+	previousWindowClass string
+	previousWindowKnown bool
+)
+
+// Remembers the currently focused window, so that auto-paste can return to it.
+func rememberActiveWindow() {
+	previousWindow, previousWindowClass = activeWindow()
+	previousWindowKnown = true
+}
+
+// Forgets the remembered window, so that a later showing of the window that
+// wasn't caused by the hotkey doesn't paste into it.
+func forgetActiveWindow() {
+	previousWindow, previousWindowClass = 0, ""
+	previousWindowKnown = false
+}
+
+// Returns true if copying should paste into the previous window, which is only
+// the case if auto-paste is enabled and the window was remembered when this
+// app was shown.
+func autoPasteEnabled() bool {
+	return appConf.AutoPaste && previousWindowKnown
+}
+
+// Returns the key combination that pastes into a window with the provided
+// WM_CLASS. If several apps match, the longest one wins.
+func pasteKeysFor(class string) string {
+	class = strings.ToLower(class)
+
+	keys := DEFAULT_PASTE_KEYS
+	match := ""
+	for app, k := range appConf.PasteKeys {
+		if len(app) > len(match) && strings.Contains(class, strings.ToLower(app)) {
+			keys = k
+			match = app
+		}
+	}
+
+	return keys
+}
+
+// Converts a key combination such as ctrl+shift+v to wtype's arguments.
+func wtypeArgs(keys string) []string {
+	parts := strings.Split(keys, "+")
+	mods := parts[:len(parts)-1]
+
+	args := []string{}
+	for _, m := range mods {
+		args = append(args, "-M", m)
+	}
+	args = append(args, "-k", parts[len(parts)-1])
+	for i := len(mods) - 1; i >= 0; i-- {
+		args = append(args, "-m", mods[i])
+	}
+
+	return args
+}
+
+// Focuses the previous window and synthesizes the paste keystroke using the
+// configured helper. Any other helper is run as a shell command, with the keys
+// and window id in the PASTE_KEYS and PASTE_WINDOW environment variables.
+func synthesizePaste(window uint64, keys string) error {
+	helper := appConf.PasteHelper
+	if helper == PASTE_HELPER_AUTO {
+		helper = PASTE_HELPER_XDOTOOL
+		if os.Getenv("WAYLAND_DISPLAY") != "" {
+			helper = PASTE_HELPER_WTYPE
+		} else if _, err := exec.LookPath("xdotool"); err != nil {
+			helper = PASTE_HELPER_XTEST
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), DEFAULT_ACTION_TIMEOUT)
+	defer cancel()
+
+	switch helper {
+	case PASTE_HELPER_XTEST:
+		if window != 0 {
+			err := activateWindow(window)
+			if err != nil {
+				return err
+			}
+			time.Sleep(AUTO_PASTE_DELAY)
+		}
+		return fakeKeys(keys)
+	case PASTE_HELPER_XDOTOOL:
+		args := []string{}
+		if window != 0 {
+			args = append(args, "windowactivate", "--sync", fmt.Sprint(window))
+		}
+		args = append(args, "key", "--clearmodifiers", keys)
+		_, err := runCommand(ctx, "xdotool", args, nil, nil, nil, nil)
+		return err
+	case PASTE_HELPER_WTYPE:
+		// wayland doesn't allow focusing other windows, so this relies on
+		// the compositor returning focus to the previous window
+		_, err := runCommand(ctx, "wtype", wtypeArgs(keys), nil, nil, nil, nil)
+		return err
+	}
+
+	env := []string{fmt.Sprintf("PASTE_KEYS=%v", keys), fmt.Sprintf("PASTE_WINDOW=%v", window)}
+	_, err := runCommand(ctx, ACTION_SHELL, []string{"-c", helper}, env, nil, nil, nil)
+
+	return err
+}
+
+// Hides the window and pastes the clipboard into the previously focused
+// window.
+func autoPaste() {
+	shownByHotkey = false
+	win.Hide()
+
+	window, class := previousWindow, previousWindowClass
+	forgetActiveWindow()
+	keys := pasteKeysFor(class)
+
+	go func() {
+		time.Sleep(AUTO_PASTE_DELAY)
+
+		err := synthesizePaste(window, keys)
+		if err != nil {
+			log.Printf("failed to paste into the previous window: %v", err.Error())
+		}
+	}()
+}
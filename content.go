@@ -0,0 +1,309 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/godbus/dbus/v5"
+	"github.com/pwiecz/go-fltk"
+)
+
+const (
+	// Kinds of content that captured values are classified as.
+	KIND_TEXT   = "text"
+	KIND_URL    = "url"
+	KIND_EMAIL  = "email"
+	KIND_PATH   = "path"
+	KIND_COLOR  = "color"
+	KIND_JSON   = "json"
+	KIND_NUMBER = "number"
+	KIND_PHONE  = "phone"
+	KIND_CODE   = "code"
+
+	// Values larger than this are not classified, other than as text.
+	MAX_CLASSIFY_BYTES = 1 << 20
+
+	FILE_MANAGER_DEST = "org.freedesktop.FileManager1"
+	FILE_MANAGER_PATH = dbus.ObjectPath("/org/freedesktop/FileManager1")
+)
+
+var (
+	// Matches web addresses in entries, for opening them.
+	urlPattern = regexp.MustCompile(`(?i)\b(?:https?|ftp)://[^\s<>"'` + "`" + `]+`)
+
+	kindURLPattern    = regexp.MustCompile(`(?i)^(?:(?:https?|ftp)://|www\.)[^\s<>"]+$`)
+	kindEmailPattern  = regexp.MustCompile(`(?i)^(?:mailto:)?[a-z0-9._%+\-]+@[a-z0-9.\-]+\.[a-z]{2,}$`)
+	kindPathPattern   = regexp.MustCompile(`^(?:file://|~|\.{1,2})?/[^\x00\n]*$`)
+	kindColorPattern  = regexp.MustCompile(`(?i)^(?:#(?:[0-9a-f]{3}|[0-9a-f]{6}|[0-9a-f]{8})|rgba?\(\s*\d{1,3}\s*,\s*\d{1,3}\s*,\s*\d{1,3}\s*(?:,\s*[\d.]+\s*)?\))$`)
+	kindNumberPattern = regexp.MustCompile(`(?i)^(?:[-+]?(?:\d[\d_,]*)?\.?\d+(?:e[-+]?\d+)?|0x[0-9a-f]+|0b[01]+)$`)
+	kindPhonePattern  = regexp.MustCompile(`^\+?[\d\s().\-/]{7,20}$`)
+	// Dates and IPv4 addresses, which would otherwise look like phone numbers.
+	kindNotPhonePattern = regexp.MustCompile(`^(?:\d{4}[-/.]\d{1,2}[-/.]\d{1,2}|\d{1,2}[-/.]\d{1,2}[-/.]\d{4}|\d{1,3}(?:\.\d{1,3}){3})$`)
+
+	// Lines that look like code, for the code heuristic.
+	kindCodePattern = regexp.MustCompile(`(?m)(?:[;{}]\s*$|^\s*(?:func|def|class|import|package|return|const|let|var|if|for|while|#include|public|private|fn|use)\b|=>|:=|==|!=|&&|\|\|)`)
+)
+
+// The tags shown in the history for each kind. Plain text has none.
+var kindTags = map[string]string{
+	KIND_URL:    "[url]",
+	KIND_EMAIL:  "[email]",
+	KIND_PATH:   "[path]",
+	KIND_COLOR:  "[color]",
+	KIND_JSON:   "[json]",
+	KIND_NUMBER: "[number]",
+	KIND_PHONE:  "[phone]",
+	KIND_CODE:   "[code]",
+}
+
+// Returns the kind of content in value.
+func classify(value string) string {
+	v := strings.TrimSpace(value)
+	if v == "" || len(v) > MAX_CLASSIFY_BYTES {
+		return KIND_TEXT
+	}
+
+	singleLine := !strings.Contains(v, "\n")
+
+	switch {
+	case (v[0] == '{' || v[0] == '[') && json.Valid([]byte(v)):
+		return KIND_JSON
+	case singleLine && kindURLPattern.MatchString(v):
+		return KIND_URL
+	case singleLine && kindEmailPattern.MatchString(v):
+		return KIND_EMAIL
+	case singleLine && kindColorPattern.MatchString(v):
+		return KIND_COLOR
+	case singleLine && kindPathPattern.MatchString(v):
+		return KIND_PATH
+	case singleLine && kindNumberPattern.MatchString(v):
+		return KIND_NUMBER
+	case singleLine && kindPhonePattern.MatchString(v) && countDigits(v) >= 7 && !kindNotPhonePattern.MatchString(v):
+		return KIND_PHONE
+	case len(kindCodePattern.FindAllStringIndex(v, 3)) >= 3:
+		return KIND_CODE
+	}
+
+	return KIND_TEXT
+}
+
+// Returns the number of ascii digits in s.
+func countDigits(s string) int {
+	n := 0
+	for _, r := range s {
+		if r >= '0' && r <= '9' {
+			n++
+		}
+	}
+
+	return n
+}
+
+// Returns the kind of an entry, classifying it if it was captured before
+// kinds existed.
+func entryKind(e ClipboardEntry) string {
+	if e.Kind != "" {
+		return e.Kind
+	}
+
+	return classify(e.Value)
+}
+
+// Returns the first web address in s, without trailing punctuation, or an
+// empty string if there is none.
+func findURL(s string) string {
+	return strings.TrimRight(urlPattern.FindString(s), ".,;:!?)]}")
+}
+
+// Opens a file or address with the desktop's default app in the background.
+// xdg-open may not return until the opened app exits, so unlike actions it
+// runs without a time limit, and is only waited for so that it doesn't linger
+// as a zombie.
+func xdgOpen(target string) {
+	cmd := exec.Command("xdg-open", target)
+	err := cmd.Start()
+	if err != nil {
+		log.Printf("failed to open %v: %v", target, err.Error())
+		return
+	}
+
+	go func() {
+		err := cmd.Wait()
+		if err != nil {
+			log.Printf("failed to open %v: %v", target, err.Error())
+		}
+	}()
+}
+
+// Returns the absolute path that a path entry refers to.
+func entryPath(value string) string {
+	p := strings.TrimSpace(value)
+	if strings.HasPrefix(p, "file://") {
+		if u, err := url.Parse(p); err == nil {
+			p = u.Path
+		}
+	}
+
+	if p == "~" || strings.HasPrefix(p, "~/") {
+		if home, err := os.UserHomeDir(); err == nil {
+			p = filepath.Join(home, strings.TrimPrefix(p, "~"))
+		}
+	}
+
+	abs, err := filepath.Abs(p)
+	if err != nil {
+		return p
+	}
+
+	return abs
+}
+
+// Shows a file in the desktop's file manager, selecting it if the file
+// manager supports that. Otherwise the directory containing it is opened.
+func showInFileManager(path string) {
+	go func() {
+		conn, err := dbus.SessionBus()
+		if err == nil {
+			u := url.URL{Scheme: "file", Path: path}
+			err = conn.Object(FILE_MANAGER_DEST, FILE_MANAGER_PATH).Call(
+				FILE_MANAGER_DEST+".ShowItems", 0, []string{u.String()}, "",
+			).Err
+		}
+		if err == nil {
+			return
+		}
+
+		log.Printf("file manager does not support showing items, opening the directory instead: %v", err.Error())
+
+		dir := path
+		if info, err := os.Stat(path); err != nil || !info.IsDir() {
+			dir = filepath.Dir(path)
+		}
+		xdgOpen(dir)
+	}()
+}
+
+// Parses a color written as #rgb, #rrggbb, #rrggbbaa or rgb(r, g, b). The
+// alpha channel is ignored.
+func parseEntryColor(s string) (uint8, uint8, uint8, error) {
+	s = strings.ToLower(strings.TrimSpace(s))
+
+	if strings.HasPrefix(s, "rgb") {
+		parts := strings.FieldsFunc(s, func(r rune) bool {
+			return r == '(' || r == ')' || r == ',' || r == ' '
+		})
+		if len(parts) < 4 {
+			return 0, 0, 0, fmt.Errorf("invalid color %q", s)
+		}
+
+		c := [3]uint8{}
+		for i := range c {
+			v, err := strconv.Atoi(parts[i+1])
+			if err != nil || v < 0 || v > 255 {
+				return 0, 0, 0, fmt.Errorf("invalid color %q", s)
+			}
+			c[i] = uint8(v)
+		}
+
+		return c[0], c[1], c[2], nil
+	}
+
+	h := strings.TrimPrefix(s, "#")
+	switch len(h) {
+	case 3:
+		h = string([]byte{h[0], h[0], h[1], h[1], h[2], h[2]})
+	case 8:
+		h = h[:6]
+	}
+
+	return parseColor("#" + h)
+}
+
+// Shows a swatch of the color written in value.
+func colorDialog(value string) {
+	r, g, b, err := parseEntryColor(value)
+	if err != nil {
+		fltk.MessageBox("Error", err.Error())
+		return
+	}
+
+	hex := fmt.Sprintf("#%02x%02x%02x", r, g, b)
+
+	dialog := fltk.NewWindow(260, 220, "Color")
+	swatch := fltk.NewBox(fltk.BORDER_BOX, 10, 10, 240, 120)
+	swatch.SetColor(themeColor(hex))
+	info := fltk.NewBox(fltk.NO_BOX, 10, 135, 240, 40, fmt.Sprintf("%v\nrgb(%v, %v, %v)", hex, r, g, b))
+	info.SetAlign(fltk.ALIGN_INSIDE | fltk.ALIGN_LEFT)
+	closeBtn := fltk.NewButton(130, 180, 120, 30, "Close")
+	dialog.End()
+
+	closeBtn.SetCallback(func() {
+		dialog.Hide()
+		dialog.Destroy()
+	})
+
+	dialog.Show()
+}
+
+// Reports whether value is valid JSON, and where the first error is if not.
+func validateJSON(value string) {
+	var v any
+	err := json.Unmarshal([]byte(value), &v)
+	if err == nil {
+		fltk.MessageBox("Valid JSON", "The entry is valid JSON.")
+		return
+	}
+
+	if se, ok := err.(*json.SyntaxError); ok {
+		line := strings.Count(value[:min(int(se.Offset), len(value))], "\n") + 1
+		fltk.MessageBox("Invalid JSON", fmt.Sprintf("Line %v (byte %v): %v", line, se.Offset, err.Error()))
+		return
+	}
+
+	fltk.MessageBox("Invalid JSON", err.Error())
+}
+
+// Adds the items for the kind of the entry at index i in appConf.Log to m.
+func addKindItems(m *fltk.MenuButton, i int) {
+	value := entryValue(appConf.Log[i])
+	v := strings.TrimSpace(value)
+
+	switch entryKind(appConf.Log[i]) {
+	case KIND_EMAIL:
+		if !strings.HasPrefix(strings.ToLower(v), "mailto:") {
+			v = "mailto:" + v
+		}
+		m.Add("Compose Email", func() { xdgOpen(v) })
+	case KIND_PATH:
+		p := entryPath(v)
+		m.Add("Open File", func() { xdgOpen(p) })
+		m.Add("Show in File Manager", func() { showInFileManager(p) })
+	case KIND_COLOR:
+		m.Add("Preview Color...", func() { colorDialog(v) })
+	case KIND_JSON:
+		if t, ok := findTransform("json-pretty"); ok {
+			m.Add("Pretty Print JSON", func() { transformSelected(t) })
+		}
+	}
+
+	// entries that were meant to be json but aren't valid are classified as
+	// something else, and validating them shows where the error is
+	if strings.HasPrefix(v, "{") || strings.HasPrefix(v, "[") {
+		m.Add("Validate JSON", func() { validateJSON(value) })
+	}
+
+	if u := findURL(value); u != "" {
+		m.Add("Open URL", func() { xdgOpen(u) })
+	} else if entryKind(appConf.Log[i]) == KIND_URL {
+		// www. addresses without a scheme
+		m.Add("Open URL", func() { xdgOpen("https://" + v) })
+	}
+}
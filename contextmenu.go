@@ -0,0 +1,116 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/pwiecz/go-fltk"
+)
+
+// Escapes the characters that fltk menus interpret in labels, so that s is
+// shown as a single item.
+func menuLabel(s string) string {
+	return strings.NewReplacer("&", "&&", "/", `\/`, "_", `\_`).Replace(s)
+}
+
+// Returns the newest selected entry's index in appConf.Log, or -1.
+func newestSelected() int {
+	indices := selectedIndices()
+	if len(indices) == 0 {
+		return -1
+	}
+
+	return indices[len(indices)-1]
+}
+
+// Shows the full value of the newest selected entry in a read-only window.
+func previewDialog() {
+	i := newestSelected()
+	if i < 0 {
+		setStatus("nothing selected to preview")
+		return
+	}
+
+	e := appConf.Log[i]
+	title := fmt.Sprintf("Preview (%v)", formatBytes(len(entryValue(e))))
+	if !e.Time.IsZero() {
+		title = fmt.Sprintf("Preview (%v, %v)", formatBytes(len(entryValue(e))), e.Time.Format("2006-01-02 15:04:05"))
+	}
+
+	dialog := fltk.NewWindow(480, 360, title)
+
+	buf := fltk.NewTextBuffer()
+	buf.SetText(obscure(entryValue(e), appConf.Secrets))
+	display := fltk.NewTextDisplay(10, 10, 460, 295)
+	display.SetBuffer(buf)
+	display.SetWrapMode(fltk.WRAP_AT_BOUNDS)
+
+	closeBtn := fltk.NewButton(245, 320, 225, 30, "Close")
+	dialog.Resizable(display)
+	dialog.End()
+
+	closeBtn.SetCallback(func() {
+		dialog.Hide()
+		dialog.Destroy()
+	})
+
+	dialog.Show()
+}
+
+// Fills m with the operations for the selected entries and pops it up. The
+// items run the same handlers as the keybindings, and show their keys.
+func popupContextMenu(m *fltk.MenuButton, handlers map[string]func()) {
+	shortcuts, _ := shortcutsFor(resolveKeybindings(appConf.KeyPreset, appConf.Keybindings))
+	indices := selectedIndices()
+	if len(indices) == 0 {
+		return
+	}
+
+	// Adds the handler for the provided keybinding action.
+	add := func(label, action string, flags int) {
+		m.AddEx(label, shortcuts[action], handlers[action], flags)
+	}
+
+	m.Clear()
+
+	add("Copy", KEY_COPY, 0)
+	m.Add("Copy As Plain Text", func() {
+		f := CopyFormat{}
+		f.setDefaults()
+		copySelected(f)
+	})
+	add("Copy As...", KEY_COPY_AS, fltk.MENU_DIVIDER)
+
+	add("Edit...", KEY_EDIT, 0)
+	m.Add("Preview...", previewDialog)
+
+	pinLabel := "Pin"
+	if appConf.Log[indices[len(indices)-1]].Pinned {
+		pinLabel = "Unpin"
+	}
+	add(pinLabel, KEY_PIN, 0)
+	add("Delete", KEY_DELETE, fltk.MENU_DIVIDER)
+
+	addTagItems(m, indices, shortcuts[KEY_TAG])
+
+	for _, t := range transforms {
+		m.Add("Transform/"+menuLabel(t.Label), func() { transformSelected(t) })
+	}
+
+	for _, a := range appConf.Actions {
+		for _, j := range indices {
+			if a.matches(entryValue(appConf.Log[j])) {
+				m.Add("Actions/"+menuLabel(a.Name), func() { runAction(a) })
+				break
+			}
+		}
+	}
+
+	addKindItems(m, newestSelected())
+	addPeerItems(m, newestSelected())
+
+	m.Popup()
+	// the items' shortcuts would otherwise be handled in addition to the
+	// keybindings
+	m.Clear()
+}
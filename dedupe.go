@@ -0,0 +1,189 @@
+package main
+
+import (
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// Every captured value is added as a new entry.
+	DEDUPE_NONE = "none"
+	// A captured value is ignored if it matches the most recent entry.
+	DEDUPE_CONSECUTIVE = "consecutive"
+	// A captured value that matches any entry in the history moves that entry
+	// to the top instead of adding a new one.
+	DEDUPE_GLOBAL = "global"
+
+	DEFAULT_DEDUPE_MODE = DEDUPE_CONSECUTIVE
+)
+
+var (
+	// Guards normalizedHashes and dedupeIndex, since entries are added by the
+	// capture goroutine as well as by imports, the api and sync.
+	dedupeMu sync.Mutex
+	// Caches the whitespace-insensitive hash of each entry, keyed by the hash
+	// of its full value, so that entries don't have to be re-read and
+	// re-hashed on every capture.
+	normalizedHashes = make(map[string]string)
+	// The index in appConf.Log of the newest entry with each dedupe key, so
+	// that the global dedupe mode doesn't scan the whole history. Nil when it
+	// has to be rebuilt.
+	dedupeIndex map[string]int
+)
+
+// Collapses all runs of whitespace into a single space and trims the ends, so
+// that values differing only by whitespace compare equal.
+func normalizeWhitespace(s string) string {
+	return strings.Join(strings.Fields(s), " ")
+}
+
+// Returns the key used to compare e against other entries for the configured
+// dedupe settings.
+func dedupeKey(e ClipboardEntry) string {
+	dedupeMu.Lock()
+	defer dedupeMu.Unlock()
+
+	return dedupeKeyLocked(e)
+}
+
+// Same as dedupeKey, but must be called with dedupeMu held.
+func dedupeKeyLocked(e ClipboardEntry) string {
+	if !appConf.DedupeIgnoreWhitespace {
+		return e.Hash
+	}
+
+	k, ok := normalizedHashes[e.Hash]
+	if !ok {
+		k = hashValue(normalizeWhitespace(entryValue(e)))
+		normalizedHashes[e.Hash] = k
+	}
+
+	return k
+}
+
+// Forgets the dedupe index, so that it is rebuilt on the next lookup. Called
+// whenever entries are removed, replaced or reordered, since that changes
+// their indices.
+func invalidateDedupeIndex() {
+	dedupeMu.Lock()
+	defer dedupeMu.Unlock()
+
+	dedupeIndex = nil
+}
+
+// Records that the entry at index i was added to the end of the history.
+func indexDedupeEntry(i int) {
+	dedupeMu.Lock()
+	defer dedupeMu.Unlock()
+
+	if dedupeIndex != nil {
+		dedupeIndex[dedupeKeyLocked(appConf.Log[i])] = i
+	}
+}
+
+// Rebuilds the dedupe index from the whole history. Must be called with
+// dedupeMu held.
+func rebuildDedupeIndex() {
+	dedupeIndex = make(map[string]int, len(appConf.Log))
+	for i, e := range appConf.Log {
+		dedupeIndex[dedupeKeyLocked(e)] = i
+	}
+}
+
+// Returns the index in appConf.Log of an entry that value duplicates according
+// to the configured dedupe mode, or -1 if there is none. hash must be the hash
+// of value.
+func findDuplicate(value, hash string) int {
+	l := len(appConf.Log)
+	if l == 0 || appConf.DedupeMode == DEDUPE_NONE {
+		return -1
+	}
+
+	dedupeMu.Lock()
+	defer dedupeMu.Unlock()
+
+	key := hash
+	if appConf.DedupeIgnoreWhitespace {
+		key = hashValue(normalizeWhitespace(value))
+		normalizedHashes[hash] = key
+	}
+
+	if appConf.DedupeMode == DEDUPE_GLOBAL {
+		if dedupeIndex == nil {
+			rebuildDedupeIndex()
+		}
+
+		i, ok := dedupeIndex[key]
+		if ok && (i >= l || dedupeKeyLocked(appConf.Log[i]) != key) {
+			// the history changed without invalidating the index
+			rebuildDedupeIndex()
+			i, ok = dedupeIndex[key]
+		}
+		if !ok {
+			return -1
+		}
+
+		return i
+	}
+
+	if dedupeKeyLocked(appConf.Log[l-1]) == key {
+		return l - 1
+	}
+
+	return -1
+}
+
+// Finds a duplicate in the store of the value with the provided hash. The
+// store holds more history than is loaded, so this only applies to global
+// dedupe of exact values.
+func findStoredDuplicate(hash string) (ClipboardEntry, bool) {
+	if appConf.DedupeMode != DEDUPE_GLOBAL || appConf.DedupeIgnoreWhitespace {
+		return ClipboardEntry{}, false
+	}
+
+	return storeFindHash(hash)
+}
+
+// Moves the entry at index i to the top of the history, updating its capture
+// time and use count.
+func bumpEntry(i int) {
+	e := appConf.Log[i]
+	e.Time = time.Now()
+	e.Count = useCount(e) + 1
+
+	appConf.Log = append(appConf.Log[:i], appConf.Log[i+1:]...)
+	invalidateDedupeIndex()
+	appendEntry(e)
+}
+
+// Returns the number of times the entry has been captured.
+func useCount(e ClipboardEntry) int {
+	if e.Count < 1 {
+		return 1
+	}
+
+	return e.Count
+}
+
+// Fills in the id, hash and size of entries saved by older versions of this
+// app, which are needed for dedupe, the size limits, and the history store.
+func backfillEntries() {
+	for i, e := range appConf.Log {
+		if e.ID == "" {
+			appConf.Log[i].ID = newEntryID()
+		}
+
+		if e.Kind == "" {
+			appConf.Log[i].Kind = classify(entryValue(e))
+		}
+
+		if e.Hash != "" {
+			continue
+		}
+
+		v := entryValue(e)
+		appConf.Log[i].Hash = hashValue(v)
+		appConf.Log[i].Size = len(v)
+	}
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/pwiecz/go-fltk"
+)
+
+// Replaces the value of the entry at index i in appConf.Log, applying the
+// size policies as if it had just been captured. The entry keeps its id, time,
+// use count, pinned state and tags, and gains the tags the tag rules give to
+// the new value. The edit can be undone. Returns false if the size policies
+// rejected the new value.
+func editEntry(i int, value string) bool {
+	old := appConf.Log[i]
+
+	e, ok := newEntry(value)
+	if !ok {
+		return false
+	}
+
+	e.ID = old.ID
+	e.Time = old.Time
+	e.Count = old.Count
+	e.Pinned = old.Pinned
+	e.Tags = addTags(old.Tags, e.Tags...)
+	e.Selected = old.Selected
+
+	appConf.Log[i] = e
+	pushUndo(UNDO_EDIT, []entryChange{{ID: e.ID, Before: &old, After: &e}})
+	updateEntry(i)
+	removeBlob(old, appConf.Log)
+
+	return true
+}
+
+// Shows a dialog for editing the value of the newest selected entry.
+func editDialog() {
+	indices := selectedIndices()
+	if len(indices) == 0 {
+		setStatus("nothing selected to edit")
+		return
+	}
+
+	// selectedIndices is in ascending order, so the newest entry is last
+	i := indices[len(indices)-1]
+	id := appConf.Log[i].ID
+
+	dialog := fltk.NewWindow(480, 360, "Edit Entry")
+	dialog.SetModal()
+
+	buf := fltk.NewTextBuffer()
+	buf.SetText(entryValue(appConf.Log[i]))
+	editor := fltk.NewTextEditor(10, 10, 460, 295)
+	editor.SetBuffer(buf)
+	editor.SetWrapMode(fltk.WRAP_AT_BOUNDS)
+
+	cancelBtn := fltk.NewButton(10, 320, 225, 30, "Cancel")
+	saveBtn := fltk.NewButton(245, 320, 225, 30, "&Save")
+	dialog.Resizable(editor)
+	dialog.End()
+
+	closeDialog := func() {
+		dialog.Hide()
+		dialog.Destroy()
+	}
+
+	cancelBtn.SetCallback(closeDialog)
+
+	saveBtn.SetCallback(func() {
+		value := buf.Text()
+		closeDialog()
+
+		// the history may have changed while the dialog was open
+		j := entryIndex(id)
+		if j < 0 {
+			setStatus("the edited entry no longer exists")
+			return
+		}
+
+		if !editEntry(j, value) {
+			fltk.MessageBox("Error", fmt.Sprintf("The edited value (%v) exceeds the size limits.", formatBytes(len(value))))
+			return
+		}
+
+		setStatus("entry edited")
+		reconstruct()
+	})
+
+	dialog.Show()
+	editor.TakeFocus()
+}
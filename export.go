@@ -0,0 +1,484 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	FORMAT_JSONL    = "jsonl"
+	FORMAT_TEXT     = "txt"
+	FORMAT_CSV      = "csv"
+	FORMAT_MARKDOWN = "md"
+
+	// Separates entries in the plain text format, unless overridden by
+	// textSeparator in the config.
+	DEFAULT_TEXT_SEPARATOR = "\n---\n"
+
+	EXPORT_FILE_FILTER = "JSON Lines\t*.jsonl\nPlain Text\t*.txt\nCSV\t*.csv\nMarkdown\t*.md"
+)
+
+var exportFormats = []string{FORMAT_JSONL, FORMAT_TEXT, FORMAT_CSV, FORMAT_MARKDOWN}
+
+// The representation of an entry in exported files. Only the full value and
+// metadata that is meaningful outside of this app are included.
+type exportEntry struct {
+	Value  string    `json:"value"`
+	Time   time.Time `json:"time,omitempty"`
+	Count  int       `json:"count,omitempty"`
+	Pinned bool      `json:"pinned,omitempty"`
+	Tags   []string  `json:"tags,omitempty"`
+}
+
+// Determines the export format from a file's extension, unless format is
+// already set.
+func resolveFormat(format, fileName string) (string, error) {
+	if format == "" {
+		format = strings.TrimPrefix(strings.ToLower(filepath.Ext(fileName)), ".")
+	}
+
+	switch format {
+	case "json", "ndjson":
+		format = FORMAT_JSONL
+	case "text":
+		format = FORMAT_TEXT
+	case "markdown":
+		format = FORMAT_MARKDOWN
+	}
+
+	if !slices.Contains(exportFormats, format) {
+		return "", fmt.Errorf("unsupported format %v, must be one of %v", format, strings.Join(exportFormats, ", "))
+	}
+
+	return format, nil
+}
+
+// Converts entries to their exported representation, reading blobs as needed.
+func toExportEntries(entries []ClipboardEntry) []exportEntry {
+	result := make([]exportEntry, len(entries))
+	for i, e := range entries {
+		result[i] = exportEntry{
+			Value:  entryValue(e),
+			Time:   e.Time,
+			Count:  e.Count,
+			Pinned: e.Pinned,
+			Tags:   e.Tags,
+		}
+	}
+
+	return result
+}
+
+// Encodes entries, oldest first, in the provided format. sep is only used for
+// the plain text format.
+func exportEntries(entries []ClipboardEntry, format, sep string) ([]byte, error) {
+	buf := new(bytes.Buffer)
+	ee := toExportEntries(entries)
+
+	switch format {
+	case FORMAT_JSONL:
+		enc := json.NewEncoder(buf)
+		for _, e := range ee {
+			err := enc.Encode(e)
+			if err != nil {
+				return nil, fmt.Errorf("failed to encode entry: %v", err.Error())
+			}
+		}
+	case FORMAT_TEXT:
+		values := make([]string, len(ee))
+		for i, e := range ee {
+			values[i] = e.Value
+		}
+		buf.WriteString(strings.Join(values, sep))
+	case FORMAT_CSV:
+		w := csv.NewWriter(buf)
+		_ = w.Write([]string{"time", "count", "pinned", "value", "tags"})
+		for _, e := range ee {
+			t := ""
+			if !e.Time.IsZero() {
+				t = e.Time.Format(time.RFC3339)
+			}
+
+			err := w.Write([]string{t, strconv.Itoa(e.Count), strconv.FormatBool(e.Pinned), e.Value, strings.Join(e.Tags, ",")})
+			if err != nil {
+				return nil, fmt.Errorf("failed to encode entry: %v", err.Error())
+			}
+		}
+		w.Flush()
+		if w.Error() != nil {
+			return nil, fmt.Errorf("failed to write csv: %v", w.Error().Error())
+		}
+	case FORMAT_MARKDOWN:
+		buf.WriteString("# Clipboard history\n")
+		for _, e := range ee {
+			t := "-"
+			if !e.Time.IsZero() {
+				t = e.Time.Format(time.RFC3339)
+			}
+
+			buf.WriteString(fmt.Sprintf("\n## %v\n\n", t))
+			if e.Count > 1 {
+				buf.WriteString(fmt.Sprintf("- count: %v\n", e.Count))
+			}
+			if e.Pinned {
+				buf.WriteString("- pinned: true\n")
+			}
+			if len(e.Tags) > 0 {
+				buf.WriteString(fmt.Sprintf("- tags: %v\n", strings.Join(e.Tags, ", ")))
+			}
+			if e.Count > 1 || e.Pinned || len(e.Tags) > 0 {
+				buf.WriteString("\n")
+			}
+
+			fence := markdownFence(e.Value)
+			buf.WriteString(fmt.Sprintf("%v\n%v\n%v\n", fence, e.Value, fence))
+		}
+	default:
+		return nil, fmt.Errorf("unsupported format %v", format)
+	}
+
+	return buf.Bytes(), nil
+}
+
+// Returns a code fence that is longer than any run of backticks in s, so that
+// s can be safely embedded in a fenced code block.
+func markdownFence(s string) string {
+	longest, run := 0, 0
+	for _, r := range s {
+		if r == '`' {
+			run++
+			longest = max(longest, run)
+		} else {
+			run = 0
+		}
+	}
+
+	return strings.Repeat("`", max(3, longest+1))
+}
+
+// Decodes entries in the provided format. sep is only used for the plain text
+// format.
+func importEntries(b []byte, format, sep string) ([]exportEntry, error) {
+	result := []exportEntry{}
+
+	switch format {
+	case FORMAT_JSONL:
+		dec := json.NewDecoder(bytes.NewReader(b))
+		for dec.More() {
+			var e exportEntry
+			err := dec.Decode(&e)
+			if err != nil {
+				return nil, fmt.Errorf("failed to decode entry %v: %v", len(result)+1, err.Error())
+			}
+			result = append(result, e)
+		}
+	case FORMAT_TEXT:
+		for _, v := range strings.Split(string(b), sep) {
+			if v == "" {
+				continue
+			}
+			result = append(result, exportEntry{Value: v})
+		}
+	case FORMAT_CSV:
+		cr := csv.NewReader(bytes.NewReader(b))
+		// files exported before tags existed have no tags column
+		cr.FieldsPerRecord = -1
+		records, err := cr.ReadAll()
+		if err != nil {
+			return nil, fmt.Errorf("failed to read csv: %v", err.Error())
+		}
+
+		for i, r := range records {
+			if len(r) != 4 && len(r) != 5 {
+				return nil, fmt.Errorf("row %v has %v columns, expected 4 or 5", i+1, len(r))
+			}
+
+			if i == 0 && r[3] == "value" {
+				continue
+			}
+
+			e := exportEntry{Value: r[3]}
+			e.Time, _ = time.Parse(time.RFC3339, r[0])
+			e.Count, _ = strconv.Atoi(r[1])
+			e.Pinned, _ = strconv.ParseBool(r[2])
+			if len(r) == 5 {
+				e.Tags = addTags(nil, strings.Split(r[4], ",")...)
+			}
+			result = append(result, e)
+		}
+	case FORMAT_MARKDOWN:
+		return importMarkdown(b), nil
+	default:
+		return nil, fmt.Errorf("unsupported format %v", format)
+	}
+
+	return result, nil
+}
+
+// Parses the markdown format written by exportEntries.
+func importMarkdown(b []byte) []exportEntry {
+	result := []exportEntry{}
+	var current exportEntry
+	fence := ""
+	lines := []string{}
+
+	scanner := bufio.NewScanner(bytes.NewReader(b))
+	scanner.Buffer(make([]byte, 0, 64*1024), len(b)+1)
+	for scanner.Scan() {
+		line := scanner.Text()
+
+		if fence != "" {
+			if line == fence {
+				current.Value = strings.Join(lines, "\n")
+				result = append(result, current)
+				current = exportEntry{}
+				fence = ""
+				lines = []string{}
+				continue
+			}
+
+			lines = append(lines, line)
+			continue
+		}
+
+		switch {
+		case strings.HasPrefix(line, "## "):
+			current = exportEntry{}
+			current.Time, _ = time.Parse(time.RFC3339, strings.TrimPrefix(line, "## "))
+		case strings.HasPrefix(line, "- count: "):
+			current.Count, _ = strconv.Atoi(strings.TrimPrefix(line, "- count: "))
+		case line == "- pinned: true":
+			current.Pinned = true
+		case strings.HasPrefix(line, "- tags: "):
+			current.Tags = addTags(nil, strings.Split(strings.TrimPrefix(line, "- tags: "), ",")...)
+		case strings.HasPrefix(line, "```"):
+			fence = strings.TrimRight(line, "abcdefghijklmnopqrstuvwxyz")
+		}
+	}
+
+	return result
+}
+
+// Adds imported entries to the history, skipping values that the configured
+// dedupe mode considers duplicates, and keeps the history in chronological
+// order. Returns the number of entries added, and how many of them are still
+// in the history after applying the history limits.
+func mergeEntries(entries []exportEntry) (int, int) {
+	// entries without a time, such as those imported from plain text, are
+	// treated as if they were just captured, in the order that they were
+	// read. Otherwise they would be the oldest entries, and the first to be
+	// dropped by the history limits.
+	untimed := 0
+	for _, ie := range entries {
+		if ie.Time.IsZero() {
+			untimed++
+		}
+	}
+	stamp := time.Now().Add(-time.Duration(untimed) * time.Microsecond)
+
+	ids := []string{}
+	for _, ie := range entries {
+		hash := hashValue(ie.Value)
+		if i := findDuplicate(ie.Value, hash); i >= 0 {
+			existing := &appConf.Log[i]
+			existing.Pinned = existing.Pinned || ie.Pinned
+			existing.Tags = addTags(existing.Tags, ie.Tags...)
+			if ie.Time.After(existing.Time) {
+				existing.Time = ie.Time
+			}
+			updateEntry(i)
+			continue
+		}
+
+		e, ok := newEntry(ie.Value)
+		if !ok {
+			continue
+		}
+
+		e.Time = ie.Time
+		if e.Time.IsZero() {
+			stamp = stamp.Add(time.Microsecond)
+			e.Time = stamp
+		}
+		e.Count = max(ie.Count, 1)
+		e.Pinned = ie.Pinned
+		e.Tags = addTags(e.Tags, ie.Tags...)
+		appendEntry(e)
+		ids = append(ids, e.ID)
+	}
+
+	sortHistory()
+
+	// the store keeps every entry, so only the history in the config file
+	// can lose entries to the limits
+	if storeEnabled() {
+		return len(ids), len(ids)
+	}
+
+	enforceMaxEntries()
+	enforceHistoryBudget()
+
+	kept := 0
+	for _, id := range ids {
+		if entryIndex(id) >= 0 {
+			kept++
+		}
+	}
+
+	return len(ids), kept
+}
+
+// Returns a summary of an import for status messages and logs.
+func importMessage(read, added, kept int) string {
+	msg := fmt.Sprintf("imported %v/%v entries", added, read)
+	if kept < added {
+		msg = fmt.Sprintf("%v, %v of them were dropped to stay within the history limits", msg, added-kept)
+	}
+
+	return msg
+}
+
+// Returns the text separator from the config, or the default.
+func textSeparator() string {
+	if appConf.TextSeparator == "" {
+		return DEFAULT_TEXT_SEPARATOR
+	}
+
+	return appConf.TextSeparator
+}
+
+// Writes entries to fileName. If format is empty, it is determined from the
+// file's extension.
+func exportFile(fileName, format string, entries []ClipboardEntry) error {
+	format, err := resolveFormat(format, fileName)
+	if err != nil {
+		return err
+	}
+
+	b, err := exportEntries(entries, format, textSeparator())
+	if err != nil {
+		return err
+	}
+
+	err = os.WriteFile(fileName, b, 0o600)
+	if err != nil {
+		return fmt.Errorf("failed to write %v: %v", fileName, err.Error())
+	}
+
+	return nil
+}
+
+// Reads entries from fileName and merges them into the history. If format is
+// empty, it is determined from the file's extension. Returns a summary of the
+// import.
+func importFile(fileName, format string) (string, error) {
+	format, err := resolveFormat(format, fileName)
+	if err != nil {
+		return "", err
+	}
+
+	b, err := os.ReadFile(fileName)
+	if err != nil {
+		return "", fmt.Errorf("failed to read %v: %v", fileName, err.Error())
+	}
+
+	entries, err := importEntries(b, format, textSeparator())
+	if err != nil {
+		return "", err
+	}
+
+	added, kept := mergeEntries(entries)
+
+	return importMessage(len(entries), added, kept), nil
+}
+
+// Handles the -export and -import flags. If another instance is running, it
+// does the work so that its in-memory history stays in sync and isn't
+// overwritten when it exits. Otherwise the config file is modified directly.
+func runExportImportFlags() error {
+	cmd, fileName := "export", flagExport
+	if flagImport != "" {
+		cmd, fileName = "import", flagImport
+	}
+
+	fileName, err := filepath.Abs(fileName)
+	if err != nil {
+		return fmt.Errorf("failed to resolve %v: %v", fileName, err.Error())
+	}
+
+	format := flagFormat
+	if format == "" {
+		format = "auto"
+	}
+
+	resp, err := sendIPCCommand(fmt.Sprintf("%v %v %v", cmd, format, fileName))
+	if err == nil {
+		if strings.HasPrefix(resp, "error: ") {
+			return fmt.Errorf("%v", strings.TrimPrefix(resp, "error: "))
+		}
+
+		log.Println(resp)
+		return nil
+	}
+
+	log.Printf("no running instance found, using %v directly", configFilePath)
+
+	msg, err := handleExportImport(cmd, flagFormat, fileName, true)
+	if err != nil {
+		return err
+	}
+
+	log.Println(msg)
+
+	return nil
+}
+
+// Exports the whole history to, or imports it from, fileName and returns a
+// summary of what was done. If save is true, the config is saved after
+// importing.
+func handleExportImport(cmd, format, fileName string, save bool) (string, error) {
+	switch cmd {
+	case "export":
+		entries := appConf.Log
+		if storeEnabled() {
+			var err error
+			entries, err = storeLoad("", "", 0, -1)
+			if err != nil {
+				return "", err
+			}
+		}
+
+		err := exportFile(fileName, format, entries)
+		if err != nil {
+			return "", err
+		}
+
+		return fmt.Sprintf("exported %v entries to %v", len(entries), fileName), nil
+	case "import":
+		msg, err := importFile(fileName, format)
+		if err != nil {
+			return "", err
+		}
+
+		if save {
+			err = saveConfig(configFilePath, &appConf)
+			if err != nil {
+				return "", err
+			}
+		}
+
+		return fmt.Sprintf("%v from %v", msg, fileName), nil
+	}
+
+	return "", fmt.Errorf("unknown command %v", cmd)
+}
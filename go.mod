@@ -5,7 +5,23 @@ go 1.22.5
 require (
 	github.com/adrg/xdg v0.5.0
 	github.com/atotto/clipboard v0.1.4
+	github.com/godbus/dbus/v5 v5.1.0
+	github.com/hashicorp/mdns v1.0.5
 	github.com/pwiecz/go-fltk v0.0.0-20240525043121-5313f8a5a643
+	go.etcd.io/bbolt v1.3.11
+	modernc.org/sqlite v1.34.5
 )
 
-require golang.org/x/sys v0.22.0 // indirect
+require (
+	github.com/dustin/go-humanize v1.0.1 // indirect
+	github.com/google/uuid v1.6.0 // indirect
+	github.com/mattn/go-isatty v0.0.20 // indirect
+	github.com/miekg/dns v1.1.43 // indirect
+	github.com/ncruces/go-strftime v0.1.9 // indirect
+	github.com/remyoudompheng/bigfft v0.0.0-20230129092748-24d4a6f8daec // indirect
+	golang.org/x/net v0.27.0 // indirect
+	golang.org/x/sys v0.22.0 // indirect
+	modernc.org/libc v1.55.3 // indirect
+	modernc.org/mathutil v1.6.0 // indirect
+	modernc.org/memory v1.8.0 // indirect
+)
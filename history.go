@@ -0,0 +1,276 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"log"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	// The text currently entered in the search input. Only entries matching
+	// it are shown.
+	searchQuery string
+	// When using the sqlite store, the number of newer entries that are
+	// skipped when loading a page of history.
+	pageOffset int
+
+	ignoredCaptureMu sync.Mutex
+	// The hash of a value that the app placed on the clipboard itself, and
+	// that should not be captured as a new entry.
+	ignoredCapture string
+)
+
+// Prevents value from being captured the next time it is read from the
+// clipboard.
+func ignoreCapture(value string) {
+	ignoredCaptureMu.Lock()
+	defer ignoredCaptureMu.Unlock()
+
+	ignoredCapture = hashValue(value)
+}
+
+// Returns true if value was placed on the clipboard by the app and should not
+// be captured. Only the next captured value is checked, so copying the same
+// value again later is captured as usual.
+func captureIgnored(value string) bool {
+	ignoredCaptureMu.Lock()
+	defer ignoredCaptureMu.Unlock()
+
+	ignored := ignoredCapture != "" && ignoredCapture == hashValue(value)
+	ignoredCapture = ""
+
+	return ignored
+}
+
+// Returns a new random id for an entry.
+func newEntryID() string {
+	b := make([]byte, 16)
+	_, _ = rand.Read(b)
+
+	return hex.EncodeToString(b)
+}
+
+// Adds e to the end of the history.
+func appendEntry(e ClipboardEntry) {
+	appConf.Log = append(appConf.Log, e)
+	indexDedupeEntry(len(appConf.Log) - 1)
+	storePut(e)
+	syncLocal(e.ID, &e)
+}
+
+// Sorts the history by capture time, oldest first. Entries with the same time
+// keep their order.
+func sortHistory() {
+	slices.SortStableFunc(appConf.Log, func(a, b ClipboardEntry) int {
+		return a.Time.Compare(b.Time)
+	})
+	invalidateDedupeIndex()
+}
+
+// Adds value to the history as a new entry, or moves an existing duplicate of
+// it to the top. Returns false if the size policies rejected it.
+func addValue(value string) bool {
+	if i := findDuplicate(value, hashValue(value)); i >= 0 {
+		bumpEntry(i)
+		return true
+	}
+
+	e, ok := newEntry(value)
+	if !ok {
+		return false
+	}
+
+	e.Time = time.Now()
+	e.Count = 1
+	appendEntry(e)
+
+	return true
+}
+
+// Adds a captured value to the history with the provided tags, or moves an
+// existing duplicate of it to the top, including duplicates in the store that
+// aren't loaded. The hooks, notifications and event stream are then told
+// about it. Returns false if the size policies rejected the value.
+func captureValue(value string, tags []string) (ClipboardEntry, bool) {
+	hash := hashValue(value)
+
+	var e ClipboardEntry
+	isNew := false
+	if i := findDuplicate(value, hash); i >= 0 {
+		if i == len(appConf.Log)-1 && len(tags) == 0 {
+			// the value already is the newest entry
+			return appConf.Log[i], true
+		}
+
+		e = appConf.Log[i]
+		appConf.Log = slices.Delete(appConf.Log, i, i+1)
+		invalidateDedupeIndex()
+	} else if stored, ok := findStoredDuplicate(hash); ok {
+		e = stored
+	} else {
+		e, ok = newEntry(value)
+		if !ok {
+			return e, false
+		}
+		isNew = true
+	}
+
+	e.Time = time.Now()
+	e.Count = useCount(e)
+	if !isNew {
+		e.Count++
+	}
+	for _, t := range tags {
+		e.Tags = addTags(e.Tags, normalizeTag(t))
+	}
+
+	appendEntry(e)
+	runHooks(e)
+	if isNew {
+		notifyCaptured(e)
+	}
+	publishCapture(e)
+
+	return e, true
+}
+
+// Persists changes that were made to the entry at index i.
+func updateEntry(i int) {
+	invalidateDedupeIndex()
+	storePut(appConf.Log[i])
+	syncLocal(appConf.Log[i].ID, &appConf.Log[i])
+}
+
+// Deletes the entries at the provided indices in appConf.Log, along with any
+// blobs that are no longer referenced. The deletions are synced, so that other
+// instances don't keep entries that were evicted here.
+func removeEntries(indices []int) {
+	toDel := slices.Clone(indices)
+	slices.Sort(toDel)
+	slices.Reverse(toDel)
+
+	ids := []string{}
+	removed := []ClipboardEntry{}
+	for _, i := range toDel {
+		e := appConf.Log[i]
+		appConf.Log = append(appConf.Log[:i], appConf.Log[i+1:]...)
+		ids = append(ids, e.ID)
+		removed = append(removed, e)
+	}
+	invalidateDedupeIndex()
+
+	// blobs are only removed once the entries are gone from the store, since
+	// the store is also checked for references
+	storeDelete(ids)
+	for _, e := range removed {
+		removeBlob(e, appConf.Log)
+		syncLocal(e.ID, nil)
+	}
+}
+
+// Deletes entries from the store, including those that aren't in the loaded
+// page, along with any blobs that are no longer referenced.
+func removeStoredEntries(entries []ClipboardEntry) {
+	ids := make([]string, len(entries))
+	for i, e := range entries {
+		ids[i] = e.ID
+	}
+
+	storeDelete(ids)
+	appConf.Log = slices.DeleteFunc(appConf.Log, func(e ClipboardEntry) bool {
+		return slices.Contains(ids, e.ID)
+	})
+	invalidateDedupeIndex()
+
+	for _, e := range entries {
+		removeBlob(e, appConf.Log)
+		syncLocal(e.ID, nil)
+	}
+}
+
+// Deletes every entry that isn't pinned, moving them to the trash. Returns the
+// number of deleted entries.
+func clearHistory() int {
+	entries := appConf.Log
+	if storeEnabled() {
+		var err error
+		entries, err = storeLoad("", "", 0, -1)
+		if err != nil {
+			log.Printf("failed to clear history: %v", err.Error())
+			return 0
+		}
+	}
+
+	toDel := []ClipboardEntry{}
+	for _, e := range entries {
+		if !e.Pinned {
+			toDel = append(toDel, e)
+		}
+	}
+	deleteEntries(UNDO_CLEAR, toDel)
+
+	return len(toDel)
+}
+
+// When using the sqlite store, replaces the in-memory log with the page of
+// entries matching the current search query, tag filter and page offset. Does
+// nothing otherwise, since the whole history is already in memory.
+func loadHistory() {
+	if !storeEnabled() {
+		return
+	}
+
+	entries, err := storeLoad(searchQuery, tagFilter, pageOffset, appConf.MaxEntries)
+	if err != nil {
+		log.Printf("failed to load history: %v", err.Error())
+		return
+	}
+
+	appConf.Log = entries
+	invalidateDedupeIndex()
+}
+
+// Moves to an older (positive) or newer (negative) page of history. Only
+// applies when using the sqlite store.
+func changePage(delta int) {
+	if !storeEnabled() {
+		return
+	}
+
+	total, _ := storeStats(searchQuery, tagFilter)
+	offset := pageOffset + delta*appConf.MaxEntries
+	if offset < 0 || offset >= total {
+		return
+	}
+
+	pageOffset = offset
+	loadHistory()
+	reconstruct()
+}
+
+// Sets the search query and reloads the history to match it.
+func search(q string) {
+	searchQuery = q
+	pageOffset = 0
+	loadHistory()
+	reconstruct()
+}
+
+// Returns true if e should be shown for the current search query and tag
+// filter. When using the sqlite store, they have already been applied when
+// loading history.
+func matchesSearch(e ClipboardEntry) bool {
+	if !matchesTag(e) {
+		return false
+	}
+
+	if searchQuery == "" || storeEnabled() {
+		return true
+	}
+
+	return strings.Contains(strings.ToLower(e.Value), strings.ToLower(searchQuery))
+}
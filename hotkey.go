@@ -0,0 +1,93 @@
+package main
+
+import (
+	"log"
+
+	"github.com/pwiecz/go-fltk"
+)
+
+const (
+	// Disables the global hotkey.
+	HOTKEY_NONE = "none"
+
+	// The hotkey is grabbed for the whole display, so it is only registered
+	// when it is set in the config.
+	DEFAULT_HOTKEY = HOTKEY_NONE
+
+	// The window is shown with its top left corner at the mouse pointer.
+	HOTKEY_POSITION_CURSOR = "cursor"
+	// The window is shown centered on the monitor containing the mouse
+	// pointer.
+	HOTKEY_POSITION_CENTER = "center"
+
+	DEFAULT_HOTKEY_POSITION = HOTKEY_POSITION_CURSOR
+)
+
+// Whether the window is currently shown because of the global hotkey, in which
+// case it is hidden again after copying.
+var shownByHotkey bool
+
+// Registers the configured global hotkey, if any.
+func setupHotkey() {
+	if appConf.Hotkey == HOTKEY_NONE {
+		return
+	}
+
+	err := grabHotkey(appConf.Hotkey, func(x, y int) {
+		// widgets may only be modified from the ui thread
+		fltk.Awake(func() {
+			toggleWindow(x, y)
+		})
+	})
+	if err != nil {
+		log.Printf("failed to register global hotkey: %v", err.Error())
+		return
+	}
+
+	log.Printf("registered global hotkey %v", appConf.Hotkey)
+}
+
+// Hides the window if it is visible. Otherwise shows it at the position
+// configured for the hotkey, relative to the pointer at x and y, and focuses
+// the search input.
+func toggleWindow(x, y int) {
+	if win.IsShown() && win.Visible() {
+		shownByHotkey = false
+		forgetActiveWindow()
+		win.Hide()
+		return
+	}
+
+	w, h := win.W(), win.H()
+	sx, sy, sw, sh := fltk.ScreenWorkArea(fltk.ScreenNum(x, y))
+
+	if appConf.HotkeyPosition == HOTKEY_POSITION_CENTER {
+		x = sx + (sw-w)/2
+		y = sy + (sh-h)/2
+	}
+
+	// keep the window entirely on the monitor
+	x = max(sx, min(x, sx+sw-w))
+	y = max(sy, min(y, sy+sh-h))
+
+	rememberActiveWindow()
+
+	win.Resize(x, y, w, h)
+	win.Show()
+	shownByHotkey = true
+
+	if currentPage == PAGE_MAIN {
+		searchInput.TakeFocus()
+	}
+}
+
+// Hides the window after copying, if it was shown by the global hotkey.
+func hideAfterCopy() {
+	if !shownByHotkey {
+		return
+	}
+
+	shownByHotkey = false
+	forgetActiveWindow()
+	win.Hide()
+}
@@ -0,0 +1,127 @@
+//go:build linux
+
+package main
+
+/*
+#cgo LDFLAGS: -lX11
+#include <stdlib.h>
+#include <X11/Xlib.h>
+#include <X11/keysym.h>
+
+static int hotkeyGrabFailed;
+
+static int hotkeyErrorHandler(Display *d, XErrorEvent *e) {
+	hotkeyGrabFailed = 1;
+	return 0;
+}
+
+// Grabs the key on the root window, ignoring the num lock and caps lock
+// modifiers. Returns 0 on success.
+static int hotkeyGrab(Display *d, const char *key, unsigned int modifiers) {
+	KeySym sym = XStringToKeysym(key);
+	if (sym == NoSymbol) {
+		return 1;
+	}
+
+	KeyCode code = XKeysymToKeycode(d, sym);
+	if (code == 0) {
+		return 1;
+	}
+
+	unsigned int ignored[] = {0, LockMask, Mod2Mask, LockMask | Mod2Mask};
+	Window root = DefaultRootWindow(d);
+
+	XSync(d, False);
+	hotkeyGrabFailed = 0;
+	int (*previous)(Display *, XErrorEvent *) = XSetErrorHandler(hotkeyErrorHandler);
+	for (int i = 0; i < 4; i++) {
+		XGrabKey(d, code, modifiers | ignored[i], root, True, GrabModeAsync, GrabModeAsync);
+	}
+	XSync(d, False);
+	XSetErrorHandler(previous);
+
+	return hotkeyGrabFailed ? 2 : 0;
+}
+
+// Blocks until the grabbed key is pressed, and stores the pointer position at
+// the time.
+static void hotkeyWait(Display *d, int *x, int *y) {
+	XEvent e;
+	for (;;) {
+		XNextEvent(d, &e);
+		if (e.type == KeyPress) {
+			*x = e.xkey.x_root;
+			*y = e.xkey.y_root;
+			return;
+		}
+	}
+}
+*/
+import "C"
+
+import (
+	"fmt"
+	"strings"
+	"unsafe"
+)
+
+// Registers spec (such as ctrl+alt+v) as a global hotkey on the X11 display,
+// calling fn with the pointer's position each time it is pressed. fn is
+// called from a background goroutine.
+func grabHotkey(spec string, fn func(x, y int)) error {
+	parts := strings.Split(spec, "+")
+	key := strings.TrimSpace(parts[len(parts)-1])
+	if key == "" {
+		return fmt.Errorf("no key in hotkey %v", spec)
+	}
+
+	// keysyms of letters are case sensitive, and upper case ones need shift
+	if len(key) == 1 {
+		key = strings.ToLower(key)
+	}
+
+	var modifiers C.uint
+	for _, m := range parts[:len(parts)-1] {
+		switch strings.ToLower(strings.TrimSpace(m)) {
+		case "ctrl", "control":
+			modifiers |= C.ControlMask
+		case "shift":
+			modifiers |= C.ShiftMask
+		case "alt":
+			modifiers |= C.Mod1Mask
+		case "super", "win", "meta":
+			modifiers |= C.Mod4Mask
+		default:
+			return fmt.Errorf("unknown modifier %v in hotkey %v", m, spec)
+		}
+	}
+
+	// a separate connection is used, since it blocks while waiting for events
+	d := C.XOpenDisplay(nil)
+	if d == nil {
+		return fmt.Errorf("failed to open X11 display")
+	}
+
+	cKey := C.CString(key)
+	defer C.free(unsafe.Pointer(cKey))
+
+	switch C.hotkeyGrab(d, cKey, modifiers) {
+	case 0:
+	case 1:
+		C.XCloseDisplay(d)
+		return fmt.Errorf("unknown key %v in hotkey %v", key, spec)
+	default:
+		C.XCloseDisplay(d)
+		return fmt.Errorf("hotkey %v is already in use by another app", spec)
+	}
+
+	go func() {
+		for {
+			var x, y C.int
+			C.hotkeyWait(d, &x, &y)
+			fn(int(x), int(y))
+		}
+	}()
+
+	return nil
+}
@@ -0,0 +1,10 @@
+//go:build !linux
+
+package main
+
+import "fmt"
+
+// Global hotkeys are only supported on X11.
+func grabHotkey(spec string, fn func(x, y int)) error {
+	return fmt.Errorf("global hotkeys are not supported on this platform")
+}
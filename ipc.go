@@ -0,0 +1,193 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/adrg/xdg"
+	"github.com/pwiecz/go-fltk"
+)
+
+// How long a client waits for a running instance to respond to a command.
+const IPC_TIMEOUT = 5 * time.Second
+
+// The listener that receives commands from other invocations of this app, such
+// as `go-fltk-clipboard -cmd "pause 5m"`.
+var ipcListener net.Listener
+
+// Returns the path to the unix socket that a running instance listens on.
+func ipcSocketPath() (string, error) {
+	return xdg.RuntimeFile("go-fltk-clipboard/ipc.sock")
+}
+
+// Sends a single-line command to a running instance and returns its response.
+func sendIPCCommand(cmd string) (string, error) {
+	p, err := ipcSocketPath()
+	if err != nil {
+		return "", fmt.Errorf("failed to get ipc socket path: %v", err.Error())
+	}
+
+	conn, err := net.DialTimeout("unix", p, IPC_TIMEOUT)
+	if err != nil {
+		return "", fmt.Errorf("failed to connect to running instance at %v: %v", p, err.Error())
+	}
+	defer conn.Close()
+
+	_ = conn.SetDeadline(time.Now().Add(IPC_TIMEOUT))
+
+	_, err = fmt.Fprintf(conn, "%v\n", strings.TrimSpace(cmd))
+	if err != nil {
+		return "", fmt.Errorf("failed to send command: %v", err.Error())
+	}
+
+	resp, err := bufio.NewReader(conn).ReadString('\n')
+	if err != nil {
+		return "", fmt.Errorf("failed to read response: %v", err.Error())
+	}
+
+	return strings.TrimSuffix(resp, "\n"), nil
+}
+
+// Starts listening for commands from other invocations of this app. Each
+// command is a single line, and handler's return value is written back as the
+// response.
+func listenIPC(handler func(cmd string) string) error {
+	p, err := ipcSocketPath()
+	if err != nil {
+		return fmt.Errorf("failed to get ipc socket path: %v", err.Error())
+	}
+
+	// if the socket already exists, either another instance is running or a
+	// previous instance didn't clean up after itself
+	if _, err := os.Stat(p); err == nil {
+		conn, err := net.DialTimeout("unix", p, IPC_TIMEOUT)
+		if err == nil {
+			conn.Close()
+			return fmt.Errorf("another instance is already listening on %v", p)
+		}
+
+		err = os.Remove(p)
+		if err != nil {
+			return fmt.Errorf("failed to remove stale ipc socket %v: %v", p, err.Error())
+		}
+	}
+
+	ipcListener, err = net.Listen("unix", p)
+	if err != nil {
+		return fmt.Errorf("failed to listen on %v: %v", p, err.Error())
+	}
+
+	log.Printf("listening for commands on %v", p)
+
+	go func() {
+		for {
+			conn, err := ipcListener.Accept()
+			if err != nil {
+				return
+			}
+
+			go func(conn net.Conn) {
+				defer conn.Close()
+				_ = conn.SetDeadline(time.Now().Add(IPC_TIMEOUT))
+
+				cmd, err := bufio.NewReader(conn).ReadString('\n')
+				if err != nil {
+					return
+				}
+
+				resp := handler(strings.TrimSpace(cmd))
+				_, _ = fmt.Fprintf(conn, "%v\n", strings.ReplaceAll(resp, "\n", " "))
+			}(conn)
+		}
+	}()
+
+	return nil
+}
+
+// Stops listening for commands and removes the socket.
+func closeIPC() {
+	if ipcListener == nil {
+		return
+	}
+
+	err := ipcListener.Close()
+	if err != nil {
+		log.Printf("failed to close ipc listener: %v", err.Error())
+	}
+}
+
+// Handles a command received from another invocation of this app.
+func handleIPCCommand(cmd string) string {
+	fields := strings.Fields(cmd)
+	if len(fields) == 0 {
+		return "error: empty command"
+	}
+
+	switch fields[0] {
+	case "pause":
+		var d time.Duration
+		if len(fields) > 1 {
+			var err error
+			d, err = time.ParseDuration(fields[1])
+			if err != nil {
+				return fmt.Sprintf("error: invalid duration %v: %v", fields[1], err.Error())
+			}
+		}
+
+		// the pause state is saved in the config, which the ui thread owns
+		onUIThread(func() { pauseCapture(d) })
+		return pauseStatus()
+	case "resume":
+		onUIThread(resumeCapture)
+		return pauseStatus()
+	case "toggle":
+		onUIThread(togglePause)
+		return pauseStatus()
+	case "export", "import":
+		// the path is the remainder of the command, since it may contain
+		// spaces
+		parts := strings.SplitN(cmd, " ", 3)
+		if len(parts) != 3 {
+			return fmt.Sprintf("error: usage: %v <format|auto> <path>", fields[0])
+		}
+
+		format := parts[1]
+		if format == "auto" {
+			format = ""
+		}
+
+		// the history is only changed on the ui thread
+		var msg string
+		var err error
+		onUIThread(func() {
+			msg, err = handleExportImport(fields[0], format, parts[2], false)
+			if err == nil && fields[0] == "import" {
+				reconstruct()
+			}
+		})
+		if err != nil {
+			return fmt.Sprintf("error: %v", err.Error())
+		}
+
+		return msg
+	case "window":
+		// the pointer position isn't known, so this behaves as if the
+		// pointer was in the middle of the first monitor
+		fltk.Awake(func() {
+			x, y, w, h := fltk.ScreenWorkArea(0)
+			toggleWindow(x+w/2, y+h/2)
+		})
+		return "ok"
+	case "status":
+		// isPaused resumes capture if a timed pause expired
+		onUIThread(func() { isPaused() })
+		return pauseStatus()
+	default:
+		return fmt.Sprintf("error: unknown command %v", fields[0])
+	}
+}
@@ -0,0 +1,321 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/atotto/clipboard"
+	"github.com/pwiecz/go-fltk"
+)
+
+const (
+	// Named separators for joining multiple copied entries. Any other value is
+	// used literally, after replacing \n and \t with a newline and a tab.
+	SEPARATOR_NEWLINE = "newline"
+	SEPARATOR_BLANK   = "blank"
+	SEPARATOR_COMMA   = "comma"
+	SEPARATOR_TAB     = "tab"
+	// Entries are joined without anything between them. An empty custom
+	// separator means the default, so this is the explicit way to set it.
+	SEPARATOR_NONE = "none"
+
+	// Entries are joined in the order they were selected in.
+	ORDER_SELECTION = "selection"
+	// Entries are joined oldest first.
+	ORDER_CHRONOLOGICAL = "chronological"
+	// Entries are joined newest first, as they are shown in the history.
+	ORDER_REVERSE = "reverse"
+
+	// Entries are joined using the separator.
+	OUTPUT_TEXT = "text"
+	// Entries are copied as a JSON array of strings.
+	OUTPUT_JSON = "json"
+	// Entries are single-quoted and joined with spaces, for use as shell
+	// arguments.
+	OUTPUT_SHELL = "shell"
+
+	DEFAULT_SEPARATOR = SEPARATOR_NEWLINE
+	DEFAULT_ORDER     = ORDER_REVERSE
+	DEFAULT_OUTPUT    = OUTPUT_TEXT
+)
+
+// Controls how multiple selected entries are combined when copying them.
+type CopyFormat struct {
+	// newline, blank, comma, tab, none, or a custom string.
+	Separator string `json:"separator"`
+	// selection, chronological or reverse.
+	Order string `json:"order"`
+	// Added before and after each entry. {n} is replaced with the entry's
+	// position (starting at 1), {total} with the number of entries, and {time}
+	// with the time the entry was captured.
+	Prefix string `json:"prefix"`
+	Suffix string `json:"suffix"`
+	// text, json or shell.
+	Output string `json:"output"`
+}
+
+// The ids of the selected entries, in the order they were selected in.
+var selectionOrder []string
+
+// Fills in unset fields of f with their defaults.
+func (f *CopyFormat) setDefaults() {
+	if f.Separator == "" {
+		f.Separator = DEFAULT_SEPARATOR
+	}
+	if f.Order == "" {
+		f.Order = DEFAULT_ORDER
+	}
+	if f.Output == "" {
+		f.Output = DEFAULT_OUTPUT
+	}
+}
+
+// Returns the string that entries are joined with.
+func (f CopyFormat) separator() string {
+	switch f.Separator {
+	case SEPARATOR_NEWLINE, "":
+		return "\n"
+	case SEPARATOR_BLANK:
+		return "\n\n"
+	case SEPARATOR_COMMA:
+		return ","
+	case SEPARATOR_TAB:
+		return "\t"
+	case SEPARATOR_NONE:
+		return ""
+	}
+
+	return strings.NewReplacer(`\n`, "\n", `\t`, "\t").Replace(f.Separator)
+}
+
+// Updates selectionOrder from the entries currently selected in the log
+// browser. Newly selected entries are added to the end, and deselected
+// entries are removed.
+func trackSelection() {
+	selected := make(map[string]bool)
+	for _, j := range selectedIndices() {
+		selected[appConf.Log[j].ID] = true
+	}
+
+	selectionOrder = slices.DeleteFunc(selectionOrder, func(id string) bool {
+		return !selected[id]
+	})
+
+	for _, j := range selectedIndices() {
+		id := appConf.Log[j].ID
+		if !slices.Contains(selectionOrder, id) {
+			selectionOrder = append(selectionOrder, id)
+		}
+	}
+}
+
+// Returns the indices in appConf.Log of the selected entries, in the order
+// that f specifies.
+func orderedSelection(f CopyFormat) []int {
+	indices := selectedIndices()
+
+	switch f.Order {
+	case ORDER_CHRONOLOGICAL:
+		return indices
+	case ORDER_SELECTION:
+		// entries selected without a callback (such as by select all) are
+		// added after the others, oldest first
+		rank := func(j int) int {
+			r := slices.Index(selectionOrder, appConf.Log[j].ID)
+			if r < 0 {
+				return len(selectionOrder) + j
+			}
+			return r
+		}
+		slices.SortStableFunc(indices, func(a, b int) int {
+			return rank(a) - rank(b)
+		})
+		return indices
+	}
+
+	slices.Reverse(indices)
+
+	return indices
+}
+
+// Quotes s so that a POSIX shell treats it as a single argument.
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+// Combines the values of the entries at the provided indices in appConf.Log
+// according to f.
+func joinEntries(indices []int, f CopyFormat) (string, error) {
+	values := make([]string, len(indices))
+	for n, j := range indices {
+		e := appConf.Log[j]
+		t := ""
+		if !e.Time.IsZero() {
+			t = e.Time.Format(time.RFC3339)
+		}
+
+		r := strings.NewReplacer("{n}", fmt.Sprint(n+1), "{total}", fmt.Sprint(len(indices)), "{time}", t)
+		values[n] = r.Replace(f.Prefix) + entryValue(e) + r.Replace(f.Suffix)
+	}
+
+	switch f.Output {
+	case OUTPUT_JSON:
+		b := new(bytes.Buffer)
+		enc := json.NewEncoder(b)
+		enc.SetEscapeHTML(false)
+		err := enc.Encode(values)
+		if err != nil {
+			return "", fmt.Errorf("failed to encode entries as json: %v", err.Error())
+		}
+		return strings.TrimSuffix(b.String(), "\n"), nil
+	case OUTPUT_SHELL:
+		for i, v := range values {
+			values[i] = shellQuote(v)
+		}
+		return strings.Join(values, " "), nil
+	}
+
+	return strings.Join(values, f.separator()), nil
+}
+
+// Copies the selected entries to the clipboard, combined according to f, and
+// then deselects them.
+func copySelected(f CopyFormat) {
+	l := len(appConf.Log)
+	indices := orderedSelection(f)
+	if len(indices) == 0 {
+		log.Println("nothing to copy")
+		return
+	}
+
+	result, err := joinEntries(indices, f)
+	if err != nil {
+		fltk.MessageBox("Error", err.Error())
+		return
+	}
+
+	total := 0
+	for i := 1; i <= len(rows); i++ {
+		j := rowEntry(i)
+		appConf.Log[j].Selected = false
+		logBrowser.SetSelected(i, false)
+		total += entrySize(appConf.Log[j])
+	}
+	selectionOrder = nil
+
+	msg := fmt.Sprintf("%v/%v items copied, %v bytes (%v bytes in history)", len(indices), l, len(result), total)
+	setStatus(msg)
+	log.Println(msg)
+
+	if len(indices) > 1 {
+		notify(NOTIFY_COPY, fmt.Sprintf("Copied %v entries", len(indices)), notifyPreview(result))
+	}
+
+	err = clipboard.WriteAll(result)
+	if err != nil {
+		fltk.MessageBox("Error", fmt.Sprintf("Failed to write to clipboard: %v", err.Error()))
+		return
+	}
+
+	if autoPasteEnabled() {
+		autoPaste()
+		return
+	}
+
+	hideAfterCopy()
+}
+
+// Shows a dialog for choosing how to combine the selected entries before
+// copying them. The chosen format can optionally become the default.
+func copyAsDialog() {
+	if len(selectedIndices()) == 0 {
+		setStatus("nothing selected to copy")
+		return
+	}
+
+	f := appConf.CopyFormat
+	f.setDefaults()
+
+	separators := []string{SEPARATOR_NEWLINE, SEPARATOR_BLANK, SEPARATOR_COMMA, SEPARATOR_TAB, SEPARATOR_NONE}
+	orders := []string{ORDER_REVERSE, ORDER_CHRONOLOGICAL, ORDER_SELECTION}
+	outputs := []string{OUTPUT_TEXT, OUTPUT_JSON, OUTPUT_SHELL}
+
+	dialog := fltk.NewWindow(320, 300, "Copy As")
+	dialog.SetModal()
+
+	separatorChoice := fltk.NewChoice(10, 25, 145, 25, "&Separator")
+	separatorChoice.SetAlign(fltk.ALIGN_TOP_LEFT)
+	for _, s := range []string{"Newline", "Blank Line", "Comma", "Tab", "None", "Custom"} {
+		separatorChoice.Add(s, func() {})
+	}
+	customInput := fltk.NewInput(165, 25, 145, 25, "C&ustom Separator")
+	customInput.SetAlign(fltk.ALIGN_TOP_LEFT)
+	if i := slices.Index(separators, f.Separator); i >= 0 {
+		separatorChoice.SetValue(i)
+	} else {
+		separatorChoice.SetValue(len(separators))
+		customInput.SetValue(f.Separator)
+	}
+
+	orderChoice := fltk.NewChoice(10, 75, 145, 25, "&Order")
+	orderChoice.SetAlign(fltk.ALIGN_TOP_LEFT)
+	for _, s := range []string{"Newest First", "Oldest First", "Selection Order"} {
+		orderChoice.Add(s, func() {})
+	}
+	orderChoice.SetValue(max(slices.Index(orders, f.Order), 0))
+
+	outputChoice := fltk.NewChoice(165, 75, 145, 25, "O&utput")
+	outputChoice.SetAlign(fltk.ALIGN_TOP_LEFT)
+	for _, s := range []string{"Text", "JSON Array", "Shell Arguments"} {
+		outputChoice.Add(s, func() {})
+	}
+	outputChoice.SetValue(max(slices.Index(outputs, f.Output), 0))
+
+	prefixInput := fltk.NewInput(10, 125, 300, 25, "&Prefix ({n}, {total}, {time})")
+	prefixInput.SetAlign(fltk.ALIGN_TOP_LEFT)
+	prefixInput.SetValue(f.Prefix)
+	suffixInput := fltk.NewInput(10, 175, 300, 25, "Su&ffix")
+	suffixInput.SetAlign(fltk.ALIGN_TOP_LEFT)
+	suffixInput.SetValue(f.Suffix)
+
+	defaultBtn := fltk.NewCheckButton(10, 210, 300, 25, "&Make Default")
+	cancelBtn := fltk.NewButton(10, 260, 145, 30, "Cancel")
+	copyBtn := fltk.NewButton(165, 260, 145, 30, "&Copy")
+	dialog.End()
+
+	cancelBtn.SetCallback(func() {
+		dialog.Hide()
+		dialog.Destroy()
+	})
+
+	copyBtn.SetCallback(func() {
+		if i := separatorChoice.Value(); i >= 0 && i < len(separators) {
+			f.Separator = separators[i]
+		} else {
+			f.Separator = customInput.Value()
+			// an empty separator would be replaced by the default
+			if f.Separator == "" {
+				f.Separator = SEPARATOR_NONE
+			}
+		}
+		f.Order = orders[max(orderChoice.Value(), 0)]
+		f.Output = outputs[max(outputChoice.Value(), 0)]
+		f.Prefix = prefixInput.Value()
+		f.Suffix = suffixInput.Value()
+
+		if defaultBtn.Value() {
+			appConf.CopyFormat = f
+		}
+
+		dialog.Hide()
+		dialog.Destroy()
+		copySelected(f)
+	})
+
+	dialog.Show()
+}
@@ -0,0 +1,34 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestCopyFormatSeparator(t *testing.T) {
+	tests := []struct {
+		separator string
+		want      string
+	}{
+		{"", "\n"},
+		{SEPARATOR_NEWLINE, "\n"},
+		{SEPARATOR_BLANK, "\n\n"},
+		{SEPARATOR_COMMA, ","},
+		{SEPARATOR_TAB, "\t"},
+		{SEPARATOR_NONE, ""},
+		{` | `, " | "},
+		{`\n--\n`, "\n--\n"},
+	}
+
+	for _, test := range tests {
+		if got := (CopyFormat{Separator: test.separator}).separator(); got != test.want {
+			t.Errorf("separator %q joins with %q, want %q", test.separator, got, test.want)
+		}
+	}
+
+	// setDefaults keeps an explicit empty separator
+	f := CopyFormat{Separator: SEPARATOR_NONE}
+	f.setDefaults()
+	if f.separator() != "" {
+		t.Errorf("setDefaults replaced the none separator with %q", f.separator())
+	}
+}
@@ -0,0 +1,567 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"maps"
+	"slices"
+	"strings"
+
+	"github.com/pwiecz/go-fltk"
+)
+
+const (
+	// Names of the actions that can be bound to keys.
+	KEY_COPY        = "copy"
+	KEY_COPY_AS     = "copy-as"
+	KEY_COPY_JSON   = "copy-json"
+	KEY_PASTE_QUEUE = "paste-queue"
+	KEY_PASTE_STACK = "paste-stack"
+	KEY_TRANSFORM   = "transform"
+	KEY_ACTIONS     = "actions"
+	KEY_DELETE      = "delete"
+	KEY_UNDO        = "undo"
+	KEY_REDO        = "redo"
+	KEY_TRASH       = "trash"
+	KEY_EDIT        = "edit"
+	KEY_SAVE        = "save"
+	KEY_SELECT_ALL  = "select-all"
+	KEY_PAUSE       = "pause"
+	KEY_TIMED_PAUSE = "timed-pause"
+	KEY_PIN         = "pin"
+	KEY_TAG         = "tag"
+	KEY_TAG_FILTER  = "tag-filter"
+	KEY_EXPORT      = "export"
+	KEY_IMPORT      = "import"
+	KEY_SEARCH      = "search"
+	KEY_NEWER_PAGE  = "newer-page"
+	KEY_OLDER_PAGE  = "older-page"
+	KEY_HOME        = "home"
+	KEY_PAGE_UP     = "page-up"
+	KEY_PAGE_DOWN   = "page-down"
+	KEY_END         = "end"
+	KEY_NEXT        = "next"
+	KEY_PREVIOUS    = "previous"
+	KEY_QUIT        = "quit"
+
+	// Leaves an action without a key.
+	KEY_NONE = "none"
+
+	KEY_PRESET_DEFAULT = "default"
+	KEY_PRESET_VIM     = "vim"
+	KEY_PRESET_EMACS   = "emacs"
+
+	DEFAULT_KEY_PRESET = KEY_PRESET_DEFAULT
+)
+
+// An action that can be bound to a key, in the order they are listed in.
+type keyAction struct {
+	Name  string
+	Label string
+}
+
+var keyActions = []keyAction{
+	{KEY_COPY, "Copy"},
+	{KEY_COPY_AS, "Copy As"},
+	{KEY_COPY_JSON, "Copy As JSON"},
+	{KEY_PASTE_QUEUE, "Paste Queue"},
+	{KEY_PASTE_STACK, "Paste Stack"},
+	{KEY_TRANSFORM, "Transform"},
+	{KEY_ACTIONS, "Actions"},
+	{KEY_DELETE, "Delete"},
+	{KEY_UNDO, "Undo"},
+	{KEY_REDO, "Redo"},
+	{KEY_TRASH, "Trash"},
+	{KEY_EDIT, "Edit"},
+	{KEY_SAVE, "Save"},
+	{KEY_SELECT_ALL, "Select All"},
+	{KEY_PAUSE, "Pause"},
+	{KEY_TIMED_PAUSE, "Timed Pause"},
+	{KEY_PIN, "Pin"},
+	{KEY_TAG, "Add Tags"},
+	{KEY_TAG_FILTER, "Filter By Tag"},
+	{KEY_EXPORT, "Export"},
+	{KEY_IMPORT, "Import"},
+	{KEY_SEARCH, "Search"},
+	{KEY_NEWER_PAGE, "Newer Page"},
+	{KEY_OLDER_PAGE, "Older Page"},
+	{KEY_HOME, "First Entry"},
+	{KEY_END, "Last Entry"},
+	{KEY_PAGE_UP, "Page Up"},
+	{KEY_PAGE_DOWN, "Page Down"},
+	{KEY_NEXT, "Next Entry"},
+	{KEY_PREVIOUS, "Previous Entry"},
+	{KEY_QUIT, "Quit"},
+}
+
+var defaultKeybindings = map[string]string{
+	KEY_COPY:        "ctrl+c",
+	KEY_COPY_AS:     "ctrl+shift+c",
+	KEY_COPY_JSON:   "ctrl+alt+c",
+	KEY_PASTE_QUEUE: "ctrl+u",
+	KEY_PASTE_STACK: "ctrl+shift+u",
+	KEY_TRANSFORM:   "ctrl+r",
+	KEY_ACTIONS:     "ctrl+k",
+	KEY_DELETE:      "delete",
+	KEY_UNDO:        "ctrl+z",
+	KEY_REDO:        "ctrl+shift+z",
+	KEY_TRASH:       "ctrl+shift+delete",
+	KEY_EDIT:        "f2",
+	KEY_SAVE:        "ctrl+s",
+	KEY_SELECT_ALL:  "ctrl+a",
+	KEY_PAUSE:       "ctrl+p",
+	KEY_TIMED_PAUSE: "ctrl+shift+p",
+	KEY_PIN:         "ctrl+t",
+	KEY_TAG:         "ctrl+g",
+	KEY_TAG_FILTER:  "ctrl+shift+g",
+	KEY_EXPORT:      "ctrl+e",
+	KEY_IMPORT:      "ctrl+i",
+	KEY_SEARCH:      "ctrl+f",
+	KEY_NEWER_PAGE:  "ctrl+page_up",
+	KEY_OLDER_PAGE:  "ctrl+page_down",
+	KEY_HOME:        "home",
+	KEY_END:         "end",
+	KEY_PAGE_UP:     "page_up",
+	KEY_PAGE_DOWN:   "page_down",
+	KEY_NEXT:        "alt+down",
+	KEY_PREVIOUS:    "alt+up",
+	KEY_QUIT:        "ctrl+q",
+}
+
+// The keys that each preset changes from the defaults. Keys that type text,
+// such as single letters, are dispatched by the history rather than the
+// window, so they only apply while the history has focus.
+var keyPresets = map[string]map[string]string{
+	KEY_PRESET_DEFAULT: {},
+	KEY_PRESET_VIM: {
+		KEY_COPY:       "y",
+		KEY_DELETE:     "x",
+		KEY_UNDO:       "u",
+		KEY_EDIT:       "i",
+		KEY_PIN:        "m",
+		KEY_SELECT_ALL: "shift+v",
+		KEY_SEARCH:     "/",
+		KEY_NEWER_PAGE: "ctrl+b",
+		KEY_OLDER_PAGE: "ctrl+f",
+		KEY_HOME:       "g",
+		KEY_END:        "shift+g",
+		KEY_NEXT:       "j",
+		KEY_PREVIOUS:   "k",
+	},
+	KEY_PRESET_EMACS: {
+		KEY_COPY:        "alt+w",
+		KEY_DELETE:      "ctrl+w",
+		KEY_UNDO:        "ctrl+/",
+		KEY_EDIT:        "alt+e",
+		KEY_SAVE:        "ctrl+shift+s",
+		KEY_PAUSE:       "ctrl+alt+p",
+		KEY_TIMED_PAUSE: "ctrl+alt+shift+p",
+		KEY_SEARCH:      "ctrl+s",
+		KEY_NEWER_PAGE:  "alt+v",
+		KEY_OLDER_PAGE:  "ctrl+v",
+		KEY_HOME:        "alt+shift+,",
+		KEY_END:         "alt+shift+.",
+		KEY_NEXT:        "ctrl+n",
+		KEY_PREVIOUS:    "ctrl+p",
+	},
+}
+
+// The preset names, in the order they are listed in.
+var keyPresetNames = []string{KEY_PRESET_DEFAULT, KEY_PRESET_VIM, KEY_PRESET_EMACS}
+
+// Names of keys that aren't a single character.
+var keyNames = map[string]int{
+	"escape":    fltk.ESCAPE,
+	"tab":       fltk.TAB,
+	"enter":     fltk.ENTER_KEY,
+	"home":      fltk.HOME,
+	"left":      fltk.LEFT,
+	"up":        fltk.UP,
+	"right":     fltk.RIGHT,
+	"down":      fltk.DOWN,
+	"page_up":   fltk.PAGE_UP,
+	"page_down": fltk.PAGE_DOWN,
+	"end":       fltk.END,
+	"delete":    fltk.DELETE,
+	"backspace": fltk.BACKSPACE,
+	"insert":    fltk.INSERT,
+	"space":     ' ',
+	"f1":        fltk.F1,
+	"f2":        fltk.F2,
+	"f3":        fltk.F3,
+	"f4":        fltk.F4,
+	"f5":        fltk.F5,
+	"f6":        fltk.F6,
+	"f7":        fltk.F7,
+	"f8":        fltk.F8,
+	"f9":        fltk.F9,
+	"f10":       fltk.F10,
+	"f11":       fltk.F11,
+	"f12":       fltk.F12,
+}
+
+// Modifier names in the order they are written in.
+var keyModifiers = []struct {
+	Name string
+	Mask int
+}{
+	{"ctrl", fltk.CTRL},
+	{"alt", fltk.ALT},
+	{"shift", fltk.SHIFT},
+	{"super", fltk.META},
+}
+
+// Converts a key such as ctrl+shift+c or alt+page_down to an fltk shortcut.
+func parseShortcut(spec string) (int, error) {
+	parts := strings.Split(strings.ToLower(strings.TrimSpace(spec)), "+")
+	key := parts[len(parts)-1]
+	// allows binding the plus key itself, as in ctrl++
+	if key == "" && len(parts) > 1 && parts[len(parts)-2] == "" {
+		parts = parts[:len(parts)-1]
+		key = "+"
+	}
+
+	shortcut := 0
+	for _, p := range parts[:len(parts)-1] {
+		switch p {
+		case "ctrl", "control":
+			shortcut |= fltk.CTRL
+		case "alt":
+			shortcut |= fltk.ALT
+		case "shift":
+			shortcut |= fltk.SHIFT
+		case "super", "meta":
+			shortcut |= fltk.META
+		default:
+			return 0, fmt.Errorf("unknown modifier %q in %v", p, spec)
+		}
+	}
+
+	if k, ok := keyNames[key]; ok {
+		return shortcut | k, nil
+	}
+
+	r := []rune(key)
+	if len(r) != 1 || r[0] < ' ' || r[0] > '~' {
+		return 0, fmt.Errorf("unknown key %q in %v", key, spec)
+	}
+
+	return shortcut | int(r[0]), nil
+}
+
+// Converts an fltk shortcut back to its written form, such as ctrl+shift+c.
+func formatShortcut(shortcut int) string {
+	parts := []string{}
+	key := shortcut
+	for _, m := range keyModifiers {
+		if shortcut&m.Mask != 0 {
+			parts = append(parts, m.Name)
+			key &^= m.Mask
+		}
+	}
+
+	name := ""
+	for n, k := range keyNames {
+		if k == key {
+			name = n
+			break
+		}
+	}
+	if name == "" {
+		name = strings.ToLower(string(rune(key)))
+	}
+
+	return strings.Join(append(parts, name), "+")
+}
+
+// Returns the label of the action with the provided name.
+func keyActionLabel(name string) string {
+	for _, a := range keyActions {
+		if a.Name == name {
+			return a.Label
+		}
+	}
+
+	return name
+}
+
+// Returns the key of every action for the provided preset and overrides, in
+// their written form. Actions without a key are left out.
+func resolveKeybindings(preset string, overrides map[string]string) map[string]string {
+	bindings := maps.Clone(defaultKeybindings)
+	maps.Copy(bindings, keyPresets[preset])
+	maps.Copy(bindings, overrides)
+
+	for name, spec := range bindings {
+		if spec == "" || spec == KEY_NONE {
+			delete(bindings, name)
+		}
+	}
+
+	return bindings
+}
+
+// Converts the bindings to fltk shortcuts. Invalid keys are left out, and so
+// are keys that are bound to more than one action, except for the first
+// action in keyActions. Each problem is returned as an error.
+func shortcutsFor(bindings map[string]string) (map[string]int, []error) {
+	shortcuts := make(map[string]int)
+	owners := make(map[int]string)
+	errs := []error{}
+
+	for _, a := range keyActions {
+		spec, ok := bindings[a.Name]
+		if !ok {
+			continue
+		}
+
+		s, err := parseShortcut(spec)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("invalid key for %v: %v", a.Name, err.Error()))
+			continue
+		}
+
+		if owner, ok := owners[s]; ok {
+			errs = append(errs, fmt.Errorf("%v is bound to both %v and %v", spec, owner, a.Name))
+			continue
+		}
+
+		owners[s] = a.Name
+		shortcuts[a.Name] = s
+	}
+
+	return shortcuts, errs
+}
+
+// Returns the other action that the key is bound to, if any.
+func keyConflict(bindings map[string]string, name, spec string) (string, bool) {
+	s, err := parseShortcut(spec)
+	if err != nil {
+		return "", false
+	}
+
+	for other, o := range bindings {
+		if other == name {
+			continue
+		}
+
+		if k, err := parseShortcut(o); err == nil && k == s {
+			return other, true
+		}
+	}
+
+	return "", false
+}
+
+// The actions bound to keys that type text, by shortcut. The window-level menu
+// would trigger them while typing in the search input, so handleHistoryKey
+// dispatches them instead.
+var historyShortcuts = make(map[int]func())
+
+// Returns true if pressing the shortcut would type text into an input.
+func typedShortcut(s int) bool {
+	if s&(fltk.CTRL|fltk.ALT|fltk.META) != 0 {
+		return false
+	}
+
+	key := s &^ fltk.SHIFT
+
+	return key >= ' ' && key <= '~'
+}
+
+// Replaces the items of the invisible menu that receives keyboard shortcuts
+// with the configured keybindings. Keys that type text are registered in
+// historyShortcuts instead.
+func applyKeybindings(m *fltk.MenuBar, handlers map[string]func()) {
+	shortcuts, errs := shortcutsFor(resolveKeybindings(appConf.KeyPreset, appConf.Keybindings))
+	for _, err := range errs {
+		log.Printf("keybinding ignored: %v", err.Error())
+	}
+
+	m.Clear()
+	historyShortcuts = make(map[int]func())
+	for _, a := range keyActions {
+		s, ok := shortcuts[a.Name]
+		if !ok {
+			continue
+		}
+
+		h, ok := handlers[a.Name]
+		if !ok {
+			continue
+		}
+
+		if typedShortcut(s) {
+			historyShortcuts[s] = h
+			continue
+		}
+
+		m.AddEx(a.Label, s, h, 0)
+	}
+}
+
+// Returns the written form of the key that was just pressed, or false if it
+// was only a modifier.
+func pressedShortcut() (string, bool) {
+	key := fltk.EventKey()
+	// Shift_L through Hyper_R
+	if key >= 0xffe1 && key <= 0xffee {
+		return "", false
+	}
+
+	state := fltk.EventState() & (fltk.CTRL | fltk.ALT | fltk.SHIFT | fltk.META)
+
+	return formatShortcut(state | key), true
+}
+
+// Shows a dialog for choosing a preset and rebinding individual actions. A
+// key is recorded by focusing the key input and pressing it. apply is called
+// after the keybindings have been changed.
+func keybindingsDialog(apply func()) {
+	preset := appConf.KeyPreset
+	overrides := maps.Clone(appConf.Keybindings)
+	if overrides == nil {
+		overrides = make(map[string]string)
+	}
+
+	dialog := fltk.NewWindow(360, 420, "Keybindings")
+	dialog.SetModal()
+
+	presetChoice := fltk.NewChoice(10, 25, 340, 25, "&Preset")
+	presetChoice.SetAlign(fltk.ALIGN_TOP_LEFT)
+	bindingsBrowser := fltk.NewHoldBrowser(10, 60, 340, 240)
+	bindingsBrowser.SetColumnWidths(180)
+	keyInput := fltk.NewInput(10, 325, 160, 25, "Press a &Key")
+	keyInput.SetAlign(fltk.ALIGN_TOP_LEFT)
+	keyInput.SetTooltip("Focus this and press the new key for the selected action.")
+	setBtn := fltk.NewButton(180, 325, 80, 25, "&Set")
+	unbindBtn := fltk.NewButton(270, 325, 80, 25, "&Unbind")
+	cancelBtn := fltk.NewButton(10, 380, 165, 30, "Cancel")
+	applyBtn := fltk.NewButton(185, 380, 165, 30, "&Apply")
+	dialog.End()
+
+	// Lists every action with its key, keeping the selected row.
+	refresh := func() {
+		line := bindingsBrowser.Value()
+		bindings := resolveKeybindings(preset, overrides)
+		bindingsBrowser.Clear()
+		for _, a := range keyActions {
+			spec, ok := bindings[a.Name]
+			if !ok {
+				spec = KEY_NONE
+			}
+			bindingsBrowser.Add(fmt.Sprintf("%v\t%v", a.Label, spec))
+		}
+		if line > 0 {
+			bindingsBrowser.SetValue(line)
+		}
+	}
+
+	// Binds the selected action to spec, asking before taking the key from
+	// another action.
+	bind := func(spec string) {
+		line := bindingsBrowser.Value()
+		if line <= 0 {
+			fltk.MessageBox("Keybindings", "Select an action first.")
+			return
+		}
+		name := keyActions[line-1].Name
+
+		if spec != KEY_NONE {
+			_, err := parseShortcut(spec)
+			if err != nil {
+				fltk.MessageBox("Invalid", err.Error())
+				return
+			}
+
+			if other, ok := keyConflict(resolveKeybindings(preset, overrides), name, spec); ok {
+				msg := fmt.Sprintf("%v is already bound to %v. Move it to %v?", spec, keyActionLabel(other), keyActionLabel(name))
+				if fltk.ChoiceDialog(msg, "Cancel", "Move") != 1 {
+					return
+				}
+				overrides[other] = KEY_NONE
+			}
+		}
+
+		overrides[name] = spec
+		refresh()
+	}
+
+	for i, p := range keyPresetNames {
+		presetChoice.Add(p, func() {
+			// the overrides were made for the previous preset
+			preset = p
+			overrides = make(map[string]string)
+			refresh()
+		})
+		if p == preset {
+			presetChoice.SetValue(i)
+		}
+	}
+
+	bindingsBrowser.SetCallback(func() {
+		line := bindingsBrowser.Value()
+		if line <= 0 {
+			return
+		}
+		spec, ok := resolveKeybindings(preset, overrides)[keyActions[line-1].Name]
+		if !ok {
+			spec = ""
+		}
+		keyInput.SetValue(spec)
+	})
+
+	keyInput.SetEventHandler(func(e fltk.Event) bool {
+		if e != fltk.KEYDOWN {
+			return false
+		}
+
+		// tab still moves the focus, so that the dialog can be left without
+		// a mouse
+		if fltk.EventKey() == fltk.TAB && fltk.EventState()&(fltk.CTRL|fltk.ALT|fltk.META) == 0 {
+			return false
+		}
+
+		spec, ok := pressedShortcut()
+		if ok {
+			keyInput.SetValue(spec)
+		}
+
+		return true
+	})
+
+	setBtn.SetCallback(func() {
+		bind(keyInput.Value())
+	})
+
+	unbindBtn.SetCallback(func() {
+		bind(KEY_NONE)
+	})
+
+	cancelBtn.SetCallback(func() {
+		dialog.Hide()
+		dialog.Destroy()
+	})
+
+	applyBtn.SetCallback(func() {
+		_, errs := shortcutsFor(resolveKeybindings(preset, overrides))
+		if len(errs) > 0 {
+			msgs := []string{}
+			for _, err := range errs {
+				msgs = append(msgs, err.Error())
+			}
+			slices.Sort(msgs)
+			fltk.MessageBox("Conflicting Keybindings", strings.Join(msgs, "\n"))
+			return
+		}
+
+		appConf.KeyPreset = preset
+		appConf.Keybindings = overrides
+		dialog.Hide()
+		dialog.Destroy()
+		apply()
+	})
+
+	refresh()
+	dialog.Show()
+}
@@ -1,12 +1,16 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
+	"io"
 	"log"
 	"os"
+	"os/exec"
 	"path/filepath"
 	"strings"
+	"time"
 )
 
 // this is a value from the fltk lib that is used for scrolling to the bottom of
@@ -35,34 +39,43 @@ func Logf(format string, v ...any) {
 }
 
 // Runs a command with the provided command (such as `/bin/sh`) and args (such
-// as ["-c","'echo hello'"]) and environment variables (such as 'DISPLAY=:0').
+// as ["-c","'echo hello'"]) and environment variables (such as 'DISPLAY=:0'),
+// which are added to this process's environment. The command is killed when
+// ctx is done.
 //
 // `stdin`, `stdout`, and `stderr` can all be `nil`.
 //
 // Returns the exit code of the command when it finishes.
-// func runCommand(command string, args []string, env []string, stdin io.Reader, stdout, stderr io.Writer) (int, error) {
-// 	cmd := exec.Command(command, args...)
-// 	cmd.Env = append(cmd.Env, env...)
+func runCommand(ctx context.Context, command string, args []string, env []string, stdin io.Reader, stdout, stderr io.Writer) (int, error) {
+	cmd := exec.CommandContext(ctx, command, args...)
+	cmd.Env = append(os.Environ(), env...)
+	// don't wait forever for children of the command that keep its output open
+	cmd.WaitDelay = time.Second
+
+	if stdin != nil {
+		cmd.Stdin = stdin
+	}
 
-// 	if stdin != nil {
-// 		cmd.Stdin = stdin
-// 	}
+	if stdout != nil {
+		cmd.Stdout = stdout
+	}
 
-// 	if stdout != nil {
-// 		cmd.Stdout = stdout
-// 	}
+	if stderr != nil {
+		cmd.Stderr = stderr
+	}
 
-// 	if stderr != nil {
-// 		cmd.Stderr = stdout
-// 	}
+	err := cmd.Run()
+	if cmd.ProcessState == nil {
+		// the command could not be started
+		return -1, err
+	}
 
-// 	err := cmd.Run()
-// 	if err != nil {
-// 		return cmd.ProcessState.ExitCode(), err
-// 	}
+	if err != nil {
+		return cmd.ProcessState.ExitCode(), err
+	}
 
-// 	return cmd.ProcessState.ExitCode(), nil
-// }
+	return cmd.ProcessState.ExitCode(), nil
+}
 
 // Returns a minimum value of 0 if the provided integer is less than 0.
 // Otherwise, returns the int itself.
@@ -81,6 +94,22 @@ func minz(a, b int) int {
 	return floorz(b)
 }
 
+// Formats a number of bytes as a human-readable string, such as "1.5 KiB".
+func formatBytes(n int) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%v B", n)
+	}
+
+	div, exp := unit, 0
+	for i := n / unit; i >= unit; i /= unit {
+		div *= unit
+		exp++
+	}
+
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
+
 // Replaces all occurences of any keys in the secrets map with their masked
 // values.
 func obscure(s string, secrets map[string]string) string {
@@ -189,6 +218,13 @@ func saveConfig(fileName string, c *AppConfig) error {
 		return fmt.Errorf("config was nil")
 	}
 
+	// when using the sqlite store, the history is not written to the config
+	if storeEnabled() {
+		cc := *c
+		cc.Log = nil
+		c = &cc
+	}
+
 	b, err := json.Marshal(*c)
 	if err != nil {
 		return fmt.Errorf("failed to marshal app config to yaml: %v", err.Error())
@@ -198,10 +234,16 @@ func saveConfig(fileName string, c *AppConfig) error {
 		if err != nil {
 			return fmt.Errorf("failed to create app config parent dir %v: %v", dir, err.Error())
 		}
-		err = os.WriteFile(fileName, b, 0o644)
+		// the config holds the history and the peer secret, so only the
+		// user may read it. WriteFile keeps the mode of an existing file.
+		err = os.WriteFile(fileName, b, 0o600)
 		if err != nil {
 			return fmt.Errorf("failed to save app config to %v: %v", fileName, err.Error())
 		}
+		err = os.Chmod(fileName, 0o600)
+		if err != nil {
+			return fmt.Errorf("failed to restrict permissions of app config %v: %v", fileName, err.Error())
+		}
 	}
 
 	return nil
@@ -0,0 +1,285 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+)
+
+const (
+	DEFAULT_MAX_ENTRY_BYTES   = 1024 * 1024
+	DEFAULT_MAX_HISTORY_BYTES = 16 * 1024 * 1024
+
+	// Policies for what to do when an entry exceeds one of the size limits.
+	//
+	// Don't store the entry at all.
+	POLICY_SKIP = "skip"
+	// Store as much of the entry as fits, followed by TRUNCATION_MARKER.
+	POLICY_TRUNCATE = "truncate"
+	// Store the entry in its own file next to the config file, and only keep a
+	// truncated preview in the config.
+	POLICY_BLOB = "blob"
+	// Only valid for the history budget - deletes the oldest entries until
+	// the history fits within the budget again.
+	POLICY_EVICT = "evict"
+
+	DEFAULT_MAX_ENTRY_POLICY   = POLICY_TRUNCATE
+	DEFAULT_MAX_HISTORY_POLICY = POLICY_EVICT
+
+	// Appended to entries that have been truncated. The placeholder is the
+	// original size of the entry.
+	TRUNCATION_MARKER = "\n[... truncated, %v total ...]"
+
+	// The number of bytes kept in the config as a preview for blob entries.
+	BLOB_PREVIEW_BYTES = 1024
+)
+
+// Returns the sha256 hash of s as a hex string.
+func hashValue(s string) string {
+	h := sha256.Sum256([]byte(s))
+	return hex.EncodeToString(h[:])
+}
+
+// Returns the directory that blob entries are written to. It lives next to the
+// config file so that both can be backed up together.
+func blobDir() string {
+	return filepath.Join(filepath.Dir(configFilePath), "blobs")
+}
+
+// Truncates s to at most n bytes, followed by a marker indicating the original
+// size. Multi-byte characters are not split.
+func truncateValue(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+
+	marker := fmt.Sprintf(TRUNCATION_MARKER, formatBytes(len(s)))
+	if len(marker) > n {
+		// there isn't room for the marker, so the value is only cut short
+		marker = ""
+	}
+	n = floorz(n - len(marker))
+	for n > 0 && n < len(s) && !isRuneStart(s[n]) {
+		n--
+	}
+
+	return s[:n] + marker
+}
+
+// Returns true if b is the first byte of a utf-8 encoded rune.
+func isRuneStart(b byte) bool {
+	return b&0xC0 != 0x80
+}
+
+// Writes the full value to a blob file and returns an entry that references
+// it, keeping only a preview in the entry's value.
+func newBlobEntry(value, hash string) (ClipboardEntry, error) {
+	dir := blobDir()
+	err := os.MkdirAll(dir, 0o755)
+	if err != nil {
+		return ClipboardEntry{}, fmt.Errorf("failed to create blob dir %v: %v", dir, err.Error())
+	}
+
+	fileName := filepath.Join(dir, hash)
+	err = os.WriteFile(fileName, []byte(value), 0o600)
+	if err != nil {
+		return ClipboardEntry{}, fmt.Errorf("failed to write blob %v: %v", fileName, err.Error())
+	}
+
+	return ClipboardEntry{
+		Value: truncateValue(value, BLOB_PREVIEW_BYTES),
+		Hash:  hash,
+		Size:  len(value),
+		Blob:  hash,
+	}, nil
+}
+
+// Applies a size policy to value so that it fits within n bytes. Returns false
+// if the entry should not be stored.
+func applySizePolicy(policy, value, hash string, n int) (ClipboardEntry, bool) {
+	switch policy {
+	case POLICY_SKIP:
+		log.Printf("skipping %v entry, it exceeds the limit of %v", formatBytes(len(value)), formatBytes(n))
+		return ClipboardEntry{}, false
+	case POLICY_BLOB:
+		e, err := newBlobEntry(value, hash)
+		if err != nil {
+			log.Printf("failed to store entry as a blob, truncating instead: %v", err.Error())
+			break
+		}
+		return e, true
+	}
+
+	return ClipboardEntry{
+		Value: truncateValue(value, n),
+		Hash:  hash,
+		Size:  len(value),
+	}, true
+}
+
+// Creates a new clipboard entry for value, applying the configured entry size
+// and history budget policies. Returns false if the entry should not be
+// stored.
+func newEntry(value string) (ClipboardEntry, bool) {
+	hash := hashValue(value)
+	kind := classify(value)
+	e := ClipboardEntry{Value: value, Hash: hash, Size: len(value)}
+
+	if appConf.MaxEntryBytes > 0 && len(value) > appConf.MaxEntryBytes {
+		var ok bool
+		e, ok = applySizePolicy(appConf.MaxEntryPolicy, value, hash, appConf.MaxEntryBytes)
+		if !ok {
+			return e, false
+		}
+	}
+
+	e.ID = newEntryID()
+	e.Kind = kind
+	e.Tags = autoTags(value)
+
+	// the history budget only applies to history stored in the config file
+	if appConf.MaxHistoryBytes <= 0 || appConf.MaxHistoryPolicy == POLICY_EVICT || storeEnabled() {
+		return e, true
+	}
+
+	remaining := appConf.MaxHistoryBytes - storedBytes()
+	if len(e.Value) <= remaining {
+		return e, true
+	}
+
+	if e.Blob != "" {
+		// already stored externally, nothing else can be done
+		return e, true
+	}
+
+	e, ok := applySizePolicy(appConf.MaxHistoryPolicy, value, hash, floorz(remaining))
+	e.ID = newEntryID()
+	e.Kind = kind
+	e.Tags = autoTags(value)
+
+	return e, ok
+}
+
+// Deletes the oldest entries that aren't pinned until the history fits within
+// the history budget, if the history budget policy is to evict entries. The
+// newest entry is always kept.
+func enforceHistoryBudget() {
+	if appConf.MaxHistoryBytes <= 0 || appConf.MaxHistoryPolicy != POLICY_EVICT || storeEnabled() {
+		return
+	}
+
+	total := storedBytes()
+	toDel := []int{}
+	for i := 0; i < len(appConf.Log)-1 && total > appConf.MaxHistoryBytes; i++ {
+		if appConf.Log[i].Pinned {
+			continue
+		}
+
+		total -= len(appConf.Log[i].Value)
+		toDel = append(toDel, i)
+	}
+
+	if len(toDel) == 0 {
+		return
+	}
+
+	removeEntries(toDel)
+
+	log.Printf("evicted %v entries to stay within the %v history budget", len(toDel), formatBytes(appConf.MaxHistoryBytes))
+}
+
+// Deletes the oldest entries that aren't pinned until the history holds at
+// most MaxEntries entries. When using the sqlite store, the log only holds one
+// page of the history, so the page is cut short instead and nothing is
+// deleted.
+func enforceMaxEntries() {
+	l := len(appConf.Log)
+	if l <= appConf.MaxEntries {
+		return
+	}
+
+	if storeEnabled() {
+		appConf.Log = appConf.Log[l-appConf.MaxEntries:]
+		invalidateDedupeIndex()
+		return
+	}
+
+	toDel := []int{}
+	for i := 0; i < l && l-len(toDel) > appConf.MaxEntries; i++ {
+		if !appConf.Log[i].Pinned {
+			toDel = append(toDel, i)
+		}
+	}
+
+	removeEntries(toDel)
+}
+
+// Returns the number of bytes that the history occupies in the config file.
+func storedBytes() int {
+	total := 0
+	for _, e := range appConf.Log {
+		total += len(e.Value)
+	}
+
+	return total
+}
+
+// Returns the total size of all entries in the history, including the full
+// size of entries that were truncated or stored as blobs.
+func historyBytes() int {
+	total := 0
+	for _, e := range appConf.Log {
+		total += entrySize(e)
+	}
+
+	return total
+}
+
+// Returns the original size of the entry's value.
+func entrySize(e ClipboardEntry) int {
+	if e.Size > 0 {
+		return e.Size
+	}
+
+	return len(e.Value)
+}
+
+// Returns the full value of an entry, reading it from its blob file if needed.
+// If the blob cannot be read, the preview is returned instead.
+func entryValue(e ClipboardEntry) string {
+	if e.Blob == "" {
+		return e.Value
+	}
+
+	fileName := filepath.Join(blobDir(), e.Blob)
+	b, err := os.ReadFile(fileName)
+	if err != nil {
+		log.Printf("failed to read blob %v, using preview instead: %v", fileName, err.Error())
+		return e.Value
+	}
+
+	return string(b)
+}
+
+// Deletes the blob file for e, unless another entry in remaining, the store,
+// the trash or the undo history still references it.
+func removeBlob(e ClipboardEntry, remaining []ClipboardEntry) {
+	if e.Blob == "" || blobReferenced(e.Blob) || storeBlobReferenced(e.Blob) {
+		return
+	}
+
+	for _, r := range remaining {
+		if r.Blob == e.Blob {
+			return
+		}
+	}
+
+	fileName := filepath.Join(blobDir(), e.Blob)
+	err := os.Remove(fileName)
+	if err != nil && !os.IsNotExist(err) {
+		log.Printf("failed to remove blob %v: %v", fileName, err.Error())
+	}
+}
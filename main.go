@@ -8,10 +8,7 @@ import (
 	"os"
 	"os/signal"
 	"path"
-	"slices"
-	"sort"
 	"strconv"
-	"strings"
 	"syscall"
 	"time"
 
@@ -35,6 +32,17 @@ var version string = "dev"
 // Flag for showing the version and subsequently quitting.
 var flagVersion bool
 
+// Flag for sending a command to an already running instance and subsequently
+// quitting.
+var flagCmd string
+
+// Flags for exporting or importing history and subsequently quitting.
+var (
+	flagExport string
+	flagImport string
+	flagFormat string
+)
+
 var (
 	forcePortrait  bool
 	forceLandscape bool
@@ -51,8 +59,30 @@ var (
 )
 
 type ClipboardEntry struct {
+	// A random id that uniquely identifies the entry.
+	ID       string `json:"id,omitempty"`
 	Value    string
 	Selected bool
+	// The sha256 hash of the full value, which may differ from Value if the
+	// entry was truncated or stored as a blob.
+	Hash string `json:"hash,omitempty"`
+	// The size in bytes of the full value.
+	Size int `json:"size,omitempty"`
+	// If set, the full value is stored in this file in the blobs directory
+	// next to the config file, and Value only holds a preview.
+	Blob string `json:"blob,omitempty"`
+	// When the entry was last captured.
+	Time time.Time `json:"time,omitempty"`
+	// The number of times the entry has been captured, when using the global
+	// dedupe mode.
+	Count int `json:"count,omitempty"`
+	// Pinned entries are never deleted by retention rules.
+	Pinned bool `json:"pinned,omitempty"`
+	// The kind of content, such as url or json, detected when the entry was
+	// captured.
+	Kind string `json:"kind,omitempty"`
+	// Tags that the user or the tag rules gave the entry.
+	Tags []string `json:"tags,omitempty"`
 }
 
 type AppConfig struct {
@@ -66,6 +96,100 @@ type AppConfig struct {
 	// A list of secrets and the values to mask them with.
 	// Can only be supplied by directly editing the config.
 	Secrets map[string]string `json:"secrets"`
+	// The number of minutes that a timed pause (ctrl+shift+p) lasts for.
+	PauseMinutes int `json:"pauseMinutes"`
+	// If true, the pause state below is saved and restored between restarts.
+	// Otherwise, capturing always resumes when the app starts.
+	PersistPause bool      `json:"persistPause"`
+	Paused       bool      `json:"paused"`
+	PausedUntil  time.Time `json:"pausedUntil"`
+	// The largest size in bytes of a single entry, and what to do with entries
+	// that exceed it (skip, truncate, or blob). Negative values disable the
+	// limit.
+	MaxEntryBytes  int    `json:"maxEntryBytes"`
+	MaxEntryPolicy string `json:"maxEntryPolicy"`
+	// The largest number of bytes that the whole history may occupy in the
+	// config file, and what to do when a new entry would exceed it (evict,
+	// skip, truncate, or blob). Negative values disable the limit.
+	MaxHistoryBytes  int    `json:"maxHistoryBytes"`
+	MaxHistoryPolicy string `json:"maxHistoryPolicy"`
+	// How to handle captured values that are already in the history (none,
+	// consecutive, or global).
+	DedupeMode string `json:"dedupeMode"`
+	// If true, values that only differ by whitespace are considered
+	// duplicates.
+	DedupeIgnoreWhitespace bool `json:"dedupeIgnoreWhitespace"`
+	// Rules for automatically deleting entries, evaluated at startup and
+	// periodically while running. Can only be supplied by directly editing
+	// the config.
+	Retention []RetentionRule `json:"retention"`
+	// Separates entries when exporting to or importing from plain text.
+	TextSeparator string `json:"textSeparator"`
+	// Where the history is stored: json (in this config file's log) or sqlite
+	// (in a database next to this config file). When switching to sqlite, the
+	// log is moved into the database on the next start.
+	Store string `json:"store"`
+	// How multiple selected entries are combined when copying them.
+	CopyFormat CopyFormat `json:"copyFormat"`
+	// Shell commands that can be run on the selected entries.
+	Actions []CommandAction `json:"actions"`
+	// Shell commands that are run whenever something is captured.
+	Hooks []CommandAction `json:"hooks"`
+	// A global hotkey such as ctrl+alt+v or super+v that shows and hides the
+	// window, or none.
+	Hotkey string `json:"hotkey"`
+	// Where the hotkey shows the window: cursor or center.
+	HotkeyPosition string `json:"hotkeyPosition"`
+	// If true, copying hides the window and pastes into the previously
+	// focused window.
+	AutoPaste bool `json:"autoPaste"`
+	// How the paste keystroke is synthesized: auto, xtest, xdotool, wtype, or
+	// a shell command.
+	PasteHelper string `json:"pasteHelper"`
+	// The keys that paste into each app, keyed by part of its WM_CLASS.
+	// Other apps use ctrl+v.
+	PasteKeys map[string]string `json:"pasteKeys"`
+	// If true, an icon is shown in the system tray.
+	Tray bool `json:"tray"`
+	// If true and the tray icon is shown, closing the window hides it instead
+	// of exiting.
+	CloseToTray bool `json:"closeToTray"`
+	// The number of recent entries shown in the tray icon's menu.
+	TrayEntries int `json:"trayEntries"`
+	// Which desktop notifications are shown.
+	Notifications NotifyConfig `json:"notifications"`
+	// The name of the color theme, either built-in or defined in a file in
+	// the themes directory next to this config file, or auto to follow the
+	// desktop's dark or light preference.
+	Theme string `json:"theme"`
+	// The themes used by the dark mode toggle and by auto.
+	DarkTheme  string `json:"darkTheme"`
+	LightTheme string `json:"lightTheme"`
+	// The keybinding preset: default, vim or emacs.
+	KeyPreset string `json:"keyPreset"`
+	// Keys that replace the preset's key for an action, such as
+	// {"copy": "ctrl+shift+y"}. none leaves the action without a key.
+	Keybindings map[string]string `json:"keybindings"`
+	// Rules that tag captured values matching a regular expression. Can only
+	// be supplied by directly editing the config.
+	TagRules []TagRule `json:"tagRules"`
+	// Deleted entries, kept for trashDays days so that they can be restored.
+	// Negative values disable the trash.
+	Trash     []TrashEntry `json:"trash"`
+	TrashDays int          `json:"trashDays"`
+	// Deleting more entries than this at once asks for confirmation. Negative
+	// values disable the confirmation.
+	ConfirmDeleteCount int `json:"confirmDeleteCount"`
+	// If set, history is synced with other instances through this
+	// directory, such as one synced by Syncthing. Each instance appends its
+	// changes to its own file in it.
+	SyncDir string `json:"syncDir"`
+	// Peer mode, for sending entries directly to other instances on the
+	// local network. Can only be supplied by directly editing the config.
+	Peer PeerConfig `json:"peer"`
+	// An http api on the loopback interface, for editor plugins and other
+	// tools. Can only be supplied by directly editing the config.
+	API APIConfig `json:"api"`
 }
 
 // Buttons, inputs, widgets, etc that need to be repositioned in a
@@ -74,6 +198,8 @@ var (
 	win *fltk.Window
 	// For switching to the settings pane.
 	settingsBtn *fltk.Button
+	// For pausing and resuming clipboard capturing.
+	pauseBtn *fltk.Button
 	// For deleting the currently selected entries.
 	deleteBtn *fltk.Button
 	// For copying the currently selected item.
@@ -82,6 +208,18 @@ var (
 	// saveBtn *fltk.Button
 	// Each clipboard entry will go into here.
 	logBrowser *fltk.MultiBrowser
+	// For filtering the entries shown in the log browser.
+	searchInput *fltk.Input
+	// Shows the tag filter, and lists every tag to filter by when clicked.
+	tagFilterBtn *fltk.Button
+	// Pops up the list of tags for the tag filter button.
+	tagFilterMenu *fltk.MenuButton
+	// Pops up to apply a text transform to the selected entries.
+	transformMenu *fltk.MenuButton
+	// Pops up to run a user-defined action on the selected entries.
+	actionsMenu *fltk.MenuButton
+	// Pops up when right-clicking the history.
+	contextMenu *fltk.MenuButton
 
 	// Settings page items
 	maxEntriesInput        *fltk.Input
@@ -89,6 +227,13 @@ var (
 	backBtn                *fltk.Button
 	saveBtn                *fltk.Button
 	darkModeBtn            *fltk.CheckButton
+	themeChoice            *fltk.Choice
+	keybindingsBtn         *fltk.Button
+	trashBtn               *fltk.Button
+	syncBtn                *fltk.Button
+	persistPauseBtn        *fltk.CheckButton
+	retentionBtn           *fltk.Button
+	autoPasteBtn           *fltk.CheckButton
 )
 
 func parseFlags() {
@@ -98,6 +243,10 @@ func parseFlags() {
 	flag.IntVar(&captureIntervalMs, "ms", DEFAULT_CAPTURE_INTERVAL_MS, "interval between each attempt to read the clipboard")
 	flag.IntVar(&maxEntries, "entries", DEFAULT_MAX_ENTRIES, "interval between each attempt to read the clipboard")
 	flag.BoolVar(&flagVersion, "v", false, "print version and exit")
+	flag.StringVar(&flagCmd, "cmd", "", "send a command to a running instance and exit, such as 'pause', 'pause 5m', 'resume', 'toggle', or 'status'")
+	flag.StringVar(&flagExport, "export", "", "export the whole history to this file and exit")
+	flag.StringVar(&flagImport, "import", "", "import history from this file and exit")
+	flag.StringVar(&flagFormat, "format", "", "the format for -export and -import (jsonl, txt, csv, or md); determined from the file extension if unset")
 	flag.Parse()
 }
 
@@ -110,6 +259,17 @@ func main() {
 		os.Exit(0)
 	}
 
+	if flagCmd != "" {
+		resp, err := sendIPCCommand(flagCmd)
+		if err != nil {
+			log.Fatalf("failed to send command: %v", err.Error())
+		}
+
+		//nolint:forbidigo
+		fmt.Println(resp)
+		os.Exit(0)
+	}
+
 	var err error
 
 	if configFilePath == "" {
@@ -150,6 +310,121 @@ func main() {
 	if appConf.Secrets == nil {
 		appConf.Secrets = make(map[string]string)
 	}
+	if appConf.PauseMinutes == 0 {
+		appConf.PauseMinutes = DEFAULT_PAUSE_MINUTES
+	}
+	if appConf.MaxEntryBytes == 0 {
+		appConf.MaxEntryBytes = DEFAULT_MAX_ENTRY_BYTES
+	}
+	if appConf.MaxEntryPolicy == "" {
+		appConf.MaxEntryPolicy = DEFAULT_MAX_ENTRY_POLICY
+	}
+	if appConf.MaxHistoryBytes == 0 {
+		appConf.MaxHistoryBytes = DEFAULT_MAX_HISTORY_BYTES
+	}
+	if appConf.MaxHistoryPolicy == "" {
+		appConf.MaxHistoryPolicy = DEFAULT_MAX_HISTORY_POLICY
+	}
+	if appConf.DedupeMode == "" {
+		appConf.DedupeMode = DEFAULT_DEDUPE_MODE
+	}
+	if appConf.Store == "" {
+		appConf.Store = DEFAULT_STORE
+	}
+	appConf.CopyFormat.setDefaults()
+	if appConf.Hotkey == "" {
+		appConf.Hotkey = DEFAULT_HOTKEY
+	}
+	if appConf.HotkeyPosition == "" {
+		appConf.HotkeyPosition = DEFAULT_HOTKEY_POSITION
+	}
+	if appConf.PasteHelper == "" {
+		appConf.PasteHelper = DEFAULT_PASTE_HELPER
+	}
+	if appConf.PasteKeys == nil {
+		appConf.PasteKeys = defaultPasteKeys
+	}
+	if appConf.TrayEntries == 0 {
+		appConf.TrayEntries = DEFAULT_TRAY_ENTRIES
+	}
+	if appConf.Notifications.Throttle == "" {
+		appConf.Notifications.Throttle = DEFAULT_NOTIFY_THROTTLE
+	}
+	if appConf.DarkTheme == "" {
+		appConf.DarkTheme = DEFAULT_DARK_THEME
+	}
+	if appConf.LightTheme == "" {
+		appConf.LightTheme = DEFAULT_LIGHT_THEME
+	}
+	if appConf.TrashDays == 0 {
+		appConf.TrashDays = DEFAULT_TRASH_DAYS
+	}
+	if appConf.ConfirmDeleteCount == 0 {
+		appConf.ConfirmDeleteCount = DEFAULT_CONFIRM_DELETE_COUNT
+	}
+	if appConf.KeyPreset == "" {
+		appConf.KeyPreset = DEFAULT_KEY_PRESET
+	}
+	if appConf.Theme == "" {
+		// configs from before themes only had dark mode
+		appConf.Theme = appConf.LightTheme
+		if appConf.DarkMode {
+			appConf.Theme = appConf.DarkTheme
+		}
+	}
+	for i := range appConf.Actions {
+		if appConf.Actions[i].Output == "" {
+			appConf.Actions[i].Output = DEFAULT_ACTION_OUTPUT
+		}
+	}
+
+	backfillEntries()
+
+	if appConf.Store == STORE_SQLITE {
+		err = openStore()
+		if err != nil {
+			log.Printf("falling back to storing history in the config file: %v", err.Error())
+		} else {
+			err = migrateLogToStore()
+			if err != nil {
+				log.Printf("failed to move history into the store, it will be kept in the config file: %v", err.Error())
+				closeStore()
+				historyDB = nil
+			}
+		}
+
+		loadHistory()
+	}
+
+	if appConf.SyncDir != "" {
+		err = startSync()
+		if err != nil {
+			log.Printf("history will not be synced: %v", err.Error())
+			stopSync()
+		} else {
+			pullSync()
+		}
+	}
+
+	restorePauseConfig()
+
+	if flag.Arg(0) == "migrate" {
+		err = runMigrate(flag.Args()[1:])
+		if err != nil {
+			log.Fatalf("%v", err.Error())
+		}
+
+		os.Exit(0)
+	}
+
+	if flagExport != "" || flagImport != "" {
+		err = runExportImportFlags()
+		if err != nil {
+			log.Fatalf("%v", err.Error())
+		}
+
+		os.Exit(0)
+	}
 
 	portrait, err = isPortrait()
 	if err != nil {
@@ -170,7 +445,7 @@ func main() {
 		portrait = false
 	}
 
-	win = fltk.NewWindow(windowWidth, windowHeight, "Clipboard Manager FLTK")
+	win = fltk.NewWindow(windowWidth, windowHeight, WINDOW_TITLE)
 	// fltk.SetScheme("gtk+")
 	fltk.InitStyles()
 	fltk.SetTooltipDelay(0.1)
@@ -178,9 +453,16 @@ func main() {
 
 	// main page widgets
 	settingsBtn = fltk.NewButton(0, 0, 0, 0, "&Settings")
+	pauseBtn = fltk.NewButton(0, 0, 0, 0, "&Pause")
 	deleteBtn = fltk.NewButton(0, 0, 0, 0, "&Delete")
 	copyBtn = fltk.NewButton(0, 0, 0, 0, "&Copy")
 	logBrowser = fltk.NewMultiBrowser(0, 0, 0, 0)
+	searchInput = fltk.NewInput(0, 0, 0, 0)
+	tagFilterBtn = fltk.NewButton(0, 0, 0, 0, TAG_FILTER_ALL)
+	tagFilterMenu = fltk.NewMenuButton(0, 0, 0, 0)
+	transformMenu = fltk.NewMenuButton(0, 0, 0, 0)
+	actionsMenu = fltk.NewMenuButton(0, 0, 0, 0)
+	contextMenu = fltk.NewMenuButton(0, 0, 0, 0)
 	logBrowser.SetLabelSize(10)
 	logBrowser.SetLabelFont(fltk.HELVETICA)
 
@@ -190,17 +472,44 @@ func main() {
 	maxEntriesInput = fltk.NewInput(0, 0, 0, 0, "&Max Items")
 	captureIntervalMsInput = fltk.NewInput(0, 0, 0, 0, "&Capture Interval (ms)")
 	darkModeBtn = fltk.NewCheckButton(0, 0, 0, 0, "&Dark Mode")
+	themeChoice = fltk.NewChoice(0, 0, 0, 0, "T&heme")
+	persistPauseBtn = fltk.NewCheckButton(0, 0, 0, 0, "&Remember Pause")
+	retentionBtn = fltk.NewButton(0, 0, 0, 0, "Preview &Retention")
+	autoPasteBtn = fltk.NewCheckButton(0, 0, 0, 0, "A&uto Paste")
+	keybindingsBtn = fltk.NewButton(0, 0, 0, 0, "&Keybindings")
+	trashBtn = fltk.NewButton(0, 0, 0, 0, "Tr&ash")
+	syncBtn = fltk.NewButton(0, 0, 0, 0, "S&ync")
 
 	maxEntriesInput.SetValue(fmt.Sprint(appConf.MaxEntries))
 	captureIntervalMsInput.SetValue(fmt.Sprint(appConf.CaptureIntervalMS))
 
 	maxEntriesInput.SetTooltip(fmt.Sprintf("This can be a large number, but performance may suffer. Default=%v", DEFAULT_MAX_ENTRIES))
 	captureIntervalMsInput.SetTooltip(fmt.Sprintf("The smaller the interval, the sooner clipboard events will show up in this app. Avoid setting this number too small. Default=%v", DEFAULT_CAPTURE_INTERVAL_MS))
-	darkModeBtn.SetTooltip("Toggling the UI mode requires a restart, and this setting will persist to settings between app restarts.")
+	darkModeBtn.SetTooltip("Switches between the dark and light themes from the config file.")
+	themeChoice.SetTooltip(fmt.Sprintf("The color theme. Themes can be added as json files in %v. Auto follows the desktop's dark or light preference.", themesDir()))
+	persistPauseBtn.SetTooltip("If checked, pausing capture will persist between app restarts. Otherwise, capturing always resumes when the app starts.")
+	autoPasteBtn.SetTooltip("If checked, copying hides the window and pastes into the window that was focused before it.")
+	keybindingsBtn.SetTooltip("Choose a keybinding preset (default, vim or emacs) and rebind individual actions.")
+	trashBtn.SetTooltip("Restore or permanently delete deleted entries (ctrl+shift+delete). They are kept for the number of days set by trashDays in the config file.")
+	syncBtn.SetTooltip("Sync history with other instances through a folder, such as one synced by Syncthing, or stop syncing.")
+	retentionBtn.SetTooltip("Shows which entries the retention rules in the config file would delete right now, without deleting anything.")
+	pauseBtn.SetTooltip(fmt.Sprintf("Pause or resume clipboard capturing (ctrl+p). Use ctrl+shift+p to pause for %v minutes.", appConf.PauseMinutes))
 
 	maxEntriesInput.SetAlign(fltk.ALIGN_TOP_LEFT)
 	captureIntervalMsInput.SetAlign(fltk.ALIGN_TOP_LEFT)
+	themeChoice.SetAlign(fltk.ALIGN_TOP_LEFT)
 	logBrowser.SetAlign(fltk.ALIGN_BOTTOM_LEFT)
+	transformMenu.SetType(fltk.POPUP3)
+	addTransformItems(transformMenu)
+	actionsMenu.SetType(fltk.POPUP3)
+	contextMenu.SetType(fltk.POPUP3)
+	tagFilterMenu.SetType(fltk.POPUP3)
+	searchInput.SetTooltip("Type to search the history (ctrl+f).")
+	searchInput.SetCallbackCondition(fltk.WhenChanged)
+	searchInput.SetCallback(func() {
+		search(searchInput.Value())
+	})
+	tagFilterBtn.SetTooltip("Only show entries with a tag (ctrl+shift+g). The number of entries with each tag is shown.")
 
 	// hide the settings page widgets on first load
 	backBtn.Hide()
@@ -208,17 +517,70 @@ func main() {
 	maxEntriesInput.Hide()
 	captureIntervalMsInput.Hide()
 	darkModeBtn.Hide()
+	themeChoice.Hide()
+	persistPauseBtn.Hide()
+	retentionBtn.Hide()
+	autoPasteBtn.Hide()
+	keybindingsBtn.Hide()
+	trashBtn.Hide()
+	syncBtn.Hide()
+
+	// Shows the current theme in the settings page and applies it.
+	var setTheme func(name string)
+	setTheme = func(name string) {
+		if name == THEME_AUTO {
+			err := watchDesktopTheme(func() {
+				setTheme(appConf.Theme)
+			})
+			if err != nil {
+				log.Printf("failed to follow the desktop's theme: %v", err.Error())
+			}
+		}
 
-	darkModeChanged := false
-	darkModeBtn.SetValue(appConf.DarkMode)
+		appConf.Theme = name
+		appConf.DarkMode = currentThemeName() == appConf.DarkTheme
+		darkModeBtn.SetValue(appConf.DarkMode)
+		if name == THEME_AUTO {
+			themeChoice.SetValue(0)
+		} else {
+			themeChoice.SetValue(max(themeChoice.FindIndex(name), 0))
+		}
+
+		applyCurrentTheme()
+	}
+
+	themeChoice.Add("Auto", func() { setTheme(THEME_AUTO) })
+	for _, t := range loadThemes() {
+		themeChoice.Add(t.Name, func() { setTheme(t.Name) })
+	}
 
 	darkModeBtn.SetCallback(func() {
-		appConf.DarkMode = !appConf.DarkMode
-		if !darkModeChanged {
-			fltk.MessageBox("App Restart Required", "In order for this to take effect, the theme change won't take effect until the application is restarted.")
-			darkModeChanged = true
+		if darkModeBtn.Value() {
+			setTheme(appConf.DarkTheme)
+		} else {
+			setTheme(appConf.LightTheme)
 		}
-		darkModeBtn.SetValue(appConf.DarkMode)
+	})
+
+	persistPauseBtn.SetValue(appConf.PersistPause)
+
+	persistPauseBtn.SetCallback(func() {
+		appConf.PersistPause = !appConf.PersistPause
+		persistPauseBtn.SetValue(appConf.PersistPause)
+		syncPauseConfig()
+	})
+
+	autoPasteBtn.SetValue(appConf.AutoPaste)
+
+	autoPasteBtn.SetCallback(func() {
+		appConf.AutoPaste = !appConf.AutoPaste
+		autoPasteBtn.SetValue(appConf.AutoPaste)
+	})
+
+	pauseBtn.SetCallback(togglePause)
+
+	retentionBtn.SetCallback(func() {
+		fltk.MessageBox("Retention Preview", retentionPreview())
 	})
 
 	captureIntervalMsInput.SetCallback(func() {
@@ -270,149 +632,224 @@ func main() {
 		fltk.MessageBox("Saved", "Saved successfully.")
 	})
 
-	reconstruct := func() {
-		logBrowser.Clear()
-		l := len(appConf.Log)
-		if l > appConf.MaxEntries {
-			appConf.Log = appConf.Log[1 : appConf.MaxEntries+1]
-			l = len(appConf.Log)
-		}
-		// initialize with the previously stored entries
-		if l > 0 {
-			i := 1
-			for j := l - 1; j >= 0; j-- {
-				v := strings.ReplaceAll(appConf.Log[j].Value, "\n", "\\n")
-				// v = fmt.Sprintf("%v.  %v", j+1, v[:minz(len(v)-1, 200)])
-				v = v[0:minz(len(v), 200)]
-				v = obscure(v, appConf.Secrets)
-				v = fmt.Sprintf("%v.  %v", i, v)
-				logBrowser.Add(v)
-				_ = logBrowser.SetSelected(j+1, appConf.Log[j].Selected)
-				i++
-			}
-		}
-	}
+	applyRetention()
+	expireTrash(time.Now())
 
 	reconstruct()
 
-	addEntry := func(entry string) {
-		l := len(appConf.Log)
+	// the hash of the most recent entry that was rejected by the size
+	// policies, so that it isn't evaluated again on every capture
+	skippedHash := ""
 
-		if l > 0 && appConf.Log[l-1].Value == entry {
+	addEntry := func(entry string) {
+		hash := hashValue(entry)
+		if hash == skippedHash {
 			return
 		}
 
-		appConf.Log = append(appConf.Log, ClipboardEntry{
-			Value:    entry,
-			Selected: false,
-		})
+		_, ok := captureValue(entry, nil)
+		if !ok {
+			skippedHash = hash
+			setStatus(fmt.Sprintf("skipped %v entry", formatBytes(len(entry))))
+			return
+		}
 
+		loadHistory()
 		reconstruct()
 	}
 
+	// the clipboard is polled, so this tracks the most recently read value to
+	// only add entries when the clipboard actually changes
+	lastCaptured := ""
+	if l := len(appConf.Log); l > 0 {
+		lastCaptured = entryValue(appConf.Log[l-1])
+	}
+
 	captureClipboard := func() {
+		// isPaused resumes capture if a timed pause expired, which changes
+		// the config that the ui thread owns
+		if pauseExpired() {
+			onUIThread(func() { isPaused() })
+		}
+
+		if isPausedNoExpire() || queueActive() {
+			return
+		}
+
 		latest, err := clipboard.ReadAll()
 		if err != nil {
 			// Logf("failed to read clipboard: %v, ", err.Error())
 			return
 		}
 
-		addEntry(latest)
+		if latest == lastCaptured {
+			return
+		}
+		lastCaptured = latest
+
+		if captureIgnored(latest) {
+			return
+		}
+
+		// reading the clipboard may take a while, but the history is only
+		// modified on the ui thread
+		onUIThread(func() { addEntry(latest) })
 	}
 
+	logBrowser.SetCallbackCondition(fltk.WhenChanged)
 	logBrowser.SetCallback(func() {
-		l := len(appConf.Log)
-		if l == 0 {
+		historySelectionChanged()
+		j := rowEntry(logBrowser.Value())
+		if j < 0 {
 			return
 		}
-		i := logBrowser.Value()
-		j := l - i
 		logBrowser.SetTooltip(appConf.Log[j].Value)
 	})
 
 	copyAction := func() {
-		total := 0
-		copyStr := new(strings.Builder)
-		l := len(appConf.Log)
-		itemsCopied := 0
-		for i := 1; i <= appConf.MaxEntries; i++ {
-			if i > l {
-				break
-			}
-
-			// note that entries in the browser are reversed, relative to
-			// appConf.Log's items.
-
-			j := l - i
-			if j < 0 {
-				break
-			}
-
-			if logBrowser.IsSelected(i) {
-				copyStr.WriteString(fmt.Sprintf("%v\n", appConf.Log[j].Value))
-				itemsCopied++
-			}
+		copySelected(appConf.CopyFormat)
+	}
 
-			appConf.Log[j].Selected = false
-			logBrowser.SetSelected(i, false)
-			total += len(appConf.Log[j].Value)
-		}
+	copyJSONAction := func() {
+		f := appConf.CopyFormat
+		f.Output = OUTPUT_JSON
+		copySelected(f)
+	}
 
-		result := copyStr.String()
-		if result == "" {
-			log.Println("nothing to copy")
+	queueAction := func(mode string) {
+		if queueActive() {
+			stopQueue()
+			setStatus("paste queue stopped")
 			return
 		}
 
-		// remove the final trailing newline
-		result = strings.TrimSuffix(result, "\n")
-
-		msg := fmt.Sprintf("%v/%v items copied, %v bytes (%v bytes in history)", itemsCopied, l, len(result), total)
-		logBrowser.SetLabel(msg)
-		log.Println(msg)
+		values := []string{}
+		for _, j := range orderedSelection(CopyFormat{Order: ORDER_SELECTION}) {
+			values = append(values, entryValue(appConf.Log[j]))
+		}
 
-		err := clipboard.WriteAll(result)
+		err := startQueue(values, mode)
 		if err != nil {
-			fltk.MessageBox("Error", fmt.Sprintf("Failed to write to clipboard: %v", err.Error()))
+			fltk.MessageBox("Error", fmt.Sprintf("Failed to start paste queue: %v", err.Error()))
 			return
 		}
+
+		for i := 1; i <= len(rows); i++ {
+			appConf.Log[rowEntry(i)].Selected = false
+			logBrowser.SetSelected(i, false)
+		}
+		selectionOrder = nil
 	}
 
 	delAction := func() {
 		l := len(appConf.Log)
-		toDel := []int{}
-		for i := 1; i <= appConf.MaxEntries; i++ {
-			if i > l {
-				break
+		toDel := selectedIndices()
+
+		if appConf.ConfirmDeleteCount > 0 && len(toDel) > appConf.ConfirmDeleteCount {
+			if fltk.ChoiceDialog(fmt.Sprintf("Delete %v entries?", len(toDel)), "Cancel", "Delete") != 1 {
+				return
 			}
+		}
+
+		msg := fmt.Sprintf("%v/%v items deleted", len(toDel), l)
+		statusMessage = msg
+		log.Println(msg)
 
-			// note that entries in the browser are reversed, relative to
-			// appConf.Log's items.
+		entries := make([]ClipboardEntry, len(toDel))
+		for n, j := range toDel {
+			entries[n] = appConf.Log[j]
+		}
+		deleteEntries(UNDO_DELETE, entries)
 
-			j := l - i
-			if j < 0 {
-				break
+		reconstruct()
+	}
+
+	pinAction := func() {
+		pinned := 0
+		changes := []entryChange{}
+		for _, j := range selectedIndices() {
+			before := appConf.Log[j]
+			appConf.Log[j].Pinned = !appConf.Log[j].Pinned
+			after := appConf.Log[j]
+			changes = append(changes, entryChange{ID: before.ID, Before: &before, After: &after})
+			updateEntry(j)
+			if appConf.Log[j].Pinned {
+				pinned++
 			}
+		}
+		pushUndo(UNDO_PIN, changes)
 
-			if logBrowser.IsSelected(i) {
-				toDel = append(toDel, j)
+		statusMessage = fmt.Sprintf("%v items pinned", pinned)
+		reconstruct()
+	}
+
+	undoAction := func() {
+		s, ok := undo()
+		if !ok {
+			setStatus("nothing to undo")
+			return
+		}
+
+		statusMessage = fmt.Sprintf("undid %v", s)
+		reconstruct()
+	}
+
+	redoAction := func() {
+		s, ok := redo()
+		if !ok {
+			setStatus("nothing to redo")
+			return
+		}
+
+		statusMessage = fmt.Sprintf("redid %v", s)
+		reconstruct()
+	}
+
+	exportAction := func() {
+		entries := appConf.Log
+		if storeEnabled() {
+			// the log only holds the loaded page
+			var err error
+			entries, err = storeLoad("", "", 0, -1)
+			if err != nil {
+				fltk.MessageBox("Error", fmt.Sprintf("Failed to export: %v", err.Error()))
+				return
+			}
+		}
+		if sel := selectedIndices(); len(sel) > 0 {
+			entries = make([]ClipboardEntry, len(sel))
+			for n, j := range sel {
+				entries[n] = appConf.Log[j]
 			}
 		}
 
-		sort.Ints(toDel)
-		slices.Reverse(toDel)
+		fileName, ok := chooseFile(fmt.Sprintf("Export %v entries", len(entries)), EXPORT_FILE_FILTER, true)
+		if !ok {
+			return
+		}
 
-		msg := fmt.Sprintf("%v/%v items deleted", len(toDel), l)
-		logBrowser.SetLabel(msg)
-		logBrowser.Redraw()
+		err := exportFile(fileName, "", entries)
+		if err != nil {
+			fltk.MessageBox("Error", fmt.Sprintf("Failed to export: %v", err.Error()))
+			return
+		}
+
+		setStatus(fmt.Sprintf("exported %v items", len(entries)))
+	}
 
-		// i = 40, len = 65
-		// [:40], [41:]...
-		for _, i := range toDel {
-			log.Printf("deleting %v (%v left)", i, len(appConf.Log))
-			appConf.Log = append(appConf.Log[:i], appConf.Log[i+1:]...)
+	importAction := func() {
+		fileName, ok := chooseFile("Import entries", EXPORT_FILE_FILTER, false)
+		if !ok {
+			return
 		}
 
+		msg, err := importFile(fileName, "")
+		if err != nil {
+			fltk.MessageBox("Error", fmt.Sprintf("Failed to import: %v", err.Error()))
+			return
+		}
+
+		statusMessage = msg
 		reconstruct()
 	}
 
@@ -427,27 +864,72 @@ func main() {
 
 	selectAllAction := func() {
 		scrollPos := logBrowser.TopLine()
-		for i := range appConf.Log {
-			appConf.Log[i].Selected = true
+		for i, j := range rows {
+			appConf.Log[j].Selected = true
 			logBrowser.SetSelected(i+1, true)
 		}
 		// reconstruct()
 		_ = logBrowser.SetTopLine(scrollPos)
 	}
 
-	// homeAction := func() {
-	// 	_ = logBrowser.SetTopLine(0)
-	// 	logBrowser.SetSelected(1, true)
-	// }
+	homeAction := func() {
+		selectRow(1)
+	}
+
+	endAction := func() {
+		selectRow(len(rows))
+	}
 
-	// endAction := func() {
-	// 	_ = logBrowser.SetBottomLine(0)
-	// 	logBrowser.SetSelected(len(appConf.Log), true)
-	// }
+	pageUpAction := func() {
+		selectRow(cursorRow - visibleRows())
+	}
+
+	pageDownAction := func() {
+		selectRow(cursorRow + visibleRows())
+	}
+
+	nextAction := func() {
+		selectRow(cursorRow + 1)
+	}
+
+	previousAction := func() {
+		selectRow(cursorRow - 1)
+	}
+
+	searchAction := func() {
+		if currentPage != PAGE_MAIN {
+			return
+		}
+
+		searchInput.TakeFocus()
+	}
+
+	tagFilterAction := func() {
+		if currentPage != PAGE_MAIN {
+			return
+		}
+
+		popupTagFilter(tagFilterMenu)
+	}
+
+	timedPauseAction := func() {
+		pauseCapture(time.Duration(appConf.PauseMinutes) * time.Minute)
+	}
 
 	gracefulExit := func() {
 		Log("closing app and saving config, please wait a moment...")
-		err := saveConfig(configFilePath, &appConf)
+		closeIPC()
+		stopPeer()
+		stopAPI()
+		closeTray()
+		stopQueue()
+		clearUndo()
+		err := saveSyncState()
+		if err != nil {
+			log.Printf("failed to save sync state: %v", err.Error())
+		}
+		defer closeStore()
+		err = saveConfig(configFilePath, &appConf)
 		if err != nil {
 			log.Printf("failed to save config: %v", err.Error())
 		}
@@ -457,25 +939,99 @@ func main() {
 	}
 	// invisible menu that receives keyboard shortcuts
 	topMenu := fltk.NewMenuBar(0, 0, 0, 0)
-	topMenu.AddEx("Copy", fltk.CTRL+'c', copyAction, 0)
-	topMenu.AddEx("Delete", fltk.DELETE, delAction, 0)
-	topMenu.AddEx("Save", fltk.CTRL+'s', saveAction, 0)
-	topMenu.AddEx("Select All", fltk.CTRL+'a', selectAllAction, 0)
-	topMenu.AddEx("Quit", fltk.CTRL+'q', gracefulExit, 0)
-	// topMenu.AddEx("Home", fltk.HOME, homeAction, 0)
-	// topMenu.AddEx("End", fltk.END, endAction, 0)
+	keyHandlers := map[string]func(){
+		KEY_COPY:        copyAction,
+		KEY_COPY_AS:     copyAsDialog,
+		KEY_COPY_JSON:   copyJSONAction,
+		KEY_PASTE_QUEUE: func() { queueAction(QUEUE_FIFO) },
+		KEY_PASTE_STACK: func() { queueAction(QUEUE_LIFO) },
+		KEY_TRANSFORM:   transformMenu.Popup,
+		KEY_ACTIONS:     func() { popupActions(actionsMenu) },
+		KEY_DELETE:      delAction,
+		KEY_UNDO:        undoAction,
+		KEY_REDO:        redoAction,
+		KEY_TRASH:       trashDialog,
+		KEY_EDIT:        editDialog,
+		KEY_SAVE:        saveAction,
+		KEY_SELECT_ALL:  selectAllAction,
+		KEY_PAUSE:       togglePause,
+		KEY_TIMED_PAUSE: timedPauseAction,
+		KEY_PIN:         pinAction,
+		KEY_TAG:         tagDialog,
+		KEY_TAG_FILTER:  tagFilterAction,
+		KEY_EXPORT:      exportAction,
+		KEY_IMPORT:      importAction,
+		KEY_SEARCH:      searchAction,
+		KEY_NEWER_PAGE:  func() { changePage(-1) },
+		KEY_OLDER_PAGE:  func() { changePage(1) },
+		KEY_HOME:        homeAction,
+		KEY_END:         endAction,
+		KEY_PAGE_UP:     pageUpAction,
+		KEY_PAGE_DOWN:   pageDownAction,
+		KEY_NEXT:        nextAction,
+		KEY_PREVIOUS:    previousAction,
+		KEY_QUIT:        gracefulExit,
+	}
+	applyKeybindings(topMenu, keyHandlers)
+
+	logBrowser.SetEventHandler(func(e fltk.Event) bool {
+		return handleHistoryEvent(e, func() {
+			popupContextMenu(contextMenu, keyHandlers)
+		})
+	})
+
+	keybindingsBtn.SetCallback(func() {
+		keybindingsDialog(func() {
+			applyKeybindings(topMenu, keyHandlers)
+			setStatus("keybindings updated")
+		})
+	})
+
 	copyBtn.SetCallback(copyAction)
+	tagFilterBtn.SetCallback(tagFilterAction)
 	deleteBtn.SetCallback(delAction)
+	trashBtn.SetCallback(trashDialog)
+	syncBtn.SetCallback(syncDialog)
 
 	go func() {
+		lastRetention := time.Now()
+		lastSync := time.Now()
 		for {
 			captureClipboard()
 
+			if time.Since(lastSync) > SYNC_INTERVAL {
+				lastSync = time.Now()
+				onUIThread(func() {
+					if pullSync() > 0 {
+						reconstruct()
+					}
+				})
+			}
+
+			// like captures, retention rules modify the log on the ui thread
+			if time.Since(lastRetention) > RETENTION_INTERVAL {
+				lastRetention = time.Now()
+				onUIThread(func() {
+					if applyRetention() > 0 {
+						reconstruct()
+					}
+					expireTrash(lastRetention)
+				})
+			}
+
 			time.Sleep(time.Duration(appConf.CaptureIntervalMS) * time.Millisecond)
 		}
 	}()
 
-	win.SetCallback(gracefulExit)
+	win.SetCallback(func() {
+		if appConf.CloseToTray && trayActive() {
+			forgetActiveWindow()
+			win.Hide()
+			return
+		}
+
+		gracefulExit()
+	})
 
 	fltk.EnableTooltips()
 	fltk.SetTooltipDelay(0.1)
@@ -490,15 +1046,46 @@ func main() {
 		responsive(win)
 	})
 
-	theme(appConf.DarkMode)
+	setTheme(appConf.Theme)
 
 	responsive(win)
 
+	updatePauseIndicator()
+
+	err = listenIPC(handleIPCCommand)
+	if err != nil {
+		log.Printf("commands from other instances will not be received: %v", err.Error())
+	}
+
+	if appConf.Peer.Enabled {
+		err = startPeer()
+		if err != nil {
+			log.Printf("peer mode will not be available: %v", err.Error())
+		}
+	}
+
+	if appConf.API.Enabled {
+		err = startAPI()
+		if err != nil {
+			log.Printf("the api will not be available: %v", err.Error())
+		}
+	}
+
 	win.SetXClass("gfltkclip")
 
 	win.End()
 	win.Show()
 
+	setupHotkey()
+
+	if appConf.Tray {
+		err = setupTray(gracefulExit)
+		if err != nil {
+			log.Printf("failed to show tray icon: %v", err.Error())
+		}
+		updateTray()
+	}
+
 	go fltk.Run()
 
 	// Create a channel to receive OS signals
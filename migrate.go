@@ -0,0 +1,615 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"encoding/xml"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf16"
+	"unicode/utf8"
+
+	"github.com/adrg/xdg"
+	bolt "go.etcd.io/bbolt"
+)
+
+const (
+	MIGRATE_CLIPHIST = "cliphist"
+	MIGRATE_CLIPMAN  = "clipman"
+	MIGRATE_COPYQ    = "copyq"
+	MIGRATE_GPASTE   = "gpaste"
+)
+
+var migrateSources = []string{MIGRATE_CLIPHIST, MIGRATE_CLIPMAN, MIGRATE_COPYQ, MIGRATE_GPASTE}
+
+// Returns the default location of a clipboard manager's history. CopyQ has no
+// default, since its history has to be exported from CopyQ first.
+func defaultMigratePath(source string) string {
+	switch source {
+	case MIGRATE_CLIPHIST:
+		return filepath.Join(xdg.CacheHome, "cliphist", "db")
+	case MIGRATE_CLIPMAN:
+		return filepath.Join(xdg.DataHome, "clipman.json")
+	case MIGRATE_GPASTE:
+		return filepath.Join(xdg.DataHome, "gpaste", "history.xml")
+	}
+
+	return ""
+}
+
+// Reads the history of another clipboard manager, oldest first. Returns the
+// entries and the number of non-text items that were skipped.
+func readMigrateSource(source, fileName string) ([]exportEntry, int, error) {
+	switch source {
+	case MIGRATE_CLIPHIST:
+		return readCliphist(fileName)
+	case MIGRATE_CLIPMAN:
+		return readClipman(fileName)
+	case MIGRATE_COPYQ:
+		return readCopyQ(fileName)
+	case MIGRATE_GPASTE:
+		return readGPaste(fileName)
+	}
+
+	return nil, 0, fmt.Errorf("unsupported source %v, must be one of %v", source, strings.Join(migrateSources, ", "))
+}
+
+// Reads cliphist's bolt database. Items are stored in the "b" bucket, keyed by
+// an incrementing big-endian id. cliphist doesn't record when items were
+// copied.
+func readCliphist(fileName string) ([]exportEntry, int, error) {
+	if _, err := os.Stat(fileName); err != nil {
+		return nil, 0, fmt.Errorf("failed to read %v: %v", fileName, err.Error())
+	}
+
+	db, err := bolt.Open(fileName, 0o600, &bolt.Options{ReadOnly: true, Timeout: time.Second})
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to open %v (is cliphist running?): %v", fileName, err.Error())
+	}
+	defer db.Close()
+
+	result := []exportEntry{}
+	skipped := 0
+	err = db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte("b"))
+		if b == nil {
+			return fmt.Errorf("bucket b not found, this doesn't look like a cliphist database")
+		}
+
+		return b.ForEach(func(_, v []byte) error {
+			if !utf8.Valid(v) {
+				skipped++
+				return nil
+			}
+
+			result = append(result, exportEntry{Value: string(v)})
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return result, skipped, nil
+}
+
+// Reads clipman's history, which is a JSON array of strings, oldest first.
+func readClipman(fileName string) ([]exportEntry, int, error) {
+	b, err := os.ReadFile(fileName)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to read %v: %v", fileName, err.Error())
+	}
+
+	values := []string{}
+	err = json.Unmarshal(b, &values)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to parse %v: %v", fileName, err.Error())
+	}
+
+	result := make([]exportEntry, len(values))
+	for i, v := range values {
+		result[i] = exportEntry{Value: v}
+	}
+
+	return result, 0, nil
+}
+
+type gpasteHistory struct {
+	Items []gpasteItem `xml:"item"`
+}
+
+type gpasteItem struct {
+	Kind  string `xml:"kind,attr"`
+	Date  string `xml:"date,attr"`
+	Value string `xml:"value"`
+}
+
+// Parses the date attribute of a GPaste item, which is either unix seconds,
+// unix microseconds, or a timestamp depending on the GPaste version.
+func parseGPasteDate(s string) time.Time {
+	if n, err := strconv.ParseInt(s, 10, 64); err == nil {
+		if n > 1e14 {
+			return time.UnixMicro(n)
+		}
+
+		return time.Unix(n, 0)
+	}
+
+	t, _ := time.Parse(time.RFC3339, s)
+
+	return t
+}
+
+// Reads GPaste's XML history, which lists the newest item first.
+func readGPaste(fileName string) ([]exportEntry, int, error) {
+	b, err := os.ReadFile(fileName)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to read %v: %v", fileName, err.Error())
+	}
+
+	var h gpasteHistory
+	err = xml.Unmarshal(b, &h)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to parse %v: %v", fileName, err.Error())
+	}
+
+	result := []exportEntry{}
+	skipped := 0
+	for _, item := range h.Items {
+		switch item.Kind {
+		case "Text", "Uris", "Password", "":
+		default:
+			skipped++
+			continue
+		}
+
+		result = append(result, exportEntry{
+			Value: item.Value,
+			Time:  parseGPasteDate(item.Date),
+		})
+	}
+
+	slices.Reverse(result)
+
+	return result, skipped, nil
+}
+
+// Reads a file created with CopyQ's "Export" feature (a .cpq file). It is a
+// QDataStream containing the header "CopyQ v3" followed by a map whose "tabs"
+// list holds each tab's items, newest first. Only the text/plain format of
+// each item is imported. CopyQ doesn't record when items were copied.
+func readCopyQ(fileName string) ([]exportEntry, int, error) {
+	if fileName == "" {
+		return nil, 0, fmt.Errorf("a path to a file exported from CopyQ is required")
+	}
+
+	b, err := os.ReadFile(fileName)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to read %v: %v", fileName, err.Error())
+	}
+
+	r := &qDataStream{r: bytes.NewReader(b)}
+	header, err := r.byteArray()
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to read header: %v", err.Error())
+	}
+
+	if string(header) != "CopyQ v3" {
+		return nil, 0, fmt.Errorf("unsupported CopyQ export version %q, only CopyQ v3 exports are supported", header)
+	}
+
+	v, err := r.variant()
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to read export data: %v", err.Error())
+	}
+
+	data, _ := v.(map[string]any)
+	tabs, _ := data["tabs"].([]any)
+
+	result := []exportEntry{}
+	skipped := 0
+	for _, t := range tabs {
+		tab, _ := t.(map[string]any)
+		items, _ := tab["items"].([]any)
+
+		tabEntries := []exportEntry{}
+		for _, it := range items {
+			item, _ := it.(map[string]any)
+			text, ok := item["text/plain"].([]byte)
+			if !ok || !utf8.Valid(text) {
+				skipped++
+				continue
+			}
+
+			tabEntries = append(tabEntries, exportEntry{Value: string(text)})
+		}
+
+		slices.Reverse(tabEntries)
+		result = append(result, tabEntries...)
+	}
+
+	return result, skipped, nil
+}
+
+// A minimal reader for Qt's QDataStream serialization format, supporting the
+// types that CopyQ uses in its exports.
+type qDataStream struct {
+	r *bytes.Reader
+}
+
+// QVariant type ids, as defined by QMetaType.
+const (
+	qTypeBool       = 1
+	qTypeInt        = 2
+	qTypeUInt       = 3
+	qTypeLongLong   = 4
+	qTypeULongLong  = 5
+	qTypeDouble     = 6
+	qTypeMap        = 8
+	qTypeList       = 9
+	qTypeString     = 10
+	qTypeStringList = 11
+	qTypeByteArray  = 12
+)
+
+func (q *qDataStream) read(v any) error {
+	return binary.Read(q.r, binary.BigEndian, v)
+}
+
+func (q *qDataStream) uint32() (uint32, error) {
+	var n uint32
+	err := q.read(&n)
+	return n, err
+}
+
+// Reads a length-prefixed byte array. A length of 0xFFFFFFFF is a null array.
+func (q *qDataStream) byteArray() ([]byte, error) {
+	n, err := q.uint32()
+	if err != nil || n == 0xFFFFFFFF {
+		return nil, err
+	}
+
+	if int64(n) > int64(q.r.Len()) {
+		return nil, fmt.Errorf("byte array length %v exceeds remaining data", n)
+	}
+
+	b := make([]byte, n)
+	_, err = q.r.Read(b)
+
+	return b, err
+}
+
+// Reads a length-prefixed UTF-16 string.
+func (q *qDataStream) string() (string, error) {
+	b, err := q.byteArray()
+	if err != nil {
+		return "", err
+	}
+
+	u := make([]uint16, len(b)/2)
+	for i := range u {
+		u[i] = binary.BigEndian.Uint16(b[i*2:])
+	}
+
+	return string(utf16.Decode(u)), nil
+}
+
+// Reads a QVariant: its type id, a null flag, and its value.
+func (q *qDataStream) variant() (any, error) {
+	t, err := q.uint32()
+	if err != nil {
+		return nil, err
+	}
+
+	var isNull uint8
+	err = q.read(&isNull)
+	if err != nil {
+		return nil, err
+	}
+
+	switch t {
+	case qTypeBool:
+		var v uint8
+		err = q.read(&v)
+		return v != 0, err
+	case qTypeInt:
+		var v int32
+		err = q.read(&v)
+		return int64(v), err
+	case qTypeUInt:
+		var v uint32
+		err = q.read(&v)
+		return int64(v), err
+	case qTypeLongLong:
+		var v int64
+		err = q.read(&v)
+		return v, err
+	case qTypeULongLong:
+		var v uint64
+		err = q.read(&v)
+		return int64(v), err
+	case qTypeDouble:
+		var v float64
+		err = q.read(&v)
+		return v, err
+	case qTypeString:
+		return q.string()
+	case qTypeByteArray:
+		return q.byteArray()
+	case qTypeStringList:
+		n, err := q.uint32()
+		if err != nil {
+			return nil, err
+		}
+
+		result := []string{}
+		for i := uint32(0); i < n; i++ {
+			s, err := q.string()
+			if err != nil {
+				return nil, err
+			}
+			result = append(result, s)
+		}
+
+		return result, nil
+	case qTypeList:
+		n, err := q.uint32()
+		if err != nil {
+			return nil, err
+		}
+
+		result := []any{}
+		for i := uint32(0); i < n; i++ {
+			v, err := q.variant()
+			if err != nil {
+				return nil, err
+			}
+			result = append(result, v)
+		}
+
+		return result, nil
+	case qTypeMap:
+		n, err := q.uint32()
+		if err != nil {
+			return nil, err
+		}
+
+		result := make(map[string]any)
+		for i := uint32(0); i < n; i++ {
+			k, err := q.string()
+			if err != nil {
+				return nil, err
+			}
+
+			v, err := q.variant()
+			if err != nil {
+				return nil, err
+			}
+
+			result[k] = v
+		}
+
+		return result, nil
+	}
+
+	return nil, fmt.Errorf("unsupported QVariant type %v", t)
+}
+
+// Gives entries without a time, such as those from cliphist, clipman and
+// CopyQ, increasing times that end at now, so that they keep their order and
+// are treated as the newest entries rather than the oldest.
+func stampMigrateEntries(entries []exportEntry, now time.Time) {
+	untimed := 0
+	for _, e := range entries {
+		if e.Time.IsZero() {
+			untimed++
+		}
+	}
+
+	stamp := now.Add(-time.Duration(untimed) * time.Microsecond)
+	for i := range entries {
+		if entries[i].Time.IsZero() {
+			stamp = stamp.Add(time.Microsecond)
+			entries[i].Time = stamp
+		}
+	}
+}
+
+// Returns how many of the new entries would still be in the history after
+// importing them, once the oldest entries that aren't pinned are dropped to
+// stay within maxEntries. Entries must already have times.
+func migrateSurvivors(entries []exportEntry) int {
+	// the store keeps every entry
+	if storeEnabled() {
+		return len(entries)
+	}
+
+	type item struct {
+		time     time.Time
+		pinned   bool
+		imported bool
+	}
+
+	items := []item{}
+	for _, e := range appConf.Log {
+		items = append(items, item{time: e.Time, pinned: e.Pinned})
+	}
+	for _, e := range entries {
+		items = append(items, item{time: e.Time, pinned: e.Pinned, imported: true})
+	}
+
+	slices.SortStableFunc(items, func(a, b item) int {
+		return a.time.Compare(b.time)
+	})
+
+	drop := len(items) - appConf.MaxEntries
+	kept := 0
+	for _, it := range items {
+		if drop > 0 && !it.pinned {
+			drop--
+			continue
+		}
+
+		if it.imported {
+			kept++
+		}
+	}
+
+	return kept
+}
+
+// Returns a summary of what migrating entries would do, without changing the
+// history.
+func migrateSummary(source, fileName string, entries []exportEntry, skipped int) string {
+	existing := make(map[string]bool)
+	for _, e := range appConf.Log {
+		existing[e.Hash] = true
+	}
+
+	duplicates := 0
+	total := 0
+	var oldest, newest time.Time
+	for _, e := range entries {
+		total += len(e.Value)
+		if existing[hashValue(e.Value)] {
+			duplicates++
+		}
+
+		if e.Time.IsZero() {
+			continue
+		}
+
+		if oldest.IsZero() || e.Time.Before(oldest) {
+			oldest = e.Time
+		}
+
+		if e.Time.After(newest) {
+			newest = e.Time
+		}
+	}
+
+	// values that are already in the history only move the existing entry
+	// when the dedupe mode is global
+	added := []exportEntry{}
+	seen := make(map[string]bool)
+	for _, e := range entries {
+		hash := hashValue(e.Value)
+		if appConf.DedupeMode == DEDUPE_GLOBAL && (existing[hash] || seen[hash]) {
+			continue
+		}
+		seen[hash] = true
+		added = append(added, e)
+	}
+
+	stamped := slices.Clone(added)
+	stampMigrateEntries(stamped, time.Now())
+
+	sb := new(strings.Builder)
+	sb.WriteString(fmt.Sprintf("%v: found %v text entries (%v) in %v\n", source, len(entries), formatBytes(total), fileName))
+	sb.WriteString(fmt.Sprintf("  non-text items skipped: %v\n", skipped))
+	sb.WriteString(fmt.Sprintf("  already in history: %v\n", duplicates))
+	if oldest.IsZero() {
+		sb.WriteString("  timestamps: none recorded, entries are added as the newest\n")
+	} else {
+		sb.WriteString(fmt.Sprintf("  timestamps: %v to %v\n", oldest.Format(time.RFC3339), newest.Format(time.RFC3339)))
+	}
+	sb.WriteString(fmt.Sprintf("  kept within the limit of %v entries: %v/%v\n", appConf.MaxEntries, migrateSurvivors(stamped), len(added)))
+
+	return sb.String()
+}
+
+// Handles the migrate subcommand:
+//
+//	go-fltk-clipboard migrate [-dry-run] <cliphist|clipman|copyq|gpaste> [path]
+//
+// If another instance is running, the entries are handed to it as a JSON
+// Lines import so that its in-memory history stays in sync. Otherwise the
+// config file is modified directly.
+func runMigrate(args []string) error {
+	fs := flag.NewFlagSet("migrate", flag.ExitOnError)
+	dryRun := fs.Bool("dry-run", false, "only print a summary of what would be imported")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "usage: %v migrate [-dry-run] <%v> [path]\n", os.Args[0], strings.Join(migrateSources, "|"))
+		fs.PrintDefaults()
+	}
+
+	err := fs.Parse(args)
+	if err != nil {
+		return err
+	}
+
+	if fs.NArg() < 1 {
+		fs.Usage()
+		return fmt.Errorf("a source is required")
+	}
+
+	source := fs.Arg(0)
+	fileName := fs.Arg(1)
+	if fileName == "" {
+		fileName = defaultMigratePath(source)
+	}
+
+	entries, skipped, err := readMigrateSource(source, fileName)
+	if err != nil {
+		return err
+	}
+
+	//nolint:forbidigo
+	fmt.Print(migrateSummary(source, fileName, entries, skipped))
+
+	if *dryRun {
+		return nil
+	}
+
+	stampMigrateEntries(entries, time.Now())
+
+	tmp, err := os.CreateTemp("", "go-fltk-clipboard-migrate-*.jsonl")
+	if err != nil {
+		return fmt.Errorf("failed to create temp file: %v", err.Error())
+	}
+	defer os.Remove(tmp.Name())
+	tmp.Close()
+
+	lines := new(bytes.Buffer)
+	enc := json.NewEncoder(lines)
+	for _, e := range entries {
+		err = enc.Encode(e)
+		if err != nil {
+			return fmt.Errorf("failed to encode entry: %v", err.Error())
+		}
+	}
+
+	err = os.WriteFile(tmp.Name(), lines.Bytes(), 0o600)
+	if err != nil {
+		return fmt.Errorf("failed to write temp file: %v", err.Error())
+	}
+
+	resp, err := sendIPCCommand(fmt.Sprintf("import %v %v", FORMAT_JSONL, tmp.Name()))
+	if err == nil {
+		if strings.HasPrefix(resp, "error: ") {
+			return fmt.Errorf("%v", strings.TrimPrefix(resp, "error: "))
+		}
+
+		log.Println(resp)
+		return nil
+	}
+
+	log.Printf("no running instance found, using %v directly", configFilePath)
+
+	msg, err := handleExportImport("import", FORMAT_JSONL, tmp.Name(), true)
+	if err != nil {
+		return err
+	}
+
+	log.Println(msg)
+
+	return nil
+}
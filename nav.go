@@ -0,0 +1,231 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/pwiecz/go-fltk"
+)
+
+var (
+	// The row of the log browser that keyboard navigation moves from.
+	cursorRow int
+	// The row that shift-selection extends from.
+	anchorRow int
+	// The digits typed so far while the history has focus. Enter copies the
+	// entry in the row with this number.
+	jumpPrefix string
+	// Which rows were selected before a right-click, so that right-clicking
+	// a selected row keeps the rest of the selection.
+	selectionBeforeMenu []bool
+)
+
+// Returns the number of rows that fit in the log browser at once.
+func visibleRows() int {
+	n := 0
+	for i := max(logBrowser.TopLine(), 1); i <= len(rows) && logBrowser.Displayed(i); i++ {
+		n++
+	}
+
+	return max(n, 1)
+}
+
+// Scrolls the log browser so that the provided row is visible.
+func showRow(i int) {
+	if logBrowser.Displayed(i) {
+		return
+	}
+
+	if i < logBrowser.TopLine() {
+		_ = logBrowser.SetTopLine(i)
+	} else {
+		_ = logBrowser.SetBottomLine(i)
+	}
+}
+
+// Copies the selection state of every row in the log browser to the entries
+// it shows.
+func syncSelection() {
+	for i := 1; i <= len(rows); i++ {
+		appConf.Log[rowEntry(i)].Selected = logBrowser.IsSelected(i)
+	}
+}
+
+// Selects only the entry in the provided row of the log browser, clamped to
+// the rows that exist, and scrolls it into view.
+func selectRow(i int) {
+	moveCursor(i, false)
+}
+
+// Moves the cursor to the provided row, clamped to the rows that exist. If
+// extend is true, every row between the anchor and the cursor is selected,
+// and otherwise only the cursor's row is selected and becomes the anchor.
+func moveCursor(i int, extend bool) {
+	if len(rows) == 0 {
+		return
+	}
+	i = min(max(i, 1), len(rows))
+
+	if !extend || anchorRow < 1 || anchorRow > len(rows) {
+		anchorRow = i
+	}
+	cursorRow = i
+
+	lo, hi := min(anchorRow, i), max(anchorRow, i)
+	for j := range appConf.Log {
+		appConf.Log[j].Selected = false
+	}
+	for r := 1; r <= len(rows); r++ {
+		selected := r >= lo && r <= hi
+		logBrowser.SetSelected(r, selected)
+		appConf.Log[rowEntry(r)].Selected = selected
+	}
+
+	showRow(i)
+	trackSelection()
+}
+
+// Updates the cursor, anchor and selection after the log browser changed
+// its selection by itself, such as on a click or shift-click.
+func historySelectionChanged() {
+	if v := logBrowser.Value(); v > 0 {
+		cursorRow = v
+		if fltk.EventState()&fltk.SHIFT == 0 {
+			anchorRow = v
+		}
+	}
+
+	syncSelection()
+	trackSelection()
+}
+
+// Shows the number typed so far in the status bar.
+func showJumpPrefix() {
+	if jumpPrefix == "" {
+		setStatus("")
+		return
+	}
+
+	setStatus(fmt.Sprintf("press enter to copy entry %v", jumpPrefix))
+}
+
+// Handles events for the log browser: right-clicking a row or pressing the
+// menu key calls contextMenu, and other keys are handled by handleHistoryKey. Returns true if the
+// event was handled.
+func handleHistoryEvent(e fltk.Event, contextMenu func()) bool {
+	switch e {
+	case fltk.PUSH:
+		if fltk.EventButton() != fltk.RightMouse {
+			return false
+		}
+		// the log browser then selects only the clicked row
+		selectionBeforeMenu = make([]bool, len(rows)+1)
+		for i := 1; i <= len(rows); i++ {
+			selectionBeforeMenu[i] = logBrowser.IsSelected(i)
+		}
+		return false
+	case fltk.RELEASE:
+		if fltk.EventButton() != fltk.RightMouse {
+			return false
+		}
+		if v := logBrowser.Value(); v > 0 && v < len(selectionBeforeMenu) && selectionBeforeMenu[v] {
+			for i := 1; i < len(selectionBeforeMenu); i++ {
+				logBrowser.SetSelected(i, selectionBeforeMenu[i])
+			}
+			syncSelection()
+			trackSelection()
+		}
+		selectionBeforeMenu = nil
+		contextMenu()
+		return true
+	case fltk.KEYDOWN:
+		// the menu key or shift+f10 open the menu from the keyboard
+		if fltk.EventKey() == fltk.MENU || (fltk.EventKey() == fltk.F10 && fltk.EventState()&fltk.SHIFT != 0) {
+			contextMenu()
+			return true
+		}
+		return handleHistoryKey()
+	}
+
+	return false
+}
+
+// Handles keys pressed while the log browser has focus: digits followed by
+// enter copy the entry with that number, enter alone copies the entry under
+// the cursor, and shift with home, end, page up or page down extends the
+// selection. Keybindings that type text, such as the vim preset's letters,
+// are dispatched here. Returns true if the key was handled. Other keys are
+// left to the log browser and the keybindings.
+func handleHistoryKey() bool {
+	key := fltk.EventKey()
+	state := fltk.EventState()
+	if state&(fltk.CTRL|fltk.ALT|fltk.META) != 0 {
+		return false
+	}
+
+	if t := fltk.EventText(); len(t) == 1 && t[0] >= '0' && t[0] <= '9' {
+		jumpPrefix += t
+		showJumpPrefix()
+		return true
+	}
+
+	switch key {
+	case fltk.BACKSPACE:
+		if jumpPrefix == "" {
+			return false
+		}
+		jumpPrefix = jumpPrefix[:len(jumpPrefix)-1]
+		showJumpPrefix()
+		return true
+	case fltk.ESCAPE:
+		if jumpPrefix == "" {
+			return false
+		}
+		jumpPrefix = ""
+		showJumpPrefix()
+		return true
+	case fltk.ENTER_KEY:
+		if jumpPrefix != "" {
+			n, _ := strconv.Atoi(jumpPrefix)
+			jumpPrefix = ""
+			if n < 1 || n > len(rows) {
+				setStatus(fmt.Sprintf("there is no entry %v", n))
+				return true
+			}
+			selectRow(n)
+		} else if !logBrowser.IsSelected(cursorRow) {
+			selectRow(cursorRow)
+		}
+		copySelected(appConf.CopyFormat)
+		return true
+	}
+
+	if jumpPrefix != "" {
+		jumpPrefix = ""
+		showJumpPrefix()
+	}
+
+	if h, ok := historyShortcuts[state&fltk.SHIFT|key]; ok {
+		h()
+		return true
+	}
+
+	if state&fltk.SHIFT == 0 {
+		return false
+	}
+
+	switch key {
+	case fltk.HOME:
+		moveCursor(1, true)
+	case fltk.END:
+		moveCursor(len(rows), true)
+	case fltk.PAGE_UP:
+		moveCursor(cursorRow-visibleRows(), true)
+	case fltk.PAGE_DOWN:
+		moveCursor(cursorRow+visibleRows(), true)
+	default:
+		return false
+	}
+
+	return true
+}
@@ -0,0 +1,197 @@
+package main
+
+import (
+	"fmt"
+	"html"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/godbus/dbus/v5"
+)
+
+const (
+	// Kinds of notifications, which are enabled and throttled separately.
+	NOTIFY_CAPTURE   = "capture"
+	NOTIFY_SENSITIVE = "sensitive"
+	NOTIFY_COPY      = "copy"
+	NOTIFY_PEER      = "peer"
+
+	DEFAULT_NOTIFY_THROTTLE = "5s"
+
+	// The longest preview of an entry shown in a notification.
+	NOTIFY_PREVIEW_LENGTH = 100
+
+	NOTIFY_DEST          = "org.freedesktop.Notifications"
+	NOTIFY_PATH          = dbus.ObjectPath("/org/freedesktop/Notifications")
+	NOTIFY_ICON          = "edit-paste"
+	NOTIFY_NAME          = "go-fltk-clipboard"
+	NOTIFY_DESKTOP_ENTRY = "dev.cmcode.go-fltk-clipboard"
+)
+
+// Controls which desktop notifications are shown.
+type NotifyConfig struct {
+	// Notify when something is captured.
+	Capture bool `json:"capture"`
+	// Notify when a captured entry contains one of the configured secrets.
+	Sensitive bool `json:"sensitive"`
+	// Notify when several entries have been copied at once.
+	Copy bool `json:"copy"`
+	// Notify when a peer sent an entry to the clipboard.
+	Peer bool `json:"peer"`
+	// The minimum time between two notifications of the same kind, such as
+	// 5s. Notifications in between are counted and mentioned in the next one.
+	Throttle string `json:"throttle"`
+}
+
+// Limits how often notifications of each kind are shown.
+type notifyThrottle struct {
+	mu sync.Mutex
+	// When the last notification of each kind was shown.
+	last map[string]time.Time
+	// How many notifications of each kind were suppressed since then.
+	suppressed map[string]int
+	// The id of the last notification of each kind, so that the next one
+	// replaces it instead of piling up.
+	ids map[string]uint32
+}
+
+var notifier = &notifyThrottle{
+	last:       make(map[string]time.Time),
+	suppressed: make(map[string]int),
+	ids:        make(map[string]uint32),
+}
+
+// Returns the connection to the bus that notifications are sent over.
+var notifyBus = dbus.SessionBus
+
+// Returns true if a notification of the provided kind may be shown at now,
+// along with the number of notifications of that kind that were suppressed
+// since the last one that was shown.
+func (t *notifyThrottle) allow(kind string, now time.Time, interval time.Duration) (bool, int) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if last, ok := t.last[kind]; ok && now.Sub(last) < interval {
+		t.suppressed[kind]++
+		return false, 0
+	}
+
+	n := t.suppressed[kind]
+	t.last[kind] = now
+	t.suppressed[kind] = 0
+
+	return true, n
+}
+
+// Returns the minimum time between two notifications of the same kind.
+func notifyInterval() time.Duration {
+	if appConf.Notifications.Throttle == "" {
+		return 0
+	}
+
+	d, err := time.ParseDuration(appConf.Notifications.Throttle)
+	if err != nil {
+		log.Printf("invalid notification throttle %q, not throttling: %v", appConf.Notifications.Throttle, err.Error())
+		return 0
+	}
+
+	return d
+}
+
+// Returns true if notifications of the provided kind are enabled.
+func notifyEnabled(kind string) bool {
+	switch kind {
+	case NOTIFY_CAPTURE:
+		return appConf.Notifications.Capture
+	case NOTIFY_SENSITIVE:
+		return appConf.Notifications.Sensitive
+	case NOTIFY_COPY:
+		return appConf.Notifications.Copy
+	case NOTIFY_PEER:
+		return appConf.Notifications.Peer
+	}
+
+	return false
+}
+
+// Returns a short preview of value for a notification's body, with secrets
+// obscured. Notification servers may interpret the body as markup, so it is
+// escaped.
+func notifyPreview(value string) string {
+	v := obscure(value, appConf.Secrets)
+	v = strings.Join(strings.Fields(v), " ")
+	if r := []rune(v); len(r) > NOTIFY_PREVIEW_LENGTH {
+		v = string(r[:NOTIFY_PREVIEW_LENGTH]) + "…"
+	}
+
+	return html.EscapeString(v)
+}
+
+// Shows a desktop notification of the provided kind in the background, if it
+// is enabled and not throttled.
+func notify(kind, summary, body string) {
+	if !notifyEnabled(kind) {
+		return
+	}
+
+	ok, suppressed := notifier.allow(kind, time.Now(), notifyInterval())
+	if !ok {
+		return
+	}
+
+	if suppressed > 0 {
+		body = fmt.Sprintf("%v\n(%v more since the last notification)", body, suppressed)
+	}
+
+	go func() {
+		conn, err := notifyBus()
+		if err != nil {
+			log.Printf("failed to connect to the session bus: %v", err.Error())
+			return
+		}
+
+		err = sendNotification(conn, kind, summary, body)
+		if err != nil {
+			log.Printf("failed to show notification: %v", err.Error())
+		}
+	}()
+}
+
+// Sends a notification over conn, replacing the previous notification of the
+// same kind.
+func sendNotification(conn *dbus.Conn, kind, summary, body string) error {
+	notifier.mu.Lock()
+	replaces := notifier.ids[kind]
+	notifier.mu.Unlock()
+
+	hints := map[string]dbus.Variant{
+		"desktop-entry": dbus.MakeVariant(NOTIFY_DESKTOP_ENTRY),
+	}
+
+	var id uint32
+	err := conn.Object(NOTIFY_DEST, NOTIFY_PATH).Call(
+		NOTIFY_DEST+".Notify", 0,
+		NOTIFY_NAME, replaces, NOTIFY_ICON, summary, body, []string{}, hints, int32(-1),
+	).Store(&id)
+	if err != nil {
+		return err
+	}
+
+	notifier.mu.Lock()
+	notifier.ids[kind] = id
+	notifier.mu.Unlock()
+
+	return nil
+}
+
+// Shows the notifications for a newly captured entry.
+func notifyCaptured(e ClipboardEntry) {
+	if isSensitive(e) {
+		notify(NOTIFY_SENSITIVE, "Sensitive entry captured", notifyPreview(entryValue(e)))
+		return
+	}
+
+	notify(NOTIFY_CAPTURE, "Clipboard captured", notifyPreview(entryValue(e)))
+}
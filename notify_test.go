@@ -0,0 +1,198 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/godbus/dbus/v5"
+)
+
+// A minimal bus config that lets every connection own names and call every
+// method.
+const testBusConfig = `<!DOCTYPE busconfig PUBLIC "-//freedesktop//DTD D-Bus Bus Configuration 1.0//EN"
+ "http://www.freedesktop.org/standards/dbus/1.0/busconfig.dtd">
+<busconfig>
+  <type>session</type>
+  <listen>unix:dir=%v</listen>
+  <auth>EXTERNAL</auth>
+  <policy context="default">
+    <allow send_destination="*" eavesdrop="true"/>
+    <allow eavesdrop="true"/>
+    <allow own="*"/>
+  </policy>
+</busconfig>
+`
+
+// A received call of the fake notification server's Notify method.
+type fakeNotification struct {
+	replaces uint32
+	summary  string
+	body     string
+}
+
+// Implements the Notify method of org.freedesktop.Notifications, recording
+// every call.
+type fakeNotifications struct {
+	mu    sync.Mutex
+	calls []fakeNotification
+	next  uint32
+}
+
+func (f *fakeNotifications) Notify(app string, replaces uint32, icon, summary, body string, actions []string, hints map[string]dbus.Variant, timeout int32) (uint32, *dbus.Error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.calls = append(f.calls, fakeNotification{replaces: replaces, summary: summary, body: body})
+	if replaces != 0 {
+		return replaces, nil
+	}
+
+	f.next++
+	return f.next, nil
+}
+
+// Waits until n notifications were received and returns them.
+func (f *fakeNotifications) wait(t *testing.T, n int) []fakeNotification {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		f.mu.Lock()
+		calls := append([]fakeNotification{}, f.calls...)
+		f.mu.Unlock()
+
+		if len(calls) >= n {
+			return calls
+		}
+
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	t.Fatalf("timed out waiting for %v notifications", n)
+	return nil
+}
+
+// Starts a private bus and returns its address. The bus is stopped when the
+// test ends.
+func startTestBus(t *testing.T) string {
+	t.Helper()
+
+	daemon, err := exec.LookPath("dbus-daemon")
+	if err != nil {
+		t.Skip("dbus-daemon is not installed")
+	}
+
+	dir := t.TempDir()
+	config := filepath.Join(dir, "bus.conf")
+	err = os.WriteFile(config, []byte(fmt.Sprintf(testBusConfig, dir)), 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cmd := exec.Command(daemon, "--config-file="+config, "--nofork", "--print-address=1")
+	out, err := cmd.StdoutPipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = cmd.Start()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_ = cmd.Process.Kill()
+		_ = cmd.Wait()
+	})
+
+	addr, err := bufio.NewReader(out).ReadString('\n')
+	if err != nil {
+		t.Fatalf("failed to read the bus address: %v", err)
+	}
+
+	return strings.TrimSpace(addr)
+}
+
+func TestNotify(t *testing.T) {
+	addr := startTestBus(t)
+
+	server, err := dbus.Connect(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+
+	fake := &fakeNotifications{}
+	err = server.Export(fake, NOTIFY_PATH, NOTIFY_DEST)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	reply, err := server.RequestName(NOTIFY_DEST, dbus.NameFlagDoNotQueue)
+	if err != nil || reply != dbus.RequestNameReplyPrimaryOwner {
+		t.Fatalf("failed to own %v: %v", NOTIFY_DEST, err)
+	}
+
+	client, err := dbus.Connect(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	oldConf := appConf.Notifications
+	defer func() { appConf.Notifications = oldConf }()
+
+	// notifyBus isn't restored, since the goroutine sending the last
+	// notification may still be using it, and no other test enables
+	// notifications
+	notifyBus = func() (*dbus.Conn, error) { return client, nil }
+	notifier.mu.Lock()
+	clear(notifier.last)
+	clear(notifier.suppressed)
+	clear(notifier.ids)
+	notifier.mu.Unlock()
+	appConf.Notifications = NotifyConfig{Capture: true, Copy: true, Throttle: "300ms"}
+
+	notify(NOTIFY_CAPTURE, "first", "one")
+	calls := fake.wait(t, 1)
+	if calls[0].replaces != 0 || calls[0].summary != "first" || calls[0].body != "one" {
+		t.Errorf("unexpected first notification %+v", calls[0])
+	}
+
+	// these are throttled, and only counted
+	notify(NOTIFY_CAPTURE, "second", "two")
+	notify(NOTIFY_CAPTURE, "third", "three")
+
+	// other kinds are throttled separately
+	notify(NOTIFY_COPY, "copied", "copy")
+	calls = fake.wait(t, 2)
+	if calls[1].summary != "copied" || calls[1].replaces != 0 {
+		t.Errorf("unexpected notification of another kind %+v", calls[1])
+	}
+
+	// disabled kinds are never sent
+	notify(NOTIFY_PEER, "peer", "peer")
+
+	time.Sleep(400 * time.Millisecond)
+
+	notify(NOTIFY_CAPTURE, "fourth", "four")
+	calls = fake.wait(t, 3)
+	if len(calls) != 3 {
+		t.Fatalf("expected 3 notifications, got %+v", calls)
+	}
+
+	want := fakeNotification{
+		replaces: 1,
+		summary:  "fourth",
+		body:     "four\n(2 more since the last notification)",
+	}
+	if calls[2] != want {
+		t.Errorf("got %+v, want %+v", calls[2], want)
+	}
+}
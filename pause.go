@@ -0,0 +1,170 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/pwiecz/go-fltk"
+)
+
+// The default duration for a timed pause, such as the one triggered by
+// ctrl+shift+p.
+const DEFAULT_PAUSE_MINUTES = 5
+
+var (
+	// Guards paused and pausedUntil, since they are read by the capture
+	// goroutine and the IPC listener, and written to on the ui thread.
+	pauseMu sync.Mutex
+	// When true, the clipboard capture goroutine will not record any new
+	// entries.
+	paused bool
+	// If non-zero, capturing will automatically resume at this time.
+	pausedUntil time.Time
+)
+
+// Suspends clipboard capturing. If d is 0, capturing stays paused until
+// resumeCapture is called. Otherwise, capturing resumes automatically after d.
+// Like resumeCapture, it must be called on the ui thread.
+func pauseCapture(d time.Duration) {
+	pauseMu.Lock()
+	paused = true
+	pausedUntil = time.Time{}
+	if d > 0 {
+		pausedUntil = time.Now().Add(d)
+	}
+	pauseMu.Unlock()
+
+	log.Printf("clipboard capture paused (%v)", pauseStatus())
+	syncPauseConfig()
+	updatePauseIndicator()
+}
+
+// Resumes clipboard capturing if it was paused.
+func resumeCapture() {
+	pauseMu.Lock()
+	paused = false
+	pausedUntil = time.Time{}
+	pauseMu.Unlock()
+
+	log.Println("clipboard capture resumed")
+	syncPauseConfig()
+	updatePauseIndicator()
+}
+
+// Pauses capturing indefinitely if it is currently running, otherwise resumes
+// it.
+func togglePause() {
+	if isPaused() {
+		resumeCapture()
+		return
+	}
+
+	pauseCapture(0)
+}
+
+// Returns true if clipboard capturing is currently paused. If a timed pause
+// has expired, capturing is resumed and false is returned. Must be called on
+// the ui thread, since resuming changes the config.
+func isPaused() bool {
+	expired := pauseExpired()
+	p := isPausedNoExpire()
+
+	if expired {
+		resumeCapture()
+		return false
+	}
+
+	return p
+}
+
+// Returns a short human-readable description of the current pause state.
+func pauseStatus() string {
+	pauseMu.Lock()
+	defer pauseMu.Unlock()
+
+	if !paused {
+		return "capturing"
+	}
+
+	if pausedUntil.IsZero() {
+		return "paused"
+	}
+
+	return fmt.Sprintf("paused until %v", pausedUntil.Format("15:04:05"))
+}
+
+// Writes the current pause state to the app config, but only if the user has
+// opted in to persisting it between restarts. Otherwise the pause state is
+// cleared from the config so that it is never saved. Must be called on the ui
+// thread, which saves the config.
+func syncPauseConfig() {
+	pauseMu.Lock()
+	defer pauseMu.Unlock()
+
+	if !appConf.PersistPause {
+		appConf.Paused = false
+		appConf.PausedUntil = time.Time{}
+		return
+	}
+
+	appConf.Paused = paused
+	appConf.PausedUntil = pausedUntil
+}
+
+// Restores a pause state that was persisted in the app config, as long as the
+// user opted in to persisting it and it hasn't already expired.
+func restorePauseConfig() {
+	if !appConf.PersistPause || !appConf.Paused {
+		return
+	}
+
+	if !appConf.PausedUntil.IsZero() && time.Now().After(appConf.PausedUntil) {
+		syncPauseConfig()
+		return
+	}
+
+	pauseMu.Lock()
+	paused = true
+	pausedUntil = appConf.PausedUntil
+	pauseMu.Unlock()
+}
+
+// Reflects the current pause state in the window title, the pause button, and
+// the status shown in the log browser's label. The pause state also changes
+// from the ipc listener and the capture goroutine, so the widgets are updated
+// on the ui thread.
+func updatePauseIndicator() {
+	if win == nil || pauseBtn == nil {
+		return
+	}
+
+	fltk.Awake(func() {
+		if isPausedNoExpire() {
+			win.SetLabel(fmt.Sprintf("%v (%v)", WINDOW_TITLE, pauseStatus()))
+			pauseBtn.SetLabel("&Resume")
+		} else {
+			win.SetLabel(WINDOW_TITLE)
+			pauseBtn.SetLabel("&Pause")
+		}
+
+		refreshStatus()
+	})
+}
+
+// Returns true if capturing is paused by a timed pause that has expired.
+func pauseExpired() bool {
+	pauseMu.Lock()
+	defer pauseMu.Unlock()
+
+	return paused && !pausedUntil.IsZero() && time.Now().After(pausedUntil)
+}
+
+// Same as isPaused, but does not resume capturing if a timed pause expired.
+func isPausedNoExpire() bool {
+	pauseMu.Lock()
+	defer pauseMu.Unlock()
+
+	return paused
+}
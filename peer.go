@@ -0,0 +1,641 @@
+package main
+
+import (
+	"bufio"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/atotto/clipboard"
+	"github.com/hashicorp/mdns"
+	"github.com/pwiecz/go-fltk"
+)
+
+const (
+	// The mDNS service that instances in peer mode advertise and browse for.
+	PEER_SERVICE = "_go-fltk-clipboard._tcp"
+	// The TCP port that peer mode listens on, unless overridden by port in
+	// the peer config.
+	DEFAULT_PEER_PORT = 7732
+	// How often the local network is browsed for peers. Peers that weren't
+	// seen for a few intervals are forgotten.
+	PEER_DISCOVERY_INTERVAL = 30 * time.Second
+	PEER_DISCOVERY_TIMEOUT  = 2 * time.Second
+	// How long connecting to, authenticating with and sending to a peer may
+	// take.
+	PEER_TIMEOUT = 10 * time.Second
+	// The largest encrypted message that is accepted from a peer.
+	PEER_MAX_MESSAGE_BYTES = 64 << 20
+	// Mixed into every key derived from the secret, so that the keys can't
+	// be reused by another protocol or version.
+	PEER_PROTOCOL = "go-fltk-clipboard-peer-1"
+	// The length in bytes of each side's random challenge.
+	PEER_NONCE_BYTES = 32
+)
+
+// Configures peer mode, in which entries can be sent directly to other
+// instances on the local network.
+type PeerConfig struct {
+	// If true, this instance listens for entries from peers and advertises
+	// itself on the local network.
+	Enabled bool `json:"enabled"`
+	// The name that peers show for this instance. Defaults to the host name.
+	Name string `json:"name"`
+	// The TCP port to listen on.
+	Port int `json:"port"`
+	// Peers must use the same secret. One is generated when peer mode is
+	// first enabled without one.
+	Secret string `json:"secret"`
+	// Peers that are always offered, as host:port, such as peers that mDNS
+	// can't reach.
+	Hosts []string `json:"hosts"`
+}
+
+// Another instance that was found on the local network.
+type peer struct {
+	Name string
+	Addr string
+	Seen time.Time
+}
+
+// Sent to a peer, and sent back as its response.
+type peerMessage struct {
+	// The name of the sending instance.
+	From string `json:"from"`
+	// The entry to copy to the receiving instance's clipboard.
+	Entry *syncEntry `json:"entry,omitempty"`
+	// In a response, why the entry wasn't accepted.
+	Error string `json:"error,omitempty"`
+}
+
+// A connection with a peer that has proven it knows the secret. Each direction
+// uses its own key, and each message's nonce is a counter.
+type peerConn struct {
+	conn     net.Conn
+	r        *bufio.Reader
+	sendAEAD cipher.AEAD
+	recvAEAD cipher.AEAD
+	sendSeq  uint64
+	recvSeq  uint64
+}
+
+var (
+	peerMu sync.Mutex
+	// Nil unless peer mode is running.
+	peerListener net.Listener
+	peerServer   *mdns.Server
+	peerStop     chan struct{}
+	// Identifies this run of the app in its mDNS advertisement, so that it
+	// doesn't discover itself.
+	peerID string
+	// Peers found through mDNS, by their id.
+	discoveredPeers = map[string]peer{}
+)
+
+// Copies an entry received from a peer to the clipboard.
+var peerClipboardWrite = clipboard.WriteAll
+
+// Returns the name that peers show for this instance.
+func peerName() string {
+	if appConf.Peer.Name != "" {
+		return appConf.Peer.Name
+	}
+
+	host, err := os.Hostname()
+	if err != nil || host == "" {
+		return "go-fltk-clipboard"
+	}
+
+	return host
+}
+
+// Returns a new random secret for pairing peers.
+func newPeerSecret() string {
+	b := make([]byte, 24)
+	_, _ = rand.Read(b)
+
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// Returns true if peer mode is running.
+func peerActive() bool {
+	peerMu.Lock()
+	defer peerMu.Unlock()
+
+	return peerListener != nil
+}
+
+// Starts listening for entries from peers, advertising this instance on the
+// local network and browsing for other instances.
+func startPeer() error {
+	if appConf.Peer.Secret == "" {
+		// the secret is only written to the config file, never to the log.
+		// The config is saved on the ui thread, which owns the history.
+		appConf.Peer.Secret = newPeerSecret()
+		fltk.Awake(func() {
+			err := saveConfig(configFilePath, &appConf)
+			if err != nil {
+				log.Printf("failed to save the generated peer secret: %v", err.Error())
+				return
+			}
+			log.Printf("generated a peer secret and saved it to %v, set the same secret on the other machines", configFilePath)
+		})
+	}
+
+	if appConf.Peer.Port == 0 {
+		appConf.Peer.Port = DEFAULT_PEER_PORT
+	}
+
+	l, err := net.Listen("tcp", fmt.Sprintf(":%v", appConf.Peer.Port))
+	if err != nil {
+		return fmt.Errorf("failed to listen for peers on port %v: %v", appConf.Peer.Port, err.Error())
+	}
+
+	peerMu.Lock()
+	peerListener = l
+	peerStop = make(chan struct{})
+	peerID = newEntryID()
+	peerMu.Unlock()
+
+	log.Printf("listening for peers on %v as %v", l.Addr(), peerName())
+
+	go func(secret string) {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+
+			go handlePeerConn(conn, secret)
+		}
+	}(appConf.Peer.Secret)
+
+	err = advertisePeer()
+	if err != nil {
+		// peers can still be reached through their configured hosts
+		log.Printf("peers will not be discovered on the local network: %v", err.Error())
+		return nil
+	}
+
+	go func(stop chan struct{}) {
+		for {
+			discoverPeers()
+
+			select {
+			case <-stop:
+				return
+			case <-time.After(PEER_DISCOVERY_INTERVAL):
+			}
+		}
+	}(peerStop)
+
+	return nil
+}
+
+// Stops listening for peers and advertising this instance.
+func stopPeer() {
+	peerMu.Lock()
+	defer peerMu.Unlock()
+
+	if peerListener == nil {
+		return
+	}
+
+	err := peerListener.Close()
+	if err != nil {
+		log.Printf("failed to close peer listener: %v", err.Error())
+	}
+	peerListener = nil
+
+	if peerServer != nil {
+		err = peerServer.Shutdown()
+		if err != nil {
+			log.Printf("failed to stop advertising to peers: %v", err.Error())
+		}
+		peerServer = nil
+	}
+
+	close(peerStop)
+	discoveredPeers = map[string]peer{}
+}
+
+// Returns the non-loopback IPv4 addresses of this machine, or the loopback
+// address if there are none, for the mDNS advertisement.
+func localIPs() []net.IP {
+	ips := []net.IP{}
+	addrs, err := net.InterfaceAddrs()
+	if err == nil {
+		for _, a := range addrs {
+			n, ok := a.(*net.IPNet)
+			if ok && !n.IP.IsLoopback() && n.IP.To4() != nil {
+				ips = append(ips, n.IP)
+			}
+		}
+	}
+
+	if len(ips) == 0 {
+		ips = append(ips, net.IPv4(127, 0, 0, 1))
+	}
+
+	return ips
+}
+
+// Advertises this instance on the local network through mDNS.
+func advertisePeer() error {
+	host, err := os.Hostname()
+	if err != nil || host == "" {
+		host = "localhost"
+	}
+
+	// the instance name must be unique on the network, and the id lets
+	// this instance ignore its own advertisement
+	txt := []string{"id=" + peerID, "name=" + peerName()}
+	service, err := mdns.NewMDNSService(peerName()+"-"+peerID[:8], PEER_SERVICE, "", host+".", appConf.Peer.Port, localIPs(), txt)
+	if err != nil {
+		return fmt.Errorf("failed to create mdns service: %v", err.Error())
+	}
+
+	server, err := mdns.NewServer(&mdns.Config{Zone: service})
+	if err != nil {
+		return fmt.Errorf("failed to start mdns server: %v", err.Error())
+	}
+
+	peerMu.Lock()
+	peerServer = server
+	peerMu.Unlock()
+
+	return nil
+}
+
+// Browses the local network for other instances and remembers the ones that
+// were found.
+func discoverPeers() {
+	entries := make(chan *mdns.ServiceEntry, 16)
+	found := []peer{}
+	ids := []string{}
+	done := make(chan struct{})
+
+	go func() {
+		defer close(done)
+		for e := range entries {
+			fields := map[string]string{}
+			for _, f := range e.InfoFields {
+				k, v, _ := strings.Cut(f, "=")
+				fields[k] = v
+			}
+
+			if fields["id"] == "" || fields["id"] == peerID || e.AddrV4 == nil {
+				continue
+			}
+
+			found = append(found, peer{
+				Name: fields["name"],
+				Addr: net.JoinHostPort(e.AddrV4.String(), strconv.Itoa(e.Port)),
+				Seen: time.Now(),
+			})
+			ids = append(ids, fields["id"])
+		}
+	}()
+
+	params := mdns.DefaultParams(PEER_SERVICE)
+	params.Entries = entries
+	params.Timeout = PEER_DISCOVERY_TIMEOUT
+	params.DisableIPv6 = true
+	err := mdns.Query(params)
+	close(entries)
+	<-done
+	if err != nil {
+		log.Printf("failed to browse for peers: %v", err.Error())
+		return
+	}
+
+	peerMu.Lock()
+	defer peerMu.Unlock()
+
+	for i, p := range found {
+		discoveredPeers[ids[i]] = p
+	}
+
+	for id, p := range discoveredPeers {
+		if time.Since(p.Seen) > 3*PEER_DISCOVERY_INTERVAL {
+			delete(discoveredPeers, id)
+		}
+	}
+}
+
+// Returns the peers that entries can be sent to: the configured hosts,
+// followed by the discovered peers sorted by name.
+func knownPeers() []peer {
+	peers := []peer{}
+	for _, h := range appConf.Peer.Hosts {
+		peers = append(peers, peer{Name: h, Addr: h})
+	}
+
+	peerMu.Lock()
+	discovered := []peer{}
+	for _, p := range discoveredPeers {
+		discovered = append(discovered, p)
+	}
+	peerMu.Unlock()
+
+	slices.SortFunc(discovered, func(a, b peer) int {
+		return strings.Compare(a.Name+" "+a.Addr, b.Name+" "+b.Addr)
+	})
+
+	return append(peers, discovered...)
+}
+
+// Derives a key for purpose from the secret and both sides' challenges.
+func peerKey(secret, purpose string, clientNonce, serverNonce []byte) []byte {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(PEER_PROTOCOL + " " + purpose))
+	mac.Write(clientNonce)
+	mac.Write(serverNonce)
+
+	return mac.Sum(nil)
+}
+
+// Returns an AES-GCM cipher using key.
+func newPeerAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return cipher.NewGCM(block)
+}
+
+// Authenticates the other side of conn. Both sides send a random challenge,
+// and each proves that it knows the secret by sending a key derived from both
+// challenges, which also means that neither proof can be replayed. The client
+// proves itself first, and the server only sends its proof after verifying
+// the client's, so that connecting to a peer reveals nothing that could be
+// used to guess the secret. The messages that follow are encrypted with keys
+// derived the same way.
+func peerHandshake(conn net.Conn, secret string, client bool) (*peerConn, error) {
+	r := bufio.NewReader(conn)
+	ours := make([]byte, PEER_NONCE_BYTES)
+	_, _ = rand.Read(ours)
+
+	theirs := make([]byte, PEER_NONCE_BYTES)
+	proof := make([]byte, sha256.Size)
+
+	var clientNonce, serverNonce []byte
+	if client {
+		_, err := conn.Write(ours)
+		if err != nil {
+			return nil, fmt.Errorf("failed to send challenge: %v", err.Error())
+		}
+
+		_, err = io.ReadFull(r, theirs)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read challenge: %v", err.Error())
+		}
+
+		clientNonce, serverNonce = ours, theirs
+		_, err = conn.Write(peerKey(secret, "client proof", clientNonce, serverNonce))
+		if err != nil {
+			return nil, fmt.Errorf("failed to send proof: %v", err.Error())
+		}
+
+		// the server closes the connection instead if the proof was wrong
+		_, err = io.ReadFull(r, proof)
+		if err != nil {
+			return nil, fmt.Errorf("the peer rejected the secret: %v", err.Error())
+		}
+
+		if !hmac.Equal(proof, peerKey(secret, "server proof", clientNonce, serverNonce)) {
+			return nil, fmt.Errorf("the peer doesn't know the secret")
+		}
+	} else {
+		_, err := io.ReadFull(r, theirs)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read challenge: %v", err.Error())
+		}
+
+		clientNonce, serverNonce = theirs, ours
+		_, err = conn.Write(ours)
+		if err != nil {
+			return nil, fmt.Errorf("failed to send challenge: %v", err.Error())
+		}
+
+		_, err = io.ReadFull(r, proof)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read proof: %v", err.Error())
+		}
+
+		if !hmac.Equal(proof, peerKey(secret, "client proof", clientNonce, serverNonce)) {
+			return nil, fmt.Errorf("the peer doesn't know the secret")
+		}
+
+		_, err = conn.Write(peerKey(secret, "server proof", clientNonce, serverNonce))
+		if err != nil {
+			return nil, fmt.Errorf("failed to send proof: %v", err.Error())
+		}
+	}
+
+	toServer, err := newPeerAEAD(peerKey(secret, "client to server", clientNonce, serverNonce))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create cipher: %v", err.Error())
+	}
+
+	toClient, err := newPeerAEAD(peerKey(secret, "server to client", clientNonce, serverNonce))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create cipher: %v", err.Error())
+	}
+
+	pc := &peerConn{conn: conn, r: r, sendAEAD: toClient, recvAEAD: toServer}
+	if client {
+		pc.sendAEAD, pc.recvAEAD = toServer, toClient
+	}
+
+	return pc, nil
+}
+
+// Returns the nonce for the message with the provided sequence number.
+func peerNonce(aead cipher.AEAD, seq uint64) []byte {
+	nonce := make([]byte, aead.NonceSize())
+	binary.BigEndian.PutUint64(nonce[len(nonce)-8:], seq)
+
+	return nonce
+}
+
+// Encrypts and sends a message, prefixed by its length.
+func (c *peerConn) write(m peerMessage) error {
+	b, err := json.Marshal(m)
+	if err != nil {
+		return fmt.Errorf("failed to encode message: %v", err.Error())
+	}
+
+	sealed := c.sendAEAD.Seal(nil, peerNonce(c.sendAEAD, c.sendSeq), b, nil)
+	c.sendSeq++
+
+	frame := binary.BigEndian.AppendUint32(nil, uint32(len(sealed)))
+	_, err = c.conn.Write(append(frame, sealed...))
+	if err != nil {
+		return fmt.Errorf("failed to send message: %v", err.Error())
+	}
+
+	return nil
+}
+
+// Receives and decrypts a message.
+func (c *peerConn) read() (peerMessage, error) {
+	m := peerMessage{}
+
+	var size uint32
+	err := binary.Read(c.r, binary.BigEndian, &size)
+	if err != nil {
+		return m, fmt.Errorf("failed to read message: %v", err.Error())
+	}
+	if size > PEER_MAX_MESSAGE_BYTES {
+		return m, fmt.Errorf("message of %v is too large", formatBytes(int(size)))
+	}
+
+	sealed := make([]byte, size)
+	_, err = io.ReadFull(c.r, sealed)
+	if err != nil {
+		return m, fmt.Errorf("failed to read message: %v", err.Error())
+	}
+
+	b, err := c.recvAEAD.Open(nil, peerNonce(c.recvAEAD, c.recvSeq), sealed, nil)
+	if err != nil {
+		return m, fmt.Errorf("failed to decrypt message: %v", err.Error())
+	}
+	c.recvSeq++
+
+	err = json.Unmarshal(b, &m)
+	if err != nil {
+		return m, fmt.Errorf("failed to decode message: %v", err.Error())
+	}
+
+	return m, nil
+}
+
+// Receives an entry from a peer that knows secret and copies it to the
+// clipboard, where it is captured like anything else that is copied.
+func handlePeerConn(conn net.Conn, secret string) {
+	defer conn.Close()
+	_ = conn.SetDeadline(time.Now().Add(PEER_TIMEOUT))
+
+	pc, err := peerHandshake(conn, secret, false)
+	if err != nil {
+		log.Printf("rejected peer %v: %v", conn.RemoteAddr(), err.Error())
+		return
+	}
+
+	m, err := pc.read()
+	if err != nil {
+		log.Printf("failed to receive from peer %v: %v", conn.RemoteAddr(), err.Error())
+		return
+	}
+
+	resp := peerMessage{From: peerName()}
+	if m.Entry == nil {
+		resp.Error = "no entry was sent"
+	} else if err := peerClipboardWrite(m.Entry.Value); err != nil {
+		resp.Error = fmt.Sprintf("failed to write to clipboard: %v", err.Error())
+	}
+
+	if resp.Error != "" {
+		log.Printf("failed to receive from peer %v: %v", m.From, resp.Error)
+	} else {
+		msg := fmt.Sprintf("received %v from %v", formatBytes(len(m.Entry.Value)), m.From)
+		log.Println(msg)
+		notify(NOTIFY_PEER, fmt.Sprintf("Received from %v", m.From), notifyPreview(m.Entry.Value))
+		onUIThread(func() { setStatus(msg) })
+	}
+
+	err = pc.write(resp)
+	if err != nil {
+		log.Printf("failed to respond to peer %v: %v", m.From, err.Error())
+	}
+}
+
+// Sends an entry to the peer at addr, which copies it to its clipboard.
+// Returns the peer's name.
+func sendToPeer(addr string, e ClipboardEntry) (string, error) {
+	conn, err := net.DialTimeout("tcp", addr, PEER_TIMEOUT)
+	if err != nil {
+		return "", fmt.Errorf("failed to connect to %v: %v", addr, err.Error())
+	}
+	defer conn.Close()
+
+	_ = conn.SetDeadline(time.Now().Add(PEER_TIMEOUT))
+
+	pc, err := peerHandshake(conn, appConf.Peer.Secret, true)
+	if err != nil {
+		return "", fmt.Errorf("failed to authenticate with %v: %v", addr, err.Error())
+	}
+
+	content := toSyncEntry(e)
+	err = pc.write(peerMessage{From: peerName(), Entry: &content})
+	if err != nil {
+		return "", err
+	}
+
+	resp, err := pc.read()
+	if err != nil {
+		return "", err
+	}
+
+	if resp.Error != "" {
+		return resp.From, fmt.Errorf("%v refused the entry: %v", resp.From, resp.Error)
+	}
+
+	return resp.From, nil
+}
+
+// Adds items to m for sending the entry at index i to each known peer.
+func addPeerItems(m *fltk.MenuButton, i int) {
+	if i < 0 || !peerActive() {
+		return
+	}
+
+	peers := knownPeers()
+	if len(peers) == 0 {
+		m.AddEx("Send To Peer/No Peers Found", 0, nil, fltk.MENU_INACTIVE)
+		return
+	}
+
+	e := appConf.Log[i]
+	for _, p := range peers {
+		label := p.Name
+		if p.Name != p.Addr {
+			label = fmt.Sprintf("%v (%v)", p.Name, p.Addr)
+		}
+
+		m.Add("Send To Peer/"+menuLabel(label), func() {
+			setStatus(fmt.Sprintf("sending to %v...", p.Name))
+
+			// sending may take a while, so it doesn't block the interface
+			go func() {
+				name, err := sendToPeer(p.Addr, e)
+				fltk.Awake(func() {
+					if err != nil {
+						log.Printf("failed to send to peer: %v", err.Error())
+						setStatus(fmt.Sprintf("failed to send to %v", p.Name))
+						return
+					}
+
+					setStatus(fmt.Sprintf("sent %v to %v", formatBytes(len(entryValue(e))), name))
+				})
+			}()
+		})
+	}
+}
@@ -0,0 +1,181 @@
+package main
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+// Runs the handshake on both ends of a pipe, and returns each side's result.
+func pipeHandshake(t *testing.T, clientSecret, serverSecret string) (*peerConn, error, *peerConn, error) {
+	t.Helper()
+
+	client, server := net.Pipe()
+	t.Cleanup(func() {
+		client.Close()
+		server.Close()
+	})
+
+	type result struct {
+		pc  *peerConn
+		err error
+	}
+	done := make(chan result)
+	go func() {
+		pc, err := peerHandshake(server, serverSecret, false)
+		if err != nil {
+			// a client waiting for the server's proof sees the connection
+			// close instead
+			server.Close()
+		}
+		done <- result{pc, err}
+	}()
+
+	_ = client.SetDeadline(time.Now().Add(5 * time.Second))
+	cpc, cerr := peerHandshake(client, clientSecret, true)
+	if cerr != nil {
+		client.Close()
+	}
+	s := <-done
+
+	return cpc, cerr, s.pc, s.err
+}
+
+func TestPeerHandshake(t *testing.T) {
+	client, cerr, server, serr := pipeHandshake(t, "a long shared secret", "a long shared secret")
+	if cerr != nil || serr != nil {
+		t.Fatalf("handshake failed: client %v, server %v", cerr, serr)
+	}
+
+	// messages are encrypted in both directions, in order
+	go func() {
+		for _, v := range []string{"one", "two"} {
+			_ = client.write(peerMessage{From: "client", Entry: &syncEntry{Value: v}})
+		}
+	}()
+	for _, want := range []string{"one", "two"} {
+		m, err := server.read()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if m.From != "client" || m.Entry == nil || m.Entry.Value != want {
+			t.Errorf("server received %+v, want %v", m, want)
+		}
+	}
+
+	go func() { _ = server.write(peerMessage{From: "server"}) }()
+	m, err := client.read()
+	if err != nil || m.From != "server" {
+		t.Errorf("client received %+v, %v", m, err)
+	}
+}
+
+func TestPeerHandshakeWrongSecret(t *testing.T) {
+	client, cerr, server, serr := pipeHandshake(t, "guessed", "a long shared secret")
+	if client != nil || cerr == nil {
+		t.Errorf("the client accepted a server with another secret")
+	}
+	if server != nil || serr == nil {
+		t.Errorf("the server accepted a client with another secret")
+	}
+}
+
+func TestPeerServerProvesLast(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	defer server.Close()
+
+	go func() {
+		_, _ = peerHandshake(server, "a long shared secret", false)
+		server.Close()
+	}()
+
+	_ = client.SetDeadline(time.Now().Add(5 * time.Second))
+	nonce := make([]byte, PEER_NONCE_BYTES)
+	_, err := client.Write(nonce)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the server only sends its challenge, and nothing derived from the
+	// secret, until the client has proven itself
+	b := make([]byte, PEER_NONCE_BYTES+1)
+	n, err := client.Read(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != PEER_NONCE_BYTES {
+		t.Fatalf("the server sent %v bytes before the client's proof, want %v", n, PEER_NONCE_BYTES)
+	}
+
+	_, err = client.Write(make([]byte, 32))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	n, err = client.Read(b)
+	if err == nil {
+		t.Errorf("the server answered a wrong proof with %v bytes", n)
+	}
+}
+
+func TestSendToPeer(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Skipf("can't listen on the loopback interface: %v", err)
+	}
+	defer l.Close()
+
+	oldPeer, oldWrite, oldUI := appConf.Peer, peerClipboardWrite, onUIThread
+	defer func() {
+		appConf.Peer, peerClipboardWrite, onUIThread = oldPeer, oldWrite, oldUI
+	}()
+
+	appConf.Peer = PeerConfig{Name: "test", Secret: "a long shared secret"}
+	onUIThread = func(f func()) {}
+	received := make(chan string, 1)
+	peerClipboardWrite = func(v string) error {
+		received <- v
+		return nil
+	}
+
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go handlePeerConn(conn, "a long shared secret")
+		}
+	}()
+
+	name, err := sendToPeer(l.Addr().String(), ClipboardEntry{Value: "hello peer", Time: time.Now()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if name != "test" {
+		t.Errorf("peer name is %q, want test", name)
+	}
+
+	select {
+	case v := <-received:
+		if v != "hello peer" {
+			t.Errorf("peer copied %q", v)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("the peer didn't copy the entry")
+	}
+
+	// a sender with another secret is rejected without copying anything
+	appConf.Peer.Secret = "guessed"
+	_, err = sendToPeer(l.Addr().String(), ClipboardEntry{Value: "intruder"})
+	if err == nil {
+		t.Errorf("sending with the wrong secret succeeded")
+	}
+
+	select {
+	case v := <-received:
+		t.Errorf("peer copied %q from a sender with the wrong secret", v)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
@@ -0,0 +1,206 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/atotto/clipboard"
+	"github.com/pwiecz/go-fltk"
+)
+
+const (
+	// Items are pasted in the order they were selected in.
+	QUEUE_FIFO = "fifo"
+	// Items are pasted in the reverse of the order they were selected in.
+	QUEUE_LIFO = "lifo"
+)
+
+var (
+	queueMu sync.Mutex
+	// The items that have not been pasted yet. The next item to paste is
+	// always the first one.
+	queueItems []string
+	queueMode  string
+	// The process currently holding the next item on the clipboard.
+	queueCmd *exec.Cmd
+)
+
+// Returns the command that places stdin on the clipboard and exits as soon as
+// it has been pasted once, or when another app takes over the clipboard.
+//
+// xclip counts every request for the selection as a loop, including the
+// TARGETS request that many apps send to find out which formats are offered
+// before asking for the text. With those apps the item is gone before it is
+// actually pasted, so the queue can skip items on X11. wl-copy only counts
+// pastes.
+func pasteOnceCommand() (*exec.Cmd, error) {
+	if os.Getenv("WAYLAND_DISPLAY") != "" {
+		if _, err := exec.LookPath("wl-copy"); err == nil {
+			return exec.Command("wl-copy", "--foreground", "--paste-once"), nil
+		}
+	}
+
+	if _, err := exec.LookPath("xclip"); err == nil {
+		return exec.Command("xclip", "-quiet", "-selection", "clipboard", "-loops", "1"), nil
+	}
+
+	return nil, fmt.Errorf("paste queue requires xclip, or wl-copy on wayland")
+}
+
+// Returns true if a paste queue is active. Clipboard capture is suspended
+// while it is, since reading the clipboard would count as a paste.
+func queueActive() bool {
+	queueMu.Lock()
+	defer queueMu.Unlock()
+
+	return len(queueItems) > 0
+}
+
+// Returns a short description of the paste queue for the status bar, or an
+// empty string if no queue is active.
+func queueStatus() string {
+	queueMu.Lock()
+	defer queueMu.Unlock()
+
+	if len(queueItems) == 0 {
+		return ""
+	}
+
+	return fmt.Sprintf("%v queue: %v left", queueMode, len(queueItems))
+}
+
+// Starts a paste queue with values, which must be in the order they were
+// selected in. Each time the clipboard is pasted, the next value is placed on
+// it, until none are left.
+func startQueue(values []string, mode string) error {
+	if len(values) == 0 {
+		return fmt.Errorf("nothing selected to queue")
+	}
+
+	stopQueue()
+
+	items := slices.Clone(values)
+	if mode == QUEUE_LIFO {
+		slices.Reverse(items)
+	}
+
+	queueMu.Lock()
+	queueItems = items
+	queueMode = mode
+	queueMu.Unlock()
+
+	log.Printf("started %v paste queue with %v items", mode, len(items))
+
+	err := serveQueue()
+	if err != nil {
+		stopQueue()
+		return err
+	}
+
+	refreshStatus()
+
+	return nil
+}
+
+// Stops the paste queue, if one is active. It is also called when exiting on a
+// signal, so the status is refreshed on the ui thread.
+func stopQueue() {
+	queueMu.Lock()
+	queueItems = nil
+	cmd := queueCmd
+	queueCmd = nil
+	queueMu.Unlock()
+
+	if cmd != nil && cmd.Process != nil {
+		_ = cmd.Process.Kill()
+	}
+
+	fltk.Awake(refreshStatus)
+}
+
+// Places the next item of the queue on the clipboard, and advances to the
+// following one once it has been pasted. The caller is responsible for
+// refreshing the status.
+func serveQueue() error {
+	queueMu.Lock()
+	if len(queueItems) == 0 {
+		queueMu.Unlock()
+		return nil
+	}
+	item := queueItems[0]
+	queueMu.Unlock()
+
+	cmd, err := pasteOnceCommand()
+	if err != nil {
+		return err
+	}
+
+	cmd.Stdin = strings.NewReader(item)
+	err = cmd.Start()
+	if err != nil {
+		return fmt.Errorf("failed to start %v: %v", cmd.Path, err.Error())
+	}
+
+	queueMu.Lock()
+	queueCmd = cmd
+	queueMu.Unlock()
+
+	go func() {
+		_ = cmd.Wait()
+
+		queueMu.Lock()
+		if queueCmd != cmd {
+			// the queue was stopped or restarted
+			queueMu.Unlock()
+			return
+		}
+		queueCmd = nil
+		queueMu.Unlock()
+
+		// once the item has been pasted nothing owns the clipboard anymore,
+		// so anything else on it means another app copied something
+		latest, err := clipboard.ReadAll()
+		if err == nil && latest != "" && latest != item {
+			log.Println("clipboard was taken over, stopping paste queue")
+			fltk.Awake(func() {
+				setStatus("paste queue stopped")
+				stopQueue()
+			})
+			return
+		}
+
+		queueMu.Lock()
+		if len(queueItems) > 0 {
+			queueItems = queueItems[1:]
+		}
+		remaining := len(queueItems)
+		queueMu.Unlock()
+
+		if remaining == 0 {
+			log.Println("paste queue is empty")
+			fltk.Awake(func() {
+				setStatus("paste queue finished")
+			})
+			return
+		}
+
+		err = serveQueue()
+		if err != nil {
+			log.Printf("failed to continue paste queue: %v", err.Error())
+			fltk.Awake(func() {
+				setStatus("paste queue stopped")
+				stopQueue()
+			})
+			return
+		}
+
+		fltk.Awake(refreshStatus)
+	}()
+
+	return nil
+}
@@ -0,0 +1,228 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// How often the retention rules are evaluated while the app is running.
+	RETENTION_INTERVAL = 15 * time.Second
+
+	// Retention rules with this match apply to every unpinned entry.
+	RETENTION_MATCH_ALL = "all"
+	// Retention rules with this match only apply to unpinned entries that
+	// contain one of the configured secrets.
+	RETENTION_MATCH_SENSITIVE = "sensitive"
+
+	// The number of entries listed in the retention preview.
+	RETENTION_PREVIEW_ENTRIES = 10
+)
+
+// A rule that deletes entries from the history. Pinned entries are never
+// deleted by retention rules. Every limit that is set is applied, and unset
+// limits are ignored.
+type RetentionRule struct {
+	// Which entries the rule applies to: all (default) or sensitive.
+	Match string `json:"match"`
+	// Entries captured longer ago than this are deleted, such as "60s", "12h"
+	// or "7d". Entries saved by versions that didn't record the capture time
+	// have no age, so they are exempt.
+	MaxAge string `json:"maxAge"`
+	// Only this many of the most recent matching entries are kept.
+	MaxCount int `json:"maxCount"`
+	// Only the most recent matching entries that fit within this many bytes
+	// are kept.
+	MaxBytes int `json:"maxBytes"`
+}
+
+// Parses a duration that, in addition to the units supported by
+// time.ParseDuration, may use "d" for days.
+func parseAge(s string) (time.Duration, error) {
+	if days, ok := strings.CutSuffix(s, "d"); ok {
+		d, err := strconv.ParseFloat(days, 64)
+		if err != nil {
+			return 0, fmt.Errorf("invalid number of days %v: %v", s, err.Error())
+		}
+
+		return time.Duration(d * float64(24*time.Hour)), nil
+	}
+
+	return time.ParseDuration(s)
+}
+
+// Returns true if e contains any of the configured secrets.
+func isSensitive(e ClipboardEntry) bool {
+	v := entryValue(e)
+	return obscure(v, appConf.Secrets) != v
+}
+
+// Returns true if the retention rule applies to e.
+func (r RetentionRule) matches(e ClipboardEntry) bool {
+	if e.Pinned {
+		return false
+	}
+
+	switch r.Match {
+	case "", RETENTION_MATCH_ALL:
+		return true
+	case RETENTION_MATCH_SENSITIVE:
+		return isSensitive(e)
+	}
+
+	return false
+}
+
+// Returns the entries among candidates, newest first, that the retention rule
+// deletes at the provided time.
+func (r RetentionRule) apply(candidates []ClipboardEntry, maxAge time.Duration, now time.Time) []ClipboardEntry {
+	result := []ClipboardEntry{}
+	count := 0
+	total := 0
+	// candidates are newest first, so that count and size limits keep the
+	// newest entries
+	for _, e := range candidates {
+		if !r.matches(e) {
+			continue
+		}
+
+		count++
+		total += entrySize(e)
+
+		switch {
+		// entries without a capture time are only subject to the count and
+		// size limits, rather than all being deleted by any age limit
+		case maxAge > 0 && !e.Time.IsZero() && now.Sub(e.Time) > maxAge:
+			result = append(result, e)
+		case r.MaxCount > 0 && count > r.MaxCount:
+			result = append(result, e)
+		case r.MaxBytes > 0 && total > r.MaxBytes:
+			result = append(result, e)
+		}
+	}
+
+	return result
+}
+
+// Returns the entries that the retention rules would delete at the provided
+// time, newest first. When using the sqlite store, the rules apply to the
+// whole store rather than the loaded page.
+func retentionCandidates(now time.Time) []ClipboardEntry {
+	newest := slices.Clone(appConf.Log)
+	slices.Reverse(newest)
+
+	purge := make(map[string]bool)
+	result := []ClipboardEntry{}
+	for _, r := range appConf.Retention {
+		var maxAge time.Duration
+		if r.MaxAge != "" {
+			var err error
+			maxAge, err = parseAge(r.MaxAge)
+			if err != nil {
+				log.Printf("ignoring retention rule with invalid max age: %v", err.Error())
+				continue
+			}
+		}
+
+		var matched []ClipboardEntry
+		if storeEnabled() {
+			var err error
+			matched, err = storeRetentionCandidates(r, maxAge, now)
+			if err != nil {
+				log.Printf("failed to apply retention rule: %v", err.Error())
+				continue
+			}
+		} else {
+			matched = r.apply(newest, maxAge, now)
+		}
+
+		for _, e := range matched {
+			if !purge[e.ID] {
+				purge[e.ID] = true
+				result = append(result, e)
+			}
+		}
+	}
+
+	slices.SortStableFunc(result, func(a, b ClipboardEntry) int {
+		return b.Time.Compare(a.Time)
+	})
+
+	return result
+}
+
+// Deletes all entries that the retention rules currently match. Returns the
+// number of deleted entries.
+func applyRetention() int {
+	toDel := retentionCandidates(time.Now())
+	if len(toDel) == 0 {
+		return 0
+	}
+
+	if storeEnabled() {
+		removeStoredEntries(toDel)
+	} else {
+		indices := []int{}
+		for _, e := range toDel {
+			if i := entryIndex(e.ID); i >= 0 {
+				indices = append(indices, i)
+			}
+		}
+		removeEntries(indices)
+	}
+
+	log.Printf("retention rules deleted %v entries", len(toDel))
+
+	return len(toDel)
+}
+
+// Returns a human-readable summary of what the retention rules would delete
+// if they were applied now, without deleting anything.
+func retentionPreview() string {
+	if len(appConf.Retention) == 0 {
+		return "No retention rules are configured. Add them to the \"retention\" list in the config file."
+	}
+
+	total := len(appConf.Log)
+	if storeEnabled() {
+		total, _ = storeStats("", "")
+	}
+
+	toDel := retentionCandidates(time.Now())
+	if len(toDel) == 0 {
+		return fmt.Sprintf("%v retention rules are configured, and none of the %v entries would be deleted.", len(appConf.Retention), total)
+	}
+
+	bytes := 0
+	for _, e := range toDel {
+		bytes += entrySize(e)
+	}
+
+	sb := new(strings.Builder)
+	sb.WriteString(fmt.Sprintf("%v/%v entries (%v) would be deleted:\n", len(toDel), total, formatBytes(bytes)))
+
+	// show the most recent entries first, like the log browser does
+	for n, e := range toDel {
+		if n >= RETENTION_PREVIEW_ENTRIES {
+			sb.WriteString(fmt.Sprintf("\n...and %v more", len(toDel)-n))
+			break
+		}
+
+		// secrets are obscured first, since they could span line breaks or
+		// be cut off
+		v := obscure(e.Value, appConf.Secrets)
+		v = strings.ReplaceAll(v, "\n", "\\n")
+		v = v[0:minz(len(v), 60)]
+		when := "unknown time"
+		if !e.Time.IsZero() {
+			when = e.Time.Format("2006-01-02 15:04")
+		}
+		sb.WriteString(fmt.Sprintf("\n%v  %v", when, v))
+	}
+
+	return sb.String()
+}
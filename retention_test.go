@@ -0,0 +1,87 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+)
+
+// Returns the ids of entries.
+func entryIDs(entries []ClipboardEntry) []string {
+	ids := []string{}
+	for _, e := range entries {
+		ids = append(ids, e.ID)
+	}
+
+	return ids
+}
+
+func TestRetentionCandidates(t *testing.T) {
+	oldConf := appConf
+	defer func() { appConf = oldConf }()
+
+	now := time.Now()
+	// oldest first, as in the history
+	history := []ClipboardEntry{
+		{ID: "legacy", Value: "saved before capture times"},
+		{ID: "old", Value: "old", Time: now.Add(-48 * time.Hour)},
+		{ID: "pinned", Value: "pinned", Time: now.Add(-48 * time.Hour), Pinned: true},
+		{ID: "recent", Value: "recent", Time: now.Add(-time.Hour)},
+		{ID: "new", Value: "new", Time: now},
+	}
+
+	tests := []struct {
+		rule RetentionRule
+		want []string
+	}{
+		// entries without a capture time are exempt from the age limit
+		{RetentionRule{MaxAge: "1d"}, []string{"old"}},
+		{RetentionRule{MaxAge: "30m"}, []string{"recent", "old"}},
+		// but not from the count and size limits
+		{RetentionRule{MaxCount: 2}, []string{"old", "legacy"}},
+		{RetentionRule{MaxBytes: 9}, []string{"old", "legacy"}},
+		{RetentionRule{Match: RETENTION_MATCH_SENSITIVE, MaxAge: "1s"}, []string{}},
+	}
+
+	for _, test := range tests {
+		appConf = AppConfig{Log: slices.Clone(history), Retention: []RetentionRule{test.rule}}
+
+		got := entryIDs(retentionCandidates(now))
+		if !slices.Equal(got, test.want) {
+			t.Errorf("%+v deletes %v, want %v", test.rule, got, test.want)
+		}
+	}
+}
+
+func TestStoreRetentionCandidates(t *testing.T) {
+	oldConf, oldPath := appConf, configFilePath
+	defer func() {
+		closeStore()
+		historyDB = nil
+		appConf, configFilePath = oldConf, oldPath
+	}()
+
+	configFilePath = filepath.Join(t.TempDir(), "config.json")
+	err := openStore()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Now()
+	appConf = AppConfig{Retention: []RetentionRule{{MaxAge: "1d"}}}
+	for i, age := range []time.Duration{0, 48 * time.Hour, time.Hour} {
+		e := ClipboardEntry{ID: fmt.Sprintf("id%v", i), Value: fmt.Sprintf("entry %v", i)}
+		if age > 0 {
+			e.Time = now.Add(-age)
+		}
+		storePut(e)
+	}
+
+	// the entry without a capture time is kept, as it is without the store
+	got := entryIDs(retentionCandidates(now))
+	if !slices.Equal(got, []string{"id1"}) {
+		t.Errorf("the store deletes %v, want [id1]", got)
+	}
+}
@@ -0,0 +1,450 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"path/filepath"
+	"strings"
+	"time"
+
+	_ "modernc.org/sqlite"
+)
+
+const (
+	// The history is stored in the config file's "log" field.
+	STORE_JSON = "json"
+	// The history is stored in a sqlite database next to the config file, and
+	// only one page of it is kept in memory at a time.
+	STORE_SQLITE = "sqlite"
+
+	DEFAULT_STORE = STORE_JSON
+
+	STORE_FILE_NAME = "history.db"
+)
+
+// The sqlite database holding the history. It is nil unless the sqlite store
+// is configured and was opened successfully.
+var historyDB *sql.DB
+
+// Each migration upgrades the schema by one version. The current version is
+// tracked in sqlite's user_version pragma, so migrations must only ever be
+// appended to this list.
+var storeMigrations = []string{
+	`CREATE TABLE entries (
+		id     TEXT PRIMARY KEY,
+		value  TEXT NOT NULL,
+		hash   TEXT NOT NULL,
+		size   INTEGER NOT NULL DEFAULT 0,
+		blob   TEXT NOT NULL DEFAULT '',
+		time   INTEGER NOT NULL DEFAULT 0,
+		count  INTEGER NOT NULL DEFAULT 1,
+		pinned INTEGER NOT NULL DEFAULT 0
+	);
+	CREATE INDEX entries_time ON entries(time);
+	CREATE INDEX entries_hash ON entries(hash);`,
+
+	`CREATE VIRTUAL TABLE entries_fts USING fts5(value, content='entries', content_rowid='rowid');
+	CREATE TRIGGER entries_ai AFTER INSERT ON entries BEGIN
+		INSERT INTO entries_fts(rowid, value) VALUES (new.rowid, new.value);
+	END;
+	CREATE TRIGGER entries_ad AFTER DELETE ON entries BEGIN
+		INSERT INTO entries_fts(entries_fts, rowid, value) VALUES ('delete', old.rowid, old.value);
+	END;
+	CREATE TRIGGER entries_au AFTER UPDATE OF value ON entries BEGIN
+		INSERT INTO entries_fts(entries_fts, rowid, value) VALUES ('delete', old.rowid, old.value);
+		INSERT INTO entries_fts(rowid, value) VALUES (new.rowid, new.value);
+	END;
+	INSERT INTO entries_fts(entries_fts) VALUES ('rebuild');`,
+
+	`ALTER TABLE entries ADD COLUMN kind TEXT NOT NULL DEFAULT '';`,
+
+	`ALTER TABLE entries ADD COLUMN tags TEXT NOT NULL DEFAULT '';`,
+}
+
+// Returns true if the history is kept in the sqlite store.
+func storeEnabled() bool {
+	return historyDB != nil
+}
+
+// Opens the sqlite store next to the config file and applies any pending
+// schema migrations.
+func openStore() error {
+	fileName := filepath.Join(filepath.Dir(configFilePath), STORE_FILE_NAME)
+
+	db, err := sql.Open("sqlite", fileName)
+	if err != nil {
+		return fmt.Errorf("failed to open %v: %v", fileName, err.Error())
+	}
+
+	// sqlite only supports one writer at a time
+	db.SetMaxOpenConns(1)
+
+	var version int
+	err = db.QueryRow("PRAGMA user_version").Scan(&version)
+	if err != nil {
+		db.Close()
+		return fmt.Errorf("failed to read schema version of %v: %v", fileName, err.Error())
+	}
+
+	for v := version; v < len(storeMigrations); v++ {
+		tx, err := db.Begin()
+		if err != nil {
+			db.Close()
+			return fmt.Errorf("failed to begin migration %v: %v", v+1, err.Error())
+		}
+
+		_, err = tx.Exec(storeMigrations[v])
+		if err == nil {
+			_, err = tx.Exec(fmt.Sprintf("PRAGMA user_version = %d", v+1))
+		}
+
+		if err != nil {
+			_ = tx.Rollback()
+			db.Close()
+			return fmt.Errorf("failed to apply migration %v: %v", v+1, err.Error())
+		}
+
+		err = tx.Commit()
+		if err != nil {
+			db.Close()
+			return fmt.Errorf("failed to commit migration %v: %v", v+1, err.Error())
+		}
+
+		log.Printf("migrated %v to schema version %v", fileName, v+1)
+	}
+
+	historyDB = db
+	log.Printf("using %v for history", fileName)
+
+	return nil
+}
+
+// Closes the sqlite store, if it is open.
+func closeStore() {
+	if historyDB == nil {
+		return
+	}
+
+	err := historyDB.Close()
+	if err != nil {
+		log.Printf("failed to close history store: %v", err.Error())
+	}
+}
+
+// Converts a time to the representation stored in the database. The zero time
+// is stored as 0 so that it sorts before every other entry.
+func storeTime(t time.Time) int64 {
+	if t.IsZero() {
+		return 0
+	}
+
+	return t.UnixNano()
+}
+
+// Inserts or replaces an entry in the store.
+func storePut(e ClipboardEntry) {
+	if historyDB == nil {
+		return
+	}
+
+	_, err := historyDB.Exec(
+		`INSERT INTO entries (id, value, hash, size, blob, time, count, pinned, kind, tags) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT(id) DO UPDATE SET value=excluded.value, hash=excluded.hash, size=excluded.size,
+		blob=excluded.blob, time=excluded.time, count=excluded.count, pinned=excluded.pinned, kind=excluded.kind,
+		tags=excluded.tags`,
+		e.ID, e.Value, e.Hash, e.Size, e.Blob, storeTime(e.Time), useCount(e), e.Pinned, e.Kind, encodeTags(e.Tags),
+	)
+	if err != nil {
+		log.Printf("failed to store entry %v: %v", e.ID, err.Error())
+	}
+}
+
+// Deletes entries from the store by id.
+func storeDelete(ids []string) {
+	if historyDB == nil || len(ids) == 0 {
+		return
+	}
+
+	args := make([]any, len(ids))
+	for i, id := range ids {
+		args[i] = id
+	}
+
+	q := fmt.Sprintf("DELETE FROM entries WHERE id IN (%v)", strings.TrimSuffix(strings.Repeat("?,", len(ids)), ","))
+	_, err := historyDB.Exec(q, args...)
+	if err != nil {
+		log.Printf("failed to delete %v entries from store: %v", len(ids), err.Error())
+	}
+}
+
+// Converts a search query into an fts5 query that matches entries containing
+// every term as a prefix. Each term is quoted so that fts5 syntax in the
+// query is treated literally.
+func ftsQuery(q string) string {
+	terms := strings.Fields(q)
+	for i, t := range terms {
+		terms[i] = fmt.Sprintf(`"%v"*`, strings.ReplaceAll(t, `"`, `""`))
+	}
+
+	return strings.Join(terms, " ")
+}
+
+// Returns the FROM and WHERE clauses that select the entries matching query
+// and having tag, and their arguments. Empty values match every entry.
+func storeFilter(query, tag string) (string, []any) {
+	from := "FROM entries e"
+	where := []string{}
+	args := []any{}
+
+	if strings.TrimSpace(query) != "" {
+		from = "FROM entries_fts f JOIN entries e ON e.rowid = f.rowid"
+		where = append(where, "entries_fts MATCH ?")
+		args = append(args, ftsQuery(query))
+	}
+
+	if tag != "" {
+		where = append(where, "instr(e.tags, ?) > 0")
+		args = append(args, encodeTags([]string{tag}))
+	}
+
+	if len(where) == 0 {
+		return from, args
+	}
+
+	return fmt.Sprintf("%v WHERE %v", from, strings.Join(where, " AND ")), args
+}
+
+// Loads up to limit entries from the store, newest first after skipping
+// offset entries, and returns them oldest first. If query or tag are not
+// empty, only entries matching the query and having the tag are returned. A
+// negative limit loads every entry.
+func storeLoad(query, tag string, offset, limit int) ([]ClipboardEntry, error) {
+	if historyDB == nil {
+		return nil, fmt.Errorf("history store is not open")
+	}
+
+	filter, args := storeFilter(query, tag)
+	result, err := storeQuery(
+		fmt.Sprintf("SELECT %v %v ORDER BY e.time DESC, e.rowid DESC LIMIT ? OFFSET ?", storeColumns("e"), filter),
+		append(args, limit, offset)...,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	// the browser expects the log to be in chronological order
+	for i, j := 0, len(result)-1; i < j; i, j = i+1, j-1 {
+		result[i], result[j] = result[j], result[i]
+	}
+
+	return result, nil
+}
+
+// Returns the number of entries in the store that match query and have tag,
+// and their total size.
+func storeStats(query, tag string) (int, int) {
+	if historyDB == nil {
+		return 0, 0
+	}
+
+	var count int
+	var size sql.NullInt64
+	filter, args := storeFilter(query, tag)
+	err := historyDB.QueryRow(fmt.Sprintf("SELECT COUNT(*), SUM(e.size) %v", filter), args...).Scan(&count, &size)
+	if err != nil {
+		log.Printf("failed to count history: %v", err.Error())
+	}
+
+	return count, int(size.Int64)
+}
+
+// Returns the encoded tags of every tagged entry in the store.
+func storeTags() []string {
+	if historyDB == nil {
+		return nil
+	}
+
+	rows, err := historyDB.Query("SELECT tags FROM entries WHERE tags != ''")
+	if err != nil {
+		log.Printf("failed to query tags: %v", err.Error())
+		return nil
+	}
+	defer rows.Close()
+
+	result := []string{}
+	for rows.Next() {
+		var tags string
+		if rows.Scan(&tags) == nil {
+			result = append(result, tags)
+		}
+	}
+
+	return result
+}
+
+// Finds an entry in the store by the hash of its full value. Returns false if
+// there is none.
+func storeFindHash(hash string) (ClipboardEntry, bool) {
+	return storeQueryOne(fmt.Sprintf("SELECT %v FROM entries WHERE hash = ? ORDER BY time DESC LIMIT 1", storeColumns("")), hash)
+}
+
+// Returns the columns of entries that storeQuery reads, in order, qualified
+// with the provided table alias if it isn't empty.
+func storeColumns(alias string) string {
+	cols := []string{"id", "value", "hash", "size", "blob", "time", "count", "pinned", "kind", "tags"}
+	if alias != "" {
+		for i, c := range cols {
+			cols[i] = alias + "." + c
+		}
+	}
+
+	return strings.Join(cols, ", ")
+}
+
+// Runs a query that selects the columns returned by storeColumns, and returns
+// the entries.
+func storeQuery(q string, args ...any) ([]ClipboardEntry, error) {
+	if historyDB == nil {
+		return nil, fmt.Errorf("history store is not open")
+	}
+
+	rows, err := historyDB.Query(q, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query history: %v", err.Error())
+	}
+	defer rows.Close()
+
+	result := []ClipboardEntry{}
+	for rows.Next() {
+		var e ClipboardEntry
+		var t int64
+		var tags string
+		err = rows.Scan(&e.ID, &e.Value, &e.Hash, &e.Size, &e.Blob, &t, &e.Count, &e.Pinned, &e.Kind, &tags)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read history: %v", err.Error())
+		}
+
+		e.Tags = decodeTags(tags)
+
+		if t != 0 {
+			e.Time = time.Unix(0, t)
+		}
+
+		result = append(result, e)
+	}
+
+	if rows.Err() != nil {
+		return nil, fmt.Errorf("failed to read history: %v", rows.Err().Error())
+	}
+
+	return result, nil
+}
+
+// Runs a query like storeQuery and returns its first entry. Returns false if
+// there is none, or the query failed.
+func storeQueryOne(q string, args ...any) (ClipboardEntry, bool) {
+	if historyDB == nil {
+		return ClipboardEntry{}, false
+	}
+
+	entries, err := storeQuery(q, args...)
+	if err != nil {
+		log.Printf("failed to find entry: %v", err.Error())
+		return ClipboardEntry{}, false
+	}
+
+	if len(entries) == 0 {
+		return ClipboardEntry{}, false
+	}
+
+	return entries[0], true
+}
+
+// Returns the entries in the store that a retention rule deletes at now,
+// newest first. Rules for every entry are evaluated by sqlite. Rules for
+// sensitive entries have to check each value for secrets, so the unpinned
+// entries are read and the rule is applied to them.
+func storeRetentionCandidates(r RetentionRule, maxAge time.Duration, now time.Time) ([]ClipboardEntry, error) {
+	cols := storeColumns("")
+	if r.Match == RETENTION_MATCH_SENSITIVE {
+		entries, err := storeQuery(fmt.Sprintf("SELECT %v FROM entries WHERE pinned = 0 ORDER BY time DESC, rowid DESC", cols))
+		if err != nil {
+			return nil, err
+		}
+
+		return r.apply(entries, maxAge, now), nil
+	}
+
+	if r.Match != "" && r.Match != RETENTION_MATCH_ALL {
+		return nil, nil
+	}
+
+	// n and total count the unpinned entries from the newest, so that count
+	// and size limits keep the newest entries
+	return storeQuery(
+		fmt.Sprintf(`SELECT %v FROM (
+			SELECT *, ROW_NUMBER() OVER w AS n,
+				SUM(CASE WHEN size > 0 THEN size ELSE length(CAST(value AS BLOB)) END) OVER w AS total
+			FROM entries WHERE pinned = 0
+			WINDOW w AS (ORDER BY time DESC, rowid DESC)
+		) WHERE (? > 0 AND time != 0 AND time < ?) OR (? > 0 AND n > ?) OR (? > 0 AND total > ?)
+		ORDER BY time DESC`, cols),
+		maxAge, storeTime(now.Add(-maxAge)), r.MaxCount, r.MaxCount, r.MaxBytes, r.MaxBytes,
+	)
+}
+
+// Returns true if an entry in the store uses the provided blob.
+func storeBlobReferenced(blob string) bool {
+	if historyDB == nil {
+		return false
+	}
+
+	var n int
+	err := historyDB.QueryRow("SELECT COUNT(*) FROM entries WHERE blob = ?", blob).Scan(&n)
+	if err != nil {
+		log.Printf("failed to check blob references: %v", err.Error())
+		// keeping an unused blob is better than losing a used one
+		return true
+	}
+
+	return n > 0
+}
+
+// Finds an entry in the store by its id. Returns false if there is none.
+func storeFindID(id string) (ClipboardEntry, bool) {
+	return storeQueryOne(fmt.Sprintf("SELECT %v FROM entries WHERE id = ? LIMIT 1", storeColumns("")), id)
+}
+
+// Moves entries from the config file's log into the store. The log is then
+// no longer written to the config file.
+func migrateLogToStore() error {
+	if historyDB == nil || len(appConf.Log) == 0 {
+		return nil
+	}
+
+	tx, err := historyDB.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin migration: %v", err.Error())
+	}
+
+	for _, e := range appConf.Log {
+		_, err = tx.Exec(
+			`INSERT OR IGNORE INTO entries (id, value, hash, size, blob, time, count, pinned, kind, tags) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+			e.ID, e.Value, e.Hash, e.Size, e.Blob, storeTime(e.Time), useCount(e), e.Pinned, e.Kind, encodeTags(e.Tags),
+		)
+		if err != nil {
+			_ = tx.Rollback()
+			return fmt.Errorf("failed to migrate entry %v: %v", e.ID, err.Error())
+		}
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return fmt.Errorf("failed to commit migration: %v", err.Error())
+	}
+
+	log.Printf("migrated %v entries from the config file to the history store", len(appConf.Log))
+
+	return nil
+}
@@ -0,0 +1,651 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pwiecz/go-fltk"
+)
+
+const (
+	// How often the change logs in the sync directory are read.
+	SYNC_INTERVAL = 5 * time.Second
+
+	// Kinds of records in a change log.
+	SYNC_OP_PUT    = "put"
+	SYNC_OP_PIN    = "pin"
+	SYNC_OP_DELETE = "delete"
+
+	// Change logs in the sync directory end with this extension.
+	SYNC_LOG_EXT = ".jsonl"
+
+	// Records what has been merged so far, next to the config file.
+	SYNC_STATE_FILE_NAME = "sync-state.json"
+)
+
+// The content of an entry in a change log. Pinning is recorded separately, so
+// that pinning an entry on one machine and using it on another don't undo
+// each other.
+type syncEntry struct {
+	Value string    `json:"value"`
+	Time  time.Time `json:"time,omitempty"`
+	Count int       `json:"count,omitempty"`
+	Kind  string    `json:"kind,omitempty"`
+	Tags  []string  `json:"tags,omitempty"`
+}
+
+// Returns true if both entries have the same content.
+func (s syncEntry) equal(o syncEntry) bool {
+	return s.Value == o.Value && s.Time.Equal(o.Time) && s.Count == o.Count && s.Kind == o.Kind && slices.Equal(s.Tags, o.Tags)
+}
+
+// One line of a change log.
+type syncRecord struct {
+	Op string `json:"op"`
+	ID string `json:"id"`
+	// When the change was made, and by which instance. Of two changes of
+	// the same kind to an entry, the later one wins, and the instance breaks
+	// ties.
+	Time     time.Time  `json:"time"`
+	Instance string     `json:"instance"`
+	Entry    *syncEntry `json:"entry,omitempty"`
+	Pinned   bool       `json:"pinned,omitempty"`
+}
+
+// When a change was made, and by which instance.
+type syncStamp struct {
+	Time     time.Time `json:"time,omitempty"`
+	Instance string    `json:"instance,omitempty"`
+}
+
+// Returns true if s was made after o.
+func (s syncStamp) after(o syncStamp) bool {
+	if !s.Time.Equal(o.Time) {
+		return s.Time.After(o.Time)
+	}
+
+	return s.Instance > o.Instance
+}
+
+// The latest change of each kind to an entry, from every instance. Since each
+// kind only keeps the latest change, merging records in any order gives the
+// same result. The content of entries that are no longer live is dropped, so
+// only the stamps of deleted entries are kept.
+type syncClock struct {
+	Put    syncStamp  `json:"put"`
+	Entry  *syncEntry `json:"entry,omitempty"`
+	Pin    syncStamp  `json:"pin"`
+	Pinned bool       `json:"pinned,omitempty"`
+	Delete syncStamp  `json:"delete"`
+}
+
+// Returns true if the entry should be in the history: it was added after it
+// was last deleted, or pinned after it was deleted.
+func (c syncClock) live() bool {
+	if c.Entry == nil {
+		return false
+	}
+
+	return c.Put.after(c.Delete) || (c.Pinned && c.Pin.after(c.Delete))
+}
+
+// Forgets the content of the entry if it is no longer live, so that deleted
+// values aren't kept around. The stamps are kept, so that older records of the
+// entry are still ignored.
+func (c *syncClock) prune() {
+	if !c.live() {
+		c.Entry = nil
+	}
+}
+
+// Saved in the sync state file.
+type syncState struct {
+	// The name of this instance's change log.
+	Instance string `json:"instance"`
+	// How many times this instance's change log was compacted. Each compacted
+	// log is written under a new name.
+	Generation int `json:"generation,omitempty"`
+	// How many bytes of each change log have been merged, by file name.
+	Offsets map[string]int64 `json:"offsets"`
+	// The latest changes to every entry that was synced.
+	Clocks map[string]syncClock `json:"clocks"`
+}
+
+var (
+	syncMu sync.Mutex
+	// Nil unless sync is enabled.
+	syncStatus *syncState
+	// Whether this instance's change log may hold the contents of entries
+	// that were deleted or changed since it was last compacted.
+	syncCompactPending bool
+)
+
+// Returns the path of the sync state file.
+func syncStatePath() string {
+	return filepath.Join(filepath.Dir(configFilePath), SYNC_STATE_FILE_NAME)
+}
+
+// Returns the path of this instance's change log.
+func syncLogPath() string {
+	if syncStatus.Generation == 0 {
+		return filepath.Join(appConf.SyncDir, syncStatus.Instance+SYNC_LOG_EXT)
+	}
+
+	return filepath.Join(appConf.SyncDir, fmt.Sprintf("%v.%v%v", syncStatus.Instance, syncStatus.Generation, SYNC_LOG_EXT))
+}
+
+// Starts syncing with the change logs in appConf.SyncDir, and adds the
+// entries that were never synced to this instance's change log.
+func startSync() error {
+	err := os.MkdirAll(appConf.SyncDir, 0o700)
+	if err != nil {
+		return fmt.Errorf("failed to create sync directory %v: %v", appConf.SyncDir, err.Error())
+	}
+
+	state := &syncState{}
+	b, err := os.ReadFile(syncStatePath())
+	if err == nil {
+		err = json.Unmarshal(b, state)
+		if err != nil {
+			log.Printf("failed to parse %v, all change logs will be merged again: %v", syncStatePath(), err.Error())
+		}
+	}
+	if state.Instance == "" {
+		state.Instance = newEntryID()
+	}
+	if state.Offsets == nil {
+		state.Offsets = make(map[string]int64)
+	}
+	if state.Clocks == nil {
+		state.Clocks = make(map[string]syncClock)
+	}
+	// earlier versions kept the content of deleted entries
+	for id, c := range state.Clocks {
+		c.prune()
+		state.Clocks[id] = c
+	}
+
+	syncMu.Lock()
+	syncStatus = state
+	syncMu.Unlock()
+
+	entries := appConf.Log
+	if storeEnabled() {
+		entries, err = storeLoad("", "", 0, -1)
+		if err != nil {
+			return fmt.Errorf("failed to load history to sync: %v", err.Error())
+		}
+	}
+
+	for _, e := range entries {
+		syncLocal(e.ID, &e)
+	}
+
+	syncMu.Lock()
+	err = compactSyncLog()
+	syncMu.Unlock()
+	if err != nil {
+		log.Printf("failed to compact change log: %v", err.Error())
+	}
+
+	log.Printf("syncing history through %v as %v", appConf.SyncDir, state.Instance)
+
+	return saveSyncState()
+}
+
+// Stops syncing.
+func stopSync() {
+	syncMu.Lock()
+	defer syncMu.Unlock()
+
+	syncStatus = nil
+}
+
+// Saves the sync state file.
+func saveSyncState() error {
+	syncMu.Lock()
+	defer syncMu.Unlock()
+
+	if syncStatus == nil {
+		return nil
+	}
+
+	b, err := json.Marshal(syncStatus)
+	if err != nil {
+		return fmt.Errorf("failed to encode sync state: %v", err.Error())
+	}
+
+	// written to a temporary file first so that a crash can't leave a
+	// partially written state behind
+	tmp := syncStatePath() + ".tmp"
+	err = os.WriteFile(tmp, b, 0o600)
+	if err == nil {
+		err = os.Rename(tmp, syncStatePath())
+	}
+	if err != nil {
+		return fmt.Errorf("failed to write %v: %v", syncStatePath(), err.Error())
+	}
+
+	return nil
+}
+
+// Converts an entry to the content recorded in change logs.
+func toSyncEntry(e ClipboardEntry) syncEntry {
+	return syncEntry{
+		Value: entryValue(e),
+		Time:  e.Time,
+		Count: e.Count,
+		Kind:  e.Kind,
+		Tags:  e.Tags,
+	}
+}
+
+// Records a change to the entry with the provided id in this instance's
+// change log. A nil entry means that it was deleted. Only the parts of the
+// entry that changed since it was last synced are recorded. Must not be
+// called with syncMu held.
+func syncLocal(id string, e *ClipboardEntry) {
+	syncMu.Lock()
+	defer syncMu.Unlock()
+
+	if syncStatus == nil || id == "" {
+		return
+	}
+
+	stamp := syncStamp{Time: time.Now(), Instance: syncStatus.Instance}
+	clock := syncStatus.Clocks[id]
+	records := []syncRecord{}
+
+	if e == nil {
+		if !clock.live() {
+			return
+		}
+		clock.Delete = stamp
+		clock.prune()
+		records = append(records, syncRecord{Op: SYNC_OP_DELETE})
+		syncCompactPending = true
+	} else {
+		content := toSyncEntry(*e)
+		if clock.Entry == nil || !clock.Entry.equal(content) || !clock.live() {
+			if clock.Entry != nil && clock.Entry.Value != content.Value {
+				syncCompactPending = true
+			}
+			clock.Put = stamp
+			clock.Entry = &content
+			records = append(records, syncRecord{Op: SYNC_OP_PUT, Entry: &content})
+		}
+
+		if e.Pinned != clock.Pinned {
+			clock.Pin = stamp
+			clock.Pinned = e.Pinned
+			r := syncRecord{Op: SYNC_OP_PIN, Pinned: e.Pinned}
+			if e.Pinned {
+				// pinning brings back an entry that was deleted
+				// elsewhere, whose content may already be forgotten there
+				r.Entry = &content
+			}
+			records = append(records, r)
+		}
+	}
+
+	if len(records) == 0 {
+		return
+	}
+
+	syncStatus.Clocks[id] = clock
+
+	buf := new(bytes.Buffer)
+	enc := json.NewEncoder(buf)
+	for _, r := range records {
+		r.ID = id
+		r.Time = stamp.Time
+		r.Instance = stamp.Instance
+		_ = enc.Encode(r)
+	}
+
+	f, err := os.OpenFile(syncLogPath(), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+	if err != nil {
+		log.Printf("failed to open change log: %v", err.Error())
+		return
+	}
+	defer f.Close()
+
+	_, err = f.Write(buf.Bytes())
+	if err != nil {
+		log.Printf("failed to write change log: %v", err.Error())
+	}
+}
+
+// Merges a record into the clocks. Returns true if the record was newer than
+// what was already known.
+func mergeSyncRecord(clocks map[string]syncClock, r syncRecord) bool {
+	clock := clocks[r.ID]
+	stamp := syncStamp{Time: r.Time, Instance: r.Instance}
+
+	switch r.Op {
+	case SYNC_OP_PUT:
+		if r.Entry == nil || !stamp.after(clock.Put) {
+			return false
+		}
+		clock.Put = stamp
+		clock.Entry = r.Entry
+	case SYNC_OP_PIN:
+		if !stamp.after(clock.Pin) {
+			return false
+		}
+		clock.Pin = stamp
+		clock.Pinned = r.Pinned
+		if r.Pinned && clock.Entry == nil {
+			clock.Entry = r.Entry
+		}
+	case SYNC_OP_DELETE:
+		if !stamp.after(clock.Delete) {
+			return false
+		}
+		clock.Delete = stamp
+	default:
+		return false
+	}
+
+	clock.prune()
+	clocks[r.ID] = clock
+
+	return true
+}
+
+// Reads the complete records that were added to a change log since offset.
+// Returns them and the offset after the last complete record. A log that is
+// shorter than offset was replaced, and is read from the start.
+func readSyncLog(fileName string, offset int64) ([]syncRecord, int64, error) {
+	f, err := os.Open(fileName)
+	if err != nil {
+		return nil, offset, fmt.Errorf("failed to open %v: %v", fileName, err.Error())
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return nil, offset, fmt.Errorf("failed to read %v: %v", fileName, err.Error())
+	}
+	if info.Size() < offset {
+		offset = 0
+	}
+
+	_, err = f.Seek(offset, io.SeekStart)
+	if err != nil {
+		return nil, offset, fmt.Errorf("failed to read %v: %v", fileName, err.Error())
+	}
+
+	records := []syncRecord{}
+	r := bufio.NewReader(f)
+	for {
+		line, err := r.ReadBytes('\n')
+		if err != nil {
+			// a partially written or partially synced record is read once
+			// it is complete
+			break
+		}
+		offset += int64(len(line))
+
+		var rec syncRecord
+		err = json.Unmarshal(line, &rec)
+		if err != nil {
+			log.Printf("skipping invalid record in %v: %v", fileName, err.Error())
+			continue
+		}
+		records = append(records, rec)
+	}
+
+	return records, offset, nil
+}
+
+// Rewrites this instance's change log with only the latest change of each kind
+// that this instance made to each entry, leaving out the contents of entries
+// that were deleted or changed since. The compacted log is written under a new
+// name and the old one is removed, so that other instances read it from the
+// start rather than from their offset into the old one. Must be called with
+// syncMu held.
+func compactSyncLog() error {
+	if syncStatus == nil {
+		return nil
+	}
+
+	self := syncStatus.Instance
+	records := []syncRecord{}
+	for id, c := range syncStatus.Clocks {
+		if c.Put.Instance == self && c.live() {
+			records = append(records, syncRecord{Op: SYNC_OP_PUT, ID: id, Time: c.Put.Time, Entry: c.Entry})
+		}
+
+		if c.Pin.Instance == self {
+			r := syncRecord{Op: SYNC_OP_PIN, ID: id, Time: c.Pin.Time, Pinned: c.Pinned}
+			if c.Pinned && c.live() {
+				r.Entry = c.Entry
+			}
+			records = append(records, r)
+		}
+
+		if c.Delete.Instance == self {
+			records = append(records, syncRecord{Op: SYNC_OP_DELETE, ID: id, Time: c.Delete.Time})
+		}
+	}
+
+	slices.SortStableFunc(records, func(a, b syncRecord) int {
+		if c := a.Time.Compare(b.Time); c != 0 {
+			return c
+		}
+
+		return strings.Compare(a.ID, b.ID)
+	})
+
+	buf := new(bytes.Buffer)
+	enc := json.NewEncoder(buf)
+	for _, r := range records {
+		r.Instance = self
+		_ = enc.Encode(r)
+	}
+
+	old := syncLogPath()
+	syncStatus.Generation++
+	fileName := syncLogPath()
+
+	// written to a temporary file that isn't matched by SYNC_LOG_EXT first,
+	// so that other instances never read a partially written log
+	tmp := fileName + ".tmp"
+	err := os.WriteFile(tmp, buf.Bytes(), 0o600)
+	if err == nil {
+		err = os.Rename(tmp, fileName)
+	}
+	if err != nil {
+		syncStatus.Generation--
+		_ = os.Remove(tmp)
+		return fmt.Errorf("failed to write %v: %v", fileName, err.Error())
+	}
+
+	err = os.Remove(old)
+	if err != nil && !os.IsNotExist(err) {
+		log.Printf("failed to remove old change log %v: %v", old, err.Error())
+	}
+
+	delete(syncStatus.Offsets, filepath.Base(old))
+	syncStatus.Offsets[filepath.Base(fileName)] = int64(buf.Len())
+	syncCompactPending = false
+
+	log.Printf("compacted change log to %v records", len(records))
+
+	return nil
+}
+
+// Makes the history match the clock of the entry with the provided id. The
+// change isn't recorded in this instance's change log, since setEntryState
+// doesn't record changes.
+func applySyncClock(id string, clock syncClock) {
+	i := entryIndex(id)
+	if !clock.live() {
+		old, ok := ClipboardEntry{}, false
+		if i >= 0 {
+			old, ok = appConf.Log[i], true
+		} else if storeEnabled() {
+			old, ok = storeFindID(id)
+		}
+
+		if ok {
+			setEntryState(id, nil)
+			removeBlob(old, appConf.Log)
+		}
+		return
+	}
+
+	e, ok := newEntry(clock.Entry.Value)
+	if !ok {
+		log.Printf("skipping synced entry %v, which exceeds the size limits", id)
+		return
+	}
+
+	e.ID = id
+	e.Time = clock.Entry.Time
+	e.Count = clock.Entry.Count
+	e.Kind = clock.Entry.Kind
+	e.Tags = clock.Entry.Tags
+	e.Pinned = clock.Pinned
+	if i >= 0 {
+		old := appConf.Log[i]
+		e.Selected = old.Selected
+		setEntryState(id, &e)
+		removeBlob(old, appConf.Log)
+		return
+	}
+
+	setEntryState(id, &e)
+}
+
+// Merges the records that were added to the change logs since the last merge.
+// This instance's own log is merged too, in case it was written to after the
+// sync state was last saved. Returns the number of entries that changed.
+func pullSync() int {
+	syncMu.Lock()
+	if syncStatus == nil {
+		syncMu.Unlock()
+		return 0
+	}
+
+	files, err := filepath.Glob(filepath.Join(appConf.SyncDir, "*"+SYNC_LOG_EXT))
+	if err != nil {
+		syncMu.Unlock()
+		log.Printf("failed to list change logs: %v", err.Error())
+		return 0
+	}
+
+	// logs that were compacted or removed by their instance are gone
+	names := make(map[string]bool)
+	for _, fileName := range files {
+		names[filepath.Base(fileName)] = true
+	}
+	for name := range syncStatus.Offsets {
+		if !names[name] {
+			delete(syncStatus.Offsets, name)
+		}
+	}
+
+	changed := map[string]syncClock{}
+	for _, fileName := range files {
+		name := filepath.Base(fileName)
+		records, offset, err := readSyncLog(fileName, syncStatus.Offsets[name])
+		if err != nil {
+			log.Printf("failed to merge change log: %v", err.Error())
+			continue
+		}
+		syncStatus.Offsets[name] = offset
+
+		for _, r := range records {
+			if mergeSyncRecord(syncStatus.Clocks, r) {
+				changed[r.ID] = syncStatus.Clocks[r.ID]
+				// this instance's log may hold what was deleted
+				if r.Op == SYNC_OP_DELETE {
+					syncCompactPending = true
+				}
+			}
+		}
+	}
+
+	compacted := false
+	if syncCompactPending {
+		err = compactSyncLog()
+		if err != nil {
+			log.Printf("failed to compact change log: %v", err.Error())
+		}
+		compacted = err == nil
+	}
+
+	syncMu.Unlock()
+
+	for id, clock := range changed {
+		applySyncClock(id, clock)
+	}
+
+	if compacted && len(changed) == 0 {
+		err = saveSyncState()
+		if err != nil {
+			log.Printf("failed to save sync state: %v", err.Error())
+		}
+	}
+
+	if len(changed) > 0 {
+		// the order of the history is by time, as in applyChanges
+		sortHistory()
+		loadHistory()
+
+		err = saveSyncState()
+		if err != nil {
+			log.Printf("failed to save sync state: %v", err.Error())
+		}
+	}
+
+	return len(changed)
+}
+
+// Asks for the sync directory, or whether to stop syncing if sync is already
+// enabled, and starts or stops syncing.
+func syncDialog() {
+	if appConf.SyncDir != "" {
+		switch fltk.ChoiceDialog(fmt.Sprintf("History is synced through %v.", appConf.SyncDir), "Keep Syncing", "Stop Syncing") {
+		case 1:
+			stopSync()
+			appConf.SyncDir = ""
+			setStatus("stopped syncing")
+		}
+		return
+	}
+
+	fc := fltk.NewNativeFileChooser()
+	defer fc.Destroy()
+
+	fc.SetTitle("Choose a folder to sync history through")
+	fc.SetType(fltk.NativeFileChooser_BROWSE_DIRECTORY)
+	if fc.Show() != 0 || len(fc.Filenames()) == 0 {
+		return
+	}
+
+	appConf.SyncDir = fc.Filenames()[0]
+	err := startSync()
+	if err != nil {
+		stopSync()
+		appConf.SyncDir = ""
+		fltk.MessageBox("Error", fmt.Sprintf("Failed to start syncing: %v", err.Error()))
+		return
+	}
+
+	n := pullSync()
+	reconstruct()
+	setStatus(fmt.Sprintf("syncing through %v, merged %v entries", appConf.SyncDir, n))
+}
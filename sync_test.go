@@ -0,0 +1,239 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+// The global state of one instance, so that several instances can take turns
+// in the same process.
+type testInstance struct {
+	configPath string
+	log        []ClipboardEntry
+	state      *syncState
+	pending    bool
+}
+
+// Makes inst the current instance, saving the state of the current one in
+// from.
+func switchInstance(from, inst *testInstance) {
+	if from != nil {
+		from.log = appConf.Log
+		from.state = syncStatus
+		from.pending = syncCompactPending
+	}
+
+	configFilePath = inst.configPath
+	appConf.Log = inst.log
+	syncStatus = inst.state
+	syncCompactPending = inst.pending
+	invalidateDedupeIndex()
+}
+
+// Returns the values in the current instance's history.
+func historyValues() []string {
+	values := []string{}
+	for _, e := range appConf.Log {
+		values = append(values, e.Value)
+	}
+	slices.Sort(values)
+
+	return values
+}
+
+// Fails if value appears in any file below dir.
+func assertNotStored(t *testing.T, dir, value string) {
+	t.Helper()
+
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return err
+		}
+
+		b, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+
+		if strings.Contains(string(b), value) {
+			t.Errorf("%v still contains %q", path, value)
+		}
+
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSyncTwoInstances(t *testing.T) {
+	dir := t.TempDir()
+
+	oldConf, oldPath := appConf, configFilePath
+	defer func() {
+		appConf, configFilePath = oldConf, oldPath
+		syncStatus = nil
+		syncCompactPending = false
+	}()
+
+	appConf = AppConfig{SyncDir: filepath.Join(dir, "sync"), DedupeMode: DEDUPE_CONSECUTIVE}
+
+	a := &testInstance{configPath: filepath.Join(dir, "a", "config.json")}
+	b := &testInstance{configPath: filepath.Join(dir, "b", "config.json")}
+	for _, inst := range []*testInstance{a, b} {
+		err := os.MkdirAll(filepath.Dir(inst.configPath), 0o700)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	now := time.Now()
+	add := func(value string, age time.Duration) {
+		e, _ := newEntry(value)
+		e.ID = newEntryID()
+		e.Time = now.Add(-age)
+		e.Count = 1
+		appendEntry(e)
+	}
+
+	switchInstance(nil, a)
+	err := startSync()
+	if err != nil {
+		t.Fatal(err)
+	}
+	add("kept", 3*time.Minute)
+	add("hunter2-secret", 2*time.Minute)
+	add("pinned later", time.Minute)
+
+	switchInstance(a, b)
+	err = startSync()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := pullSync(); n != 3 {
+		t.Fatalf("b merged %v entries, want 3", n)
+	}
+	if got := historyValues(); !slices.Equal(got, []string{"hunter2-secret", "kept", "pinned later"}) {
+		t.Fatalf("b has %v", got)
+	}
+
+	// evicting an entry, as retention rules and limits do, is synced
+	removeEntries([]int{slices.IndexFunc(appConf.Log, func(e ClipboardEntry) bool { return e.Value == "hunter2-secret" })})
+	pullSync()
+
+	switchInstance(b, a)
+	pullSync()
+	if got := historyValues(); !slices.Equal(got, []string{"kept", "pinned later"}) {
+		t.Fatalf("a has %v after the eviction", got)
+	}
+
+	// pins and edits are synced too
+	i := slices.IndexFunc(appConf.Log, func(e ClipboardEntry) bool { return e.Value == "pinned later" })
+	appConf.Log[i].Pinned = true
+	updateEntry(i)
+	err = saveSyncState()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	switchInstance(a, b)
+	pullSync()
+	err = saveSyncState()
+	if err != nil {
+		t.Fatal(err)
+	}
+	i = slices.IndexFunc(appConf.Log, func(e ClipboardEntry) bool { return e.Value == "pinned later" })
+	if i < 0 || !appConf.Log[i].Pinned {
+		t.Errorf("the pin wasn't synced to b")
+	}
+
+	// neither the change logs nor the sync state keep the evicted value
+	assertNotStored(t, dir, "hunter2-secret")
+
+	// a new instance only gets the remaining entries from the compacted logs
+	c := &testInstance{configPath: filepath.Join(dir, "c", "config.json")}
+	err = os.MkdirAll(filepath.Dir(c.configPath), 0o700)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	switchInstance(b, c)
+	err = startSync()
+	if err != nil {
+		t.Fatal(err)
+	}
+	pullSync()
+	if got := historyValues(); !slices.Equal(got, []string{"kept", "pinned later"}) {
+		t.Errorf("c has %v", got)
+	}
+}
+
+func TestSyncBlobDelete(t *testing.T) {
+	dir := t.TempDir()
+
+	oldConf, oldPath := appConf, configFilePath
+	defer func() {
+		appConf, configFilePath = oldConf, oldPath
+		syncStatus = nil
+		syncCompactPending = false
+	}()
+
+	appConf = AppConfig{
+		SyncDir:        filepath.Join(dir, "sync"),
+		DedupeMode:     DEDUPE_CONSECUTIVE,
+		MaxEntryBytes:  16,
+		MaxEntryPolicy: POLICY_BLOB,
+	}
+
+	a := &testInstance{configPath: filepath.Join(dir, "a", "config.json")}
+	b := &testInstance{configPath: filepath.Join(dir, "b", "config.json")}
+	for _, inst := range []*testInstance{a, b} {
+		err := os.MkdirAll(filepath.Dir(inst.configPath), 0o700)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	value := strings.Repeat("a large value ", 10)
+	blob := filepath.Join(dir, "b", "blobs", hashValue(value))
+
+	switchInstance(nil, a)
+	err := startSync()
+	if err != nil {
+		t.Fatal(err)
+	}
+	e, ok := newEntry(value)
+	if !ok || e.Blob == "" {
+		t.Fatalf("the value wasn't stored as a blob")
+	}
+	e.ID = newEntryID()
+	e.Time = time.Now()
+	e.Count = 1
+	appendEntry(e)
+
+	switchInstance(a, b)
+	err = startSync()
+	if err != nil {
+		t.Fatal(err)
+	}
+	pullSync()
+	if _, err := os.Stat(blob); err != nil {
+		t.Fatalf("b didn't write the synced blob: %v", err)
+	}
+
+	switchInstance(b, a)
+	removeEntries([]int{0})
+
+	switchInstance(a, b)
+	pullSync()
+	if len(appConf.Log) != 0 {
+		t.Fatalf("b still has %v entries", len(appConf.Log))
+	}
+	if _, err := os.Stat(blob); !os.IsNotExist(err) {
+		t.Errorf("the blob of the deleted entry was kept: %v", err)
+	}
+}
@@ -0,0 +1,321 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+	"unicode"
+
+	"github.com/pwiecz/go-fltk"
+)
+
+// The label of the tag filter button when every entry is shown.
+const TAG_FILTER_ALL = "All Tags"
+
+// Adds a tag to every captured value that matches a regular expression.
+type TagRule struct {
+	// A regular expression matched against captured values.
+	Match string `json:"match"`
+	// The tag that matching values receive.
+	Tag string `json:"tag"`
+}
+
+var (
+	// The tag that entries must have to be shown, or an empty string to show
+	// every entry.
+	tagFilter string
+
+	// Compiled tag rule patterns, keyed by pattern. Invalid patterns are
+	// stored as nil so that their error is only logged once.
+	tagPatternsMu sync.Mutex
+	tagPatterns   = make(map[string]*regexp.Regexp)
+)
+
+// Converts a tag to the form it is stored in: lowercase, with whitespace
+// replaced by dashes and only letters, digits and -_./ kept. Returns an
+// empty string if nothing is left.
+func normalizeTag(tag string) string {
+	tag = strings.Join(strings.Fields(strings.ToLower(tag)), "-")
+
+	return strings.Map(func(r rune) rune {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) || strings.ContainsRune("-_./", r) {
+			return r
+		}
+		return -1
+	}, tag)
+}
+
+// Returns tags with add included, normalized, without duplicates and sorted.
+func addTags(tags []string, add ...string) []string {
+	result := []string{}
+	for _, t := range append(slices.Clone(tags), add...) {
+		if t = normalizeTag(t); t != "" {
+			result = append(result, t)
+		}
+	}
+	slices.Sort(result)
+	result = slices.Compact(result)
+
+	if len(result) == 0 {
+		return nil
+	}
+
+	return result
+}
+
+// Returns tags without tag.
+func removeTag(tags []string, tag string) []string {
+	result := slices.DeleteFunc(slices.Clone(tags), func(t string) bool {
+		return t == tag
+	})
+
+	if len(result) == 0 {
+		return nil
+	}
+
+	return result
+}
+
+// Returns true if e has the provided tag.
+func hasTag(e ClipboardEntry, tag string) bool {
+	return slices.Contains(e.Tags, tag)
+}
+
+// Returns true if the rule applies to value. Invalid patterns never match.
+func (r TagRule) matches(value string) bool {
+	tagPatternsMu.Lock()
+	defer tagPatternsMu.Unlock()
+
+	re, ok := tagPatterns[r.Match]
+	if !ok {
+		var err error
+		re, err = regexp.Compile(r.Match)
+		if err != nil {
+			log.Printf("invalid match pattern %q for tag %v: %v", r.Match, r.Tag, err.Error())
+		}
+		tagPatterns[r.Match] = re
+	}
+
+	return re != nil && re.MatchString(value)
+}
+
+// Returns the tags that the tag rules give to value.
+func autoTags(value string) []string {
+	tags := []string{}
+	for _, r := range appConf.TagRules {
+		if r.Match != "" && r.matches(value) {
+			tags = append(tags, r.Tag)
+		}
+	}
+
+	return addTags(nil, tags...)
+}
+
+// Encodes tags for the database, with a comma before and after each tag so
+// that entries with a tag can be found by searching for ",tag,".
+func encodeTags(tags []string) string {
+	if len(tags) == 0 {
+		return ""
+	}
+
+	return "," + strings.Join(tags, ",") + ","
+}
+
+// Decodes tags encoded by encodeTags.
+func decodeTags(s string) []string {
+	return addTags(nil, strings.Split(s, ",")...)
+}
+
+// Returns every tag in the history and the number of entries that have it.
+func tagCounts() map[string]int {
+	counts := make(map[string]int)
+
+	if storeEnabled() {
+		for _, tags := range storeTags() {
+			for _, t := range decodeTags(tags) {
+				counts[t]++
+			}
+		}
+
+		return counts
+	}
+
+	for _, e := range appConf.Log {
+		for _, t := range e.Tags {
+			counts[t]++
+		}
+	}
+
+	return counts
+}
+
+// Returns the tags in counts in alphabetical order.
+func sortedTags(counts map[string]int) []string {
+	tags := make([]string, 0, len(counts))
+	for t := range counts {
+		tags = append(tags, t)
+	}
+	slices.Sort(tags)
+
+	return tags
+}
+
+// Returns true if e should be shown for the current tag filter. When using the
+// sqlite store, the filter has already been applied when loading history.
+func matchesTag(e ClipboardEntry) bool {
+	return tagFilter == "" || storeEnabled() || hasTag(e, tagFilter)
+}
+
+// Sets the tag filter and reloads the history to match it.
+func filterTag(tag string) {
+	tagFilter = tag
+	pageOffset = 0
+	loadHistory()
+	reconstruct()
+}
+
+// Returns the label for the tag filter button.
+func tagFilterLabel() string {
+	if tagFilter == "" {
+		return TAG_FILTER_ALL
+	}
+
+	return "#" + tagFilter
+}
+
+// Fills m with every tag and its count and pops it up. Choosing a tag only
+// shows the entries that have it.
+func popupTagFilter(m *fltk.MenuButton) {
+	counts := tagCounts()
+
+	m.Clear()
+
+	flags := fltk.MENU_RADIO
+	if tagFilter == "" {
+		flags |= fltk.MENU_VALUE
+	}
+	m.AddEx(TAG_FILTER_ALL, 0, func() { filterTag("") }, flags|fltk.MENU_DIVIDER)
+
+	for _, t := range sortedTags(counts) {
+		flags := fltk.MENU_RADIO
+		if t == tagFilter {
+			flags |= fltk.MENU_VALUE
+		}
+		m.AddEx(fmt.Sprintf("%v (%v)", menuLabel(t), counts[t]), 0, func() { filterTag(t) }, flags)
+	}
+
+	if len(counts) == 0 {
+		m.AddEx("No tags yet", 0, func() {}, fltk.MENU_INACTIVE)
+	}
+
+	m.Popup()
+	m.Clear()
+}
+
+// Adds tags to, or removes tags from, the entries at the provided indices.
+func setTags(indices []int, add []string, remove string) {
+	for _, j := range indices {
+		tags := addTags(appConf.Log[j].Tags, add...)
+		if remove != "" {
+			tags = removeTag(tags, remove)
+		}
+		appConf.Log[j].Tags = tags
+		updateEntry(j)
+	}
+}
+
+// Removes tag from the selected entries if they all have it, and adds it to
+// them otherwise.
+func toggleTag(tag string) {
+	indices := selectedIndices()
+
+	all := true
+	for _, j := range indices {
+		all = all && hasTag(appConf.Log[j], tag)
+	}
+
+	if all {
+		setTags(indices, nil, tag)
+		setStatus(fmt.Sprintf("removed #%v from %v items", tag, len(indices)))
+	} else {
+		setTags(indices, []string{tag}, "")
+		setStatus(fmt.Sprintf("tagged %v items with #%v", len(indices), tag))
+	}
+
+	reconstruct()
+}
+
+// Adds the tag items for the entries at the provided indices to m: one for
+// adding new tags, and one for toggling each existing tag, checked if every
+// entry has it.
+func addTagItems(m *fltk.MenuButton, indices []int, shortcut int) {
+	m.AddEx("Tags/Add Tags...", shortcut, tagDialog, fltk.MENU_DIVIDER)
+
+	for _, t := range sortedTags(tagCounts()) {
+		flags := fltk.MENU_TOGGLE
+		all := true
+		for _, j := range indices {
+			all = all && hasTag(appConf.Log[j], t)
+		}
+		if all {
+			flags |= fltk.MENU_VALUE
+		}
+		m.AddEx("Tags/"+menuLabel(t), 0, func() { toggleTag(t) }, flags)
+	}
+}
+
+// Shows a dialog for adding tags to the selected entries.
+func tagDialog() {
+	indices := selectedIndices()
+	if len(indices) == 0 {
+		setStatus("nothing selected to tag")
+		return
+	}
+
+	ids := make([]string, len(indices))
+	for n, j := range indices {
+		ids[n] = appConf.Log[j].ID
+	}
+
+	dialog := fltk.NewWindow(320, 110, fmt.Sprintf("Tag %v Entries", len(indices)))
+	dialog.SetModal()
+
+	tagsInput := fltk.NewInput(10, 25, 300, 25, "&Tags (separated by commas)")
+	tagsInput.SetAlign(fltk.ALIGN_TOP_LEFT)
+	cancelBtn := fltk.NewButton(10, 70, 145, 30, "Cancel")
+	addBtn := fltk.NewReturnButton(165, 70, 145, 30, "&Add")
+	dialog.End()
+
+	closeDialog := func() {
+		dialog.Hide()
+		dialog.Destroy()
+	}
+
+	cancelBtn.SetCallback(closeDialog)
+
+	addBtn.SetCallback(func() {
+		tags := addTags(nil, strings.Split(tagsInput.Value(), ",")...)
+		closeDialog()
+		if len(tags) == 0 {
+			return
+		}
+
+		// the history may have changed while the dialog was open
+		targets := []int{}
+		for j, e := range appConf.Log {
+			if slices.Contains(ids, e.ID) {
+				targets = append(targets, j)
+			}
+		}
+
+		setTags(targets, tags, "")
+		setStatus(fmt.Sprintf("tagged %v items with #%v", len(targets), strings.Join(tags, " #")))
+		reconstruct()
+	})
+
+	dialog.Show()
+	tagsInput.TakeFocus()
+}
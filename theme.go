@@ -0,0 +1,358 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/godbus/dbus/v5"
+	"github.com/pwiecz/go-fltk"
+)
+
+const (
+	// Follows the desktop's dark or light preference, switching between the
+	// configured dark and light themes.
+	THEME_AUTO = "auto"
+
+	DEFAULT_DARK_THEME  = "dark"
+	DEFAULT_LIGHT_THEME = "light"
+
+	// The directory next to the config file that user-defined themes are
+	// loaded from, one json file per theme.
+	THEMES_DIR = "themes"
+
+	PORTAL_DEST      = "org.freedesktop.portal.Desktop"
+	PORTAL_PATH      = dbus.ObjectPath("/org/freedesktop/portal/desktop")
+	PORTAL_SETTINGS  = "org.freedesktop.portal.Settings"
+	PORTAL_NAMESPACE = "org.freedesktop.appearance"
+	PORTAL_KEY       = "color-scheme"
+	// Values of the portal's color-scheme setting.
+	PORTAL_PREFER_DARK = 1
+)
+
+// The colors of the interface, each written as #rrggbb.
+type Theme struct {
+	Name string `json:"name"`
+	// Labels of buttons, inputs and the history.
+	Text string `json:"text"`
+	// The window and dialogs.
+	Background string `json:"background"`
+	// Text typed into inputs and shown in the history.
+	Foreground string `json:"foreground"`
+	// Buttons and check buttons.
+	Button string `json:"button"`
+	// Text inputs.
+	Input string `json:"input"`
+	// The rows of the history.
+	Browser string `json:"browser"`
+	// Selected rows and text.
+	Selection string `json:"selection"`
+}
+
+var builtinThemes = []Theme{
+	{
+		Name:       "light",
+		Text:       "#200305",
+		Background: "#c0c0c0",
+		Foreground: "#000000",
+		Button:     "#c0c0c0",
+		Input:      "#ffffff",
+		Browser:    "#ffffff",
+		Selection:  "#000080",
+	},
+	{
+		Name:       "dark",
+		Text:       "#9f9f9f",
+		Background: "#282828",
+		Foreground: "#e6e6e6",
+		Button:     "#202020",
+		Input:      "#202020",
+		Browser:    "#202020",
+		Selection:  "#afafaf",
+	},
+	{
+		Name:       "solarized-light",
+		Text:       "#586e75",
+		Background: "#eee8d5",
+		Foreground: "#073642",
+		Button:     "#eee8d5",
+		Input:      "#fdf6e3",
+		Browser:    "#fdf6e3",
+		Selection:  "#268bd2",
+	},
+	{
+		Name:       "solarized-dark",
+		Text:       "#93a1a1",
+		Background: "#073642",
+		Foreground: "#eee8d5",
+		Button:     "#073642",
+		Input:      "#002b36",
+		Browser:    "#002b36",
+		Selection:  "#268bd2",
+	},
+	{
+		Name:       "nord",
+		Text:       "#d8dee9",
+		Background: "#3b4252",
+		Foreground: "#eceff4",
+		Button:     "#434c5e",
+		Input:      "#2e3440",
+		Browser:    "#2e3440",
+		Selection:  "#5e81ac",
+	},
+	{
+		Name:       "high-contrast",
+		Text:       "#ffffff",
+		Background: "#000000",
+		Foreground: "#ffffff",
+		Button:     "#000000",
+		Input:      "#000000",
+		Browser:    "#000000",
+		Selection:  "#ffff00",
+	},
+}
+
+var (
+	// The preference of the desktop, as last reported by the settings portal.
+	desktopPrefersDark bool
+	// True once the settings portal is being watched.
+	watchingDesktopTheme bool
+)
+
+// Returns the directory that user-defined themes are loaded from.
+func themesDir() string {
+	return filepath.Join(filepath.Dir(configFilePath), THEMES_DIR)
+}
+
+// Parses a color written as #rrggbb.
+func parseColor(s string) (uint8, uint8, uint8, error) {
+	h := strings.TrimPrefix(strings.TrimSpace(s), "#")
+	if len(h) != 6 {
+		return 0, 0, 0, fmt.Errorf("invalid color %q, expected #rrggbb", s)
+	}
+
+	v, err := strconv.ParseUint(h, 16, 32)
+	if err != nil {
+		return 0, 0, 0, fmt.Errorf("invalid color %q: %v", s, err.Error())
+	}
+
+	return uint8(v >> 16), uint8(v >> 8), uint8(v), nil
+}
+
+// Converts a color written as #rrggbb to an fltk color.
+func themeColor(s string) fltk.Color {
+	r, g, b, _ := parseColor(s)
+	return fltk.Color(uint32(r)<<24 | uint32(g)<<16 | uint32(b)<<8)
+}
+
+// Returns an error if any of the theme's colors is invalid. Colors that are
+// unset are taken from fallback.
+func (t *Theme) validate(fallback Theme) error {
+	fields := []struct {
+		value    *string
+		fallback string
+	}{
+		{&t.Text, fallback.Text},
+		{&t.Background, fallback.Background},
+		{&t.Foreground, fallback.Foreground},
+		{&t.Button, fallback.Button},
+		{&t.Input, fallback.Input},
+		{&t.Browser, fallback.Browser},
+		{&t.Selection, fallback.Selection},
+	}
+
+	for _, f := range fields {
+		if *f.value == "" {
+			*f.value = f.fallback
+			continue
+		}
+
+		_, _, _, err := parseColor(*f.value)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Returns the built-in themes followed by the themes in the themes directory.
+// A user-defined theme with the same name as a built-in one replaces it.
+// Themes without a name are named after their file.
+func loadThemes() []Theme {
+	themes := slices.Clone(builtinThemes)
+
+	files, err := filepath.Glob(filepath.Join(themesDir(), "*.json"))
+	if err != nil {
+		log.Printf("failed to list themes: %v", err.Error())
+		return themes
+	}
+
+	for _, f := range files {
+		b, err := os.ReadFile(f)
+		if err != nil {
+			log.Printf("failed to read theme %v: %v", f, err.Error())
+			continue
+		}
+
+		var t Theme
+		err = json.Unmarshal(b, &t)
+		if err != nil {
+			log.Printf("theme %v failed to parse: %v", f, err.Error())
+			continue
+		}
+
+		if t.Name == "" {
+			t.Name = strings.TrimSuffix(filepath.Base(f), filepath.Ext(f))
+		}
+
+		// unset colors are taken from the theme being replaced, if any
+		fallback := builtinThemes[0]
+		i := slices.IndexFunc(themes, func(b Theme) bool { return b.Name == t.Name })
+		if i >= 0 {
+			fallback = themes[i]
+		}
+
+		err = t.validate(fallback)
+		if err != nil {
+			log.Printf("theme %v is invalid: %v", f, err.Error())
+			continue
+		}
+
+		if i >= 0 {
+			themes[i] = t
+		} else {
+			themes = append(themes, t)
+		}
+	}
+
+	return themes
+}
+
+// Returns the theme with the provided name, or the light theme if there is
+// none.
+func findTheme(themes []Theme, name string) Theme {
+	for _, t := range themes {
+		if t.Name == name {
+			return t
+		}
+	}
+
+	log.Printf("theme %v not found, using %v", name, DEFAULT_LIGHT_THEME)
+
+	return builtinThemes[0]
+}
+
+// Returns the name of the theme that should currently be shown, resolving
+// auto to the configured dark or light theme.
+func currentThemeName() string {
+	if appConf.Theme != THEME_AUTO {
+		return appConf.Theme
+	}
+
+	if desktopPrefersDark {
+		return appConf.DarkTheme
+	}
+
+	return appConf.LightTheme
+}
+
+// Loads the themes and applies the configured one to the whole interface.
+func applyCurrentTheme() {
+	t := findTheme(loadThemes(), currentThemeName())
+	log.Printf("%v theme activated", t.Name)
+	theme(t)
+}
+
+// Unwraps a value returned by the settings portal, which older versions wrap
+// in an extra variant.
+func portalValue(v dbus.Variant) (uint32, bool) {
+	for {
+		switch x := v.Value().(type) {
+		case dbus.Variant:
+			v = x
+		case uint32:
+			return x, true
+		default:
+			return 0, false
+		}
+	}
+}
+
+// Reads the desktop's dark or light preference from the settings portal and
+// keeps desktopPrefersDark up to date, calling changed on the main thread
+// whenever it changes. Does nothing if it is already being watched.
+func watchDesktopTheme(changed func()) error {
+	if watchingDesktopTheme {
+		return nil
+	}
+
+	conn, err := dbus.SessionBus()
+	if err != nil {
+		return fmt.Errorf("failed to connect to the session bus: %v", err.Error())
+	}
+
+	obj := conn.Object(PORTAL_DEST, PORTAL_PATH)
+
+	var v dbus.Variant
+	err = obj.Call(PORTAL_SETTINGS+".ReadOne", 0, PORTAL_NAMESPACE, PORTAL_KEY).Store(&v)
+	if err != nil {
+		// ReadOne was added in version 2 of the portal
+		err = obj.Call(PORTAL_SETTINGS+".Read", 0, PORTAL_NAMESPACE, PORTAL_KEY).Store(&v)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read the desktop's color scheme: %v", err.Error())
+	}
+
+	if scheme, ok := portalValue(v); ok {
+		desktopPrefersDark = scheme == PORTAL_PREFER_DARK
+	}
+
+	err = conn.AddMatchSignal(
+		dbus.WithMatchObjectPath(PORTAL_PATH),
+		dbus.WithMatchInterface(PORTAL_SETTINGS),
+		dbus.WithMatchMember("SettingChanged"),
+	)
+	if err != nil {
+		return fmt.Errorf("failed to watch the desktop's color scheme: %v", err.Error())
+	}
+
+	signals := make(chan *dbus.Signal, 10)
+	conn.Signal(signals)
+	watchingDesktopTheme = true
+
+	go func() {
+		for s := range signals {
+			if s.Name != PORTAL_SETTINGS+".SettingChanged" || len(s.Body) != 3 {
+				continue
+			}
+
+			namespace, _ := s.Body[0].(string)
+			key, _ := s.Body[1].(string)
+			value, _ := s.Body[2].(dbus.Variant)
+			if namespace != PORTAL_NAMESPACE || key != PORTAL_KEY {
+				continue
+			}
+
+			scheme, ok := portalValue(value)
+			if !ok {
+				continue
+			}
+
+			dark := scheme == PORTAL_PREFER_DARK
+			fltk.Awake(func() {
+				if dark != desktopPrefersDark {
+					desktopPrefersDark = dark
+					changed()
+				}
+			})
+		}
+	}()
+
+	return nil
+}
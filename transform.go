@@ -0,0 +1,189 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/url"
+	"regexp"
+	"slices"
+	"strings"
+	"unicode"
+
+	"github.com/atotto/clipboard"
+	"github.com/pwiecz/go-fltk"
+)
+
+// A text transformation that can be applied to entries.
+type Transform struct {
+	// A short, stable identifier for the transform.
+	Name string
+	// Shown in the transform menu.
+	Label string
+	Apply func(string) (string, error)
+}
+
+// Matches ANSI escape sequences: CSI sequences such as colors, and OSC
+// sequences such as terminal titles and hyperlinks.
+var ansiPattern = regexp.MustCompile(`\x1b\[[0-?]*[ -/]*[@-~]|\x1b\][^\x07\x1b]*(\x07|\x1b\\)|\x1b[@-Z\\-_]`)
+
+// Matches line breaks along with the whitespace surrounding them.
+var lineBreakPattern = regexp.MustCompile(`[ \t]*\r?\n[ \t]*`)
+
+// Every available transform, in the order they are shown in the menu.
+var transforms = []Transform{
+	{Name: "trim", Label: "Trim Whitespace", Apply: func(s string) (string, error) {
+		return strings.TrimSpace(s), nil
+	}},
+	{Name: "upper", Label: "UPPER CASE", Apply: func(s string) (string, error) {
+		return strings.ToUpper(s), nil
+	}},
+	{Name: "lower", Label: "lower case", Apply: func(s string) (string, error) {
+		return strings.ToLower(s), nil
+	}},
+	{Name: "title", Label: "Title Case", Apply: func(s string) (string, error) {
+		return titleCase(s), nil
+	}},
+	{Name: "url-encode", Label: "URL Encode", Apply: func(s string) (string, error) {
+		return url.QueryEscape(s), nil
+	}},
+	{Name: "url-decode", Label: "URL Decode", Apply: func(s string) (string, error) {
+		return url.QueryUnescape(s)
+	}},
+	{Name: "base64-encode", Label: "Base64 Encode", Apply: func(s string) (string, error) {
+		return base64.StdEncoding.EncodeToString([]byte(s)), nil
+	}},
+	{Name: "base64-decode", Label: "Base64 Decode", Apply: base64Decode},
+	{Name: "json-pretty", Label: "JSON Pretty Print", Apply: func(s string) (string, error) {
+		b := new(bytes.Buffer)
+		err := json.Indent(b, []byte(s), "", "  ")
+		return b.String(), err
+	}},
+	{Name: "json-minify", Label: "JSON Minify", Apply: func(s string) (string, error) {
+		b := new(bytes.Buffer)
+		err := json.Compact(b, []byte(s))
+		return b.String(), err
+	}},
+	{Name: "sort-lines", Label: "Sort Lines", Apply: func(s string) (string, error) {
+		lines := strings.Split(s, "\n")
+		slices.Sort(lines)
+		return strings.Join(lines, "\n"), nil
+	}},
+	{Name: "unique-lines", Label: "Unique Lines", Apply: func(s string) (string, error) {
+		seen := make(map[string]bool)
+		lines := []string{}
+		for _, line := range strings.Split(s, "\n") {
+			if seen[line] {
+				continue
+			}
+			seen[line] = true
+			lines = append(lines, line)
+		}
+		return strings.Join(lines, "\n"), nil
+	}},
+	{Name: "strip-ansi", Label: "Strip ANSI Codes", Apply: func(s string) (string, error) {
+		return ansiPattern.ReplaceAllString(s, ""), nil
+	}},
+	{Name: "join-lines", Label: "Remove Line Breaks", Apply: func(s string) (string, error) {
+		return lineBreakPattern.ReplaceAllString(strings.TrimSpace(s), " "), nil
+	}},
+	{Name: "shell-escape", Label: "Escape for Shell", Apply: func(s string) (string, error) {
+		return shellQuote(s), nil
+	}},
+}
+
+// Returns the transform with the provided name.
+func findTransform(name string) (Transform, bool) {
+	for _, t := range transforms {
+		if t.Name == name {
+			return t, true
+		}
+	}
+
+	return Transform{}, false
+}
+
+// Upper-cases the first letter of every word, and lower-cases the rest.
+func titleCase(s string) string {
+	start := true
+	return strings.Map(func(r rune) rune {
+		if unicode.IsSpace(r) {
+			start = true
+			return r
+		}
+
+		if start {
+			start = false
+			return unicode.ToTitle(r)
+		}
+
+		return unicode.ToLower(r)
+	}, s)
+}
+
+// Decodes standard or url-safe base64, with or without padding.
+func base64Decode(s string) (string, error) {
+	s = strings.Join(strings.Fields(s), "")
+	for _, enc := range []*base64.Encoding{
+		base64.StdEncoding, base64.RawStdEncoding, base64.URLEncoding, base64.RawURLEncoding,
+	} {
+		b, err := enc.DecodeString(s)
+		if err == nil {
+			return string(b), nil
+		}
+	}
+
+	return "", fmt.Errorf("not valid base64")
+}
+
+// Adds the transform menu's items to m, one per transform.
+func addTransformItems(m *fltk.MenuButton) {
+	for _, t := range transforms {
+		m.Add(t.Label, func() { transformSelected(t) })
+	}
+}
+
+// Applies t to each selected entry, adds the results to the history as new
+// entries, and copies them.
+func transformSelected(t Transform) {
+	indices := selectedIndices()
+	if len(indices) == 0 {
+		setStatus("nothing selected to transform")
+		return
+	}
+
+	results := []string{}
+	for _, j := range indices {
+		v, err := t.Apply(entryValue(appConf.Log[j]))
+		if err != nil {
+			fltk.MessageBox("Error", fmt.Sprintf("Failed to %v: %v", strings.ToLower(t.Label), err.Error()))
+			return
+		}
+		results = append(results, v)
+	}
+
+	for _, v := range results {
+		addValue(v)
+	}
+
+	loadHistory()
+	reconstruct()
+
+	// each result is already in the history, so the combined value would only
+	// add a duplicate of them
+	result := strings.Join(results, appConf.CopyFormat.separator())
+	if len(results) > 1 {
+		ignoreCapture(result)
+	}
+	err := clipboard.WriteAll(result)
+	if err != nil {
+		fltk.MessageBox("Error", fmt.Sprintf("Failed to write to clipboard: %v", err.Error()))
+		return
+	}
+
+	msg := fmt.Sprintf("%v: %v items transformed", strings.ToLower(t.Label), len(results))
+	setStatus(msg)
+	log.Println(msg)
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestTransforms(t *testing.T) {
+	tests := []struct {
+		name    string
+		in      string
+		want    string
+		wantErr bool
+	}{
+		{name: "trim", in: " \t hello world \n", want: "hello world"},
+		{name: "trim", in: "   ", want: ""},
+		{name: "upper", in: "Hello, wörld", want: "HELLO, WÖRLD"},
+		{name: "lower", in: "Hello, WÖRLD", want: "hello, wörld"},
+		{name: "title", in: "hello wORLD", want: "Hello World"},
+		{name: "title", in: "élan ünd ÄRGER", want: "Élan Ünd Ärger"},
+		// title case differs from upper case for digraphs
+		{name: "title", in: "ǆemal", want: "ǅemal"},
+		// ß has no single-rune title case, so it is kept
+		{name: "title", in: "ßtraße", want: "ßtraße"},
+		{name: "title", in: "привет мир", want: "Привет Мир"},
+		{name: "title", in: "two\nlines\there", want: "Two\nLines\tHere"},
+		{name: "url-encode", in: "a b&c=d/é", want: "a+b%26c%3Dd%2F%C3%A9"},
+		{name: "url-decode", in: "a+b%26c%3Dd%2F%C3%A9", want: "a b&c=d/é"},
+		{name: "url-decode", in: "100%", wantErr: true},
+		{name: "base64-encode", in: "hello?", want: "aGVsbG8/"},
+		{name: "base64-decode", in: "aGVsbG8/", want: "hello?"},
+		{name: "base64-decode", in: "aGVsbG8_", want: "hello?"},
+		{name: "base64-decode", in: "aGk", want: "hi"},
+		{name: "base64-decode", in: "aGVs\nbG8/", want: "hello?"},
+		{name: "base64-decode", in: "not base64!", wantErr: true},
+		{name: "base64-decode", in: "a", wantErr: true},
+		{name: "json-pretty", in: `{"a":[1,2]}`, want: "{\n  \"a\": [\n    1,\n    2\n  ]\n}"},
+		{name: "json-pretty", in: `{"a":`, wantErr: true},
+		{name: "json-minify", in: "{\n  \"a\": [1, 2]\n}", want: `{"a":[1,2]}`},
+		{name: "json-minify", in: "[1,", wantErr: true},
+		{name: "sort-lines", in: "b\na\nc\na", want: "a\na\nb\nc"},
+		{name: "unique-lines", in: "b\na\nb\nc\na", want: "b\na\nc"},
+		{name: "strip-ansi", in: "\x1b[1;31mred\x1b[0m \x1b]0;title\x07text \x1b]8;;http://x\x1b\\link\x1b]8;;\x1b\\", want: "red text link"},
+		{name: "join-lines", in: "  one \n two\r\n\tthree  ", want: "one two three"},
+		{name: "shell-escape", in: "it's $HOME", want: `'it'\''s $HOME'`},
+	}
+
+	tested := make(map[string]bool)
+	for _, test := range tests {
+		tested[test.name] = true
+
+		tr, ok := findTransform(test.name)
+		if !ok {
+			t.Fatalf("transform %v is not registered", test.name)
+		}
+
+		got, err := tr.Apply(test.in)
+		if test.wantErr {
+			if err == nil {
+				t.Errorf("%v(%q) = %q, want an error", test.name, test.in, got)
+			}
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("%v(%q) failed: %v", test.name, test.in, err)
+			continue
+		}
+
+		if got != test.want {
+			t.Errorf("%v(%q) = %q, want %q", test.name, test.in, got, test.want)
+		}
+	}
+
+	for _, tr := range transforms {
+		if !tested[tr.Name] {
+			t.Errorf("transform %v has no test", tr.Name)
+		}
+	}
+}
+
+func TestFindTransform(t *testing.T) {
+	if _, ok := findTransform("missing"); ok {
+		t.Errorf("found a transform that is not registered")
+	}
+
+	seen := make(map[string]bool)
+	for _, tr := range transforms {
+		if seen[tr.Name] {
+			t.Errorf("transform %v is registered twice", tr.Name)
+		}
+		seen[tr.Name] = true
+
+		if tr.Label == "" || tr.Apply == nil {
+			t.Errorf("transform %v is missing a label or function", tr.Name)
+		}
+	}
+}
+
+func TestCaptureIgnored(t *testing.T) {
+	ignoreCapture("a\nb")
+
+	if captureIgnored("c") {
+		t.Errorf("ignored a value that was not placed on the clipboard")
+	}
+
+	ignoreCapture("a\nb")
+	if !captureIgnored("a\nb") {
+		t.Errorf("captured a value that was placed on the clipboard")
+	}
+
+	if captureIgnored("a\nb") {
+		t.Errorf("ignored a value that was copied again")
+	}
+}
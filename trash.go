@@ -0,0 +1,193 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/pwiecz/go-fltk"
+)
+
+const (
+	// The number of days that deleted entries are kept in the trash, unless
+	// overridden by trashDays in the config.
+	DEFAULT_TRASH_DAYS = 7
+	// Deleting more entries than this at once asks for confirmation, unless
+	// overridden by confirmDeleteCount in the config.
+	DEFAULT_CONFIRM_DELETE_COUNT = 10
+)
+
+// A deleted entry, kept until it is restored or the trash period passes.
+type TrashEntry struct {
+	ClipboardEntry
+	// When the entry was deleted.
+	DeletedAt time.Time `json:"deletedAt"`
+}
+
+// Returns true if deleted entries are kept in the trash.
+func trashEnabled() bool {
+	return appConf.TrashDays > 0
+}
+
+// Adds a deleted entry to the trash, unless the trash is disabled.
+func trashEntry(e ClipboardEntry) {
+	if !trashEnabled() {
+		return
+	}
+
+	untrashEntry(e.ID)
+
+	e.Selected = false
+	appConf.Trash = append(appConf.Trash, TrashEntry{ClipboardEntry: e, DeletedAt: time.Now()})
+}
+
+// Removes the entry with the provided id from the trash, keeping its blob.
+func untrashEntry(id string) {
+	appConf.Trash = slices.DeleteFunc(appConf.Trash, func(e TrashEntry) bool {
+		return e.ID == id
+	})
+}
+
+// Permanently deletes the entries in the trash that match, along with blobs
+// that are no longer referenced. Returns the number of deleted entries.
+func purgeTrash(match func(e TrashEntry) bool) int {
+	purged := []TrashEntry{}
+	kept := []TrashEntry{}
+	for _, e := range appConf.Trash {
+		if match(e) {
+			purged = append(purged, e)
+		} else {
+			kept = append(kept, e)
+		}
+	}
+
+	appConf.Trash = kept
+	for _, e := range purged {
+		removeBlob(e.ClipboardEntry, appConf.Log)
+	}
+
+	return len(purged)
+}
+
+// Permanently deletes the entries that have been in the trash for longer than
+// the trash period. Returns the number of deleted entries.
+func expireTrash(now time.Time) int {
+	period := time.Duration(appConf.TrashDays) * 24 * time.Hour
+
+	n := purgeTrash(func(e TrashEntry) bool {
+		return !trashEnabled() || now.Sub(e.DeletedAt) > period
+	})
+	if n > 0 {
+		log.Printf("permanently deleted %v entries from the trash", n)
+	}
+
+	return n
+}
+
+// Moves the entries with the provided ids from the trash back into the
+// history. The restore can be undone.
+func restoreFromTrash(ids []string) int {
+	changes := []entryChange{}
+	for _, e := range appConf.Trash {
+		if slices.Contains(ids, e.ID) {
+			restored := e.ClipboardEntry
+			changes = append(changes, entryChange{ID: e.ID, After: &restored})
+		}
+	}
+
+	pushUndo(UNDO_RESTORE, changes)
+	applyChanges(changes, false)
+
+	return len(changes)
+}
+
+// Shows the entries in the trash, most recently deleted first, with buttons
+// to restore them or delete them permanently.
+func trashDialog() {
+	dialog := fltk.NewWindow(480, 360, "Trash")
+	dialog.SetModal()
+
+	browser := fltk.NewMultiBrowser(10, 10, 460, 295)
+	restoreBtn := fltk.NewButton(10, 320, 110, 30, "&Restore")
+	deleteBtn := fltk.NewButton(125, 320, 110, 30, "&Delete")
+	emptyBtn := fltk.NewButton(240, 320, 110, 30, "&Empty Trash")
+	closeBtn := fltk.NewButton(355, 320, 115, 30, "Close")
+	dialog.Resizable(browser)
+	dialog.End()
+
+	// the ids of the entries in each row of the browser, starting at row 1
+	ids := []string{}
+
+	refresh := func() {
+		browser.Clear()
+		ids = ids[:0]
+		for i := len(appConf.Trash) - 1; i >= 0; i-- {
+			e := appConf.Trash[i]
+			v := strings.ReplaceAll(obscure(e.Value, appConf.Secrets), "\n", "\\n")
+			v = v[0:minz(len(v), 200)]
+			browser.Add(fmt.Sprintf("%v  %v", e.DeletedAt.Format("2006-01-02 15:04"), v))
+			ids = append(ids, e.ID)
+		}
+
+		if trashEnabled() {
+			dialog.SetLabel(fmt.Sprintf("Trash (%v entries, kept for %v days)", len(ids), appConf.TrashDays))
+		} else {
+			dialog.SetLabel("Trash (disabled)")
+		}
+	}
+
+	selected := func() []string {
+		result := []string{}
+		for i := 1; i <= len(ids); i++ {
+			if browser.IsSelected(i) {
+				result = append(result, ids[i-1])
+			}
+		}
+
+		return result
+	}
+
+	restoreBtn.SetCallback(func() {
+		n := restoreFromTrash(selected())
+		setStatus(fmt.Sprintf("restored %v items", n))
+		reconstruct()
+		refresh()
+	})
+
+	deleteBtn.SetCallback(func() {
+		sel := selected()
+		if len(sel) == 0 {
+			return
+		}
+
+		if fltk.ChoiceDialog(fmt.Sprintf("Permanently delete %v entries?", len(sel)), "Cancel", "Delete") != 1 {
+			return
+		}
+
+		purgeTrash(func(e TrashEntry) bool { return slices.Contains(sel, e.ID) })
+		refresh()
+	})
+
+	emptyBtn.SetCallback(func() {
+		if len(ids) == 0 {
+			return
+		}
+
+		if fltk.ChoiceDialog(fmt.Sprintf("Permanently delete all %v entries in the trash?", len(ids)), "Cancel", "Empty Trash") != 1 {
+			return
+		}
+
+		purgeTrash(func(TrashEntry) bool { return true })
+		refresh()
+	})
+
+	closeBtn.SetCallback(func() {
+		dialog.Hide()
+		dialog.Destroy()
+	})
+
+	refresh()
+	dialog.Show()
+}
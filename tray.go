@@ -0,0 +1,498 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/atotto/clipboard"
+	"github.com/godbus/dbus/v5"
+	"github.com/godbus/dbus/v5/prop"
+	"github.com/pwiecz/go-fltk"
+)
+
+const (
+	DEFAULT_TRAY_ENTRIES = 10
+
+	// The longest label shown for an entry in the tray menu.
+	TRAY_LABEL_LENGTH = 50
+
+	TRAY_ITEM_PATH  = dbus.ObjectPath("/StatusNotifierItem")
+	TRAY_MENU_PATH  = dbus.ObjectPath("/MenuBar")
+	TRAY_ITEM_IFACE = "org.kde.StatusNotifierItem"
+	TRAY_MENU_IFACE = "com.canonical.dbusmenu"
+	TRAY_WATCHER    = "org.kde.StatusNotifierWatcher"
+
+	TRAY_ICON        = "edit-paste"
+	TRAY_ICON_PAUSED = "media-playback-pause"
+)
+
+const (
+	// Ids of the items in the tray menu. Entries are numbered from
+	// TRAY_ITEM_ENTRY, newest first.
+	TRAY_ITEM_ROOT int32 = iota
+	TRAY_ITEM_PAUSE
+	TRAY_ITEM_OPEN
+	TRAY_ITEM_CLEAR
+	TRAY_ITEM_QUIT
+	TRAY_ITEM_SEPARATOR
+	TRAY_ITEM_EMPTY
+	TRAY_ITEM_ENTRY int32 = 100
+)
+
+// An entry as shown in the tray menu.
+type trayEntry struct {
+	ID    string
+	Label string
+}
+
+// A node in the dbusmenu layout, with the (ia{sv}av) signature.
+type trayMenuLayout struct {
+	ID         int32
+	Properties map[string]dbus.Variant
+	Children   []dbus.Variant
+}
+
+// The properties of a single dbusmenu item, with the (ia{sv}) signature.
+type trayMenuProps struct {
+	ID         int32
+	Properties map[string]dbus.Variant
+}
+
+// A dbusmenu event, with the (isvu) signature.
+type trayMenuEvent struct {
+	ID        int32
+	EventID   string
+	Data      dbus.Variant
+	Timestamp uint32
+}
+
+var (
+	trayMu   sync.Mutex
+	trayConn *dbus.Conn
+	// Whether a StatusNotifierWatcher accepted the icon, meaning that it is
+	// actually shown somewhere.
+	trayRegistered bool
+	trayProps      *prop.Properties
+	// A snapshot of what the tray menu shows, so that it can be served from
+	// D-Bus goroutines without touching the log.
+	trayEntries  []trayEntry
+	trayPaused   bool
+	trayRevision uint32 = 1
+	// Called when quit is chosen from the tray menu.
+	trayQuit func()
+)
+
+// Implements org.kde.StatusNotifierItem.
+type trayItem struct{}
+
+// Implements com.canonical.dbusmenu.
+type trayMenu struct{}
+
+// Returns true if the tray icon is shown.
+func trayActive() bool {
+	trayMu.Lock()
+	defer trayMu.Unlock()
+
+	return trayConn != nil && trayRegistered
+}
+
+// Connects to the session bus and registers the tray icon with the
+// StatusNotifierWatcher. The icon is registered again whenever the watcher
+// restarts, such as when the panel is restarted. quit is called when quit is
+// chosen from the menu.
+func setupTray(quit func()) error {
+	conn, err := dbus.ConnectSessionBus()
+	if err != nil {
+		return fmt.Errorf("failed to connect to the session bus: %v", err.Error())
+	}
+
+	name := fmt.Sprintf("org.kde.StatusNotifierItem-%v-1", os.Getpid())
+	_, err = conn.RequestName(name, dbus.NameFlagDoNotQueue)
+	if err != nil {
+		conn.Close()
+		return fmt.Errorf("failed to request %v: %v", name, err.Error())
+	}
+
+	err = conn.Export(trayItem{}, TRAY_ITEM_PATH, TRAY_ITEM_IFACE)
+	if err == nil {
+		err = conn.Export(trayMenu{}, TRAY_MENU_PATH, TRAY_MENU_IFACE)
+	}
+	if err != nil {
+		conn.Close()
+		return fmt.Errorf("failed to export tray icon: %v", err.Error())
+	}
+
+	props, err := prop.Export(conn, TRAY_ITEM_PATH, prop.Map{
+		TRAY_ITEM_IFACE: {
+			"Category":   {Value: "ApplicationStatus", Emit: prop.EmitFalse},
+			"Id":         {Value: "go-fltk-clipboard", Emit: prop.EmitFalse},
+			"Title":      {Value: WINDOW_TITLE, Emit: prop.EmitFalse},
+			"Status":     {Value: "Active", Emit: prop.EmitFalse},
+			"IconName":   {Value: TRAY_ICON, Emit: prop.EmitFalse},
+			"WindowId":   {Value: int32(0), Emit: prop.EmitFalse},
+			"ItemIsMenu": {Value: false, Emit: prop.EmitFalse},
+			"Menu":       {Value: TRAY_MENU_PATH, Emit: prop.EmitFalse},
+		},
+	})
+	if err == nil {
+		_, err = prop.Export(conn, TRAY_MENU_PATH, prop.Map{
+			TRAY_MENU_IFACE: {
+				"Version":       {Value: uint32(3), Emit: prop.EmitFalse},
+				"TextDirection": {Value: "ltr", Emit: prop.EmitFalse},
+				"Status":        {Value: "normal", Emit: prop.EmitFalse},
+				"IconThemePath": {Value: []string{}, Emit: prop.EmitFalse},
+			},
+		})
+	}
+	if err != nil {
+		conn.Close()
+		return fmt.Errorf("failed to export tray properties: %v", err.Error())
+	}
+
+	trayMu.Lock()
+	trayConn = conn
+	trayProps = props
+	trayQuit = quit
+	trayMu.Unlock()
+
+	err = conn.AddMatchSignal(
+		dbus.WithMatchInterface("org.freedesktop.DBus"),
+		dbus.WithMatchMember("NameOwnerChanged"),
+		dbus.WithMatchArg(0, TRAY_WATCHER),
+	)
+	if err != nil {
+		log.Printf("tray icon won't be restored if the panel restarts: %v", err.Error())
+	}
+
+	signals := make(chan *dbus.Signal, 10)
+	conn.Signal(signals)
+	go func() {
+		for s := range signals {
+			if len(s.Body) != 3 {
+				continue
+			}
+
+			if s.Body[2] == "" {
+				// the watcher went away, so the icon isn't shown anymore
+				trayMu.Lock()
+				trayRegistered = false
+				trayMu.Unlock()
+				continue
+			}
+
+			err := registerTray(conn, name)
+			if err != nil {
+				log.Printf("failed to show tray icon: %v", err.Error())
+			}
+		}
+	}()
+
+	return registerTray(conn, name)
+}
+
+// Registers the tray icon with the StatusNotifierWatcher.
+func registerTray(conn *dbus.Conn, name string) error {
+	err := conn.Object(TRAY_WATCHER, "/StatusNotifierWatcher").
+		Call(TRAY_WATCHER+".RegisterStatusNotifierItem", 0, name).Err
+	trayMu.Lock()
+	trayRegistered = err == nil
+	trayMu.Unlock()
+
+	if err != nil {
+		return fmt.Errorf("no system tray is available: %v", err.Error())
+	}
+
+	log.Println("registered tray icon")
+
+	return nil
+}
+
+// Closes the tray icon's connection to the session bus, if it is open.
+func closeTray() {
+	trayMu.Lock()
+	defer trayMu.Unlock()
+
+	if trayConn == nil {
+		return
+	}
+
+	trayConn.Close()
+	trayConn = nil
+}
+
+// Returns the label for an entry in the tray menu. Secrets are obscured before
+// shortening the value, so that a cut off secret can't slip through, and
+// underscores are escaped since they would otherwise mark mnemonics.
+func trayLabel(e ClipboardEntry) string {
+	v := obscure(e.Value, appConf.Secrets)
+	v = strings.Join(strings.Fields(v), " ")
+	if r := []rune(v); len(r) > TRAY_LABEL_LENGTH {
+		v = string(r[:TRAY_LABEL_LENGTH]) + "…"
+	}
+
+	return strings.ReplaceAll(v, "_", "__")
+}
+
+// Updates the tray menu and icon to match the history and pause state, if
+// they have changed.
+func updateTray() {
+	trayMu.Lock()
+	open := trayConn != nil
+	trayMu.Unlock()
+
+	if !open {
+		return
+	}
+
+	entries := []trayEntry{}
+	for i := len(appConf.Log) - 1; i >= 0 && len(entries) < appConf.TrayEntries; i-- {
+		entries = append(entries, trayEntry{ID: appConf.Log[i].ID, Label: trayLabel(appConf.Log[i])})
+	}
+	paused := isPausedNoExpire()
+
+	trayMu.Lock()
+	defer trayMu.Unlock()
+
+	if trayConn == nil {
+		return
+	}
+
+	if paused != trayPaused {
+		trayPaused = paused
+		icon := TRAY_ICON
+		if paused {
+			icon = TRAY_ICON_PAUSED
+		}
+		trayProps.SetMust(TRAY_ITEM_IFACE, "IconName", icon)
+		_ = trayConn.Emit(TRAY_ITEM_PATH, TRAY_ITEM_IFACE+".NewIcon")
+	} else if slices.Equal(entries, trayEntries) {
+		return
+	}
+
+	trayEntries = entries
+	trayRevision++
+	_ = trayConn.Emit(TRAY_MENU_PATH, TRAY_MENU_IFACE+".LayoutUpdated", trayRevision, TRAY_ITEM_ROOT)
+}
+
+// Shows the window and focuses the search input.
+func showWindow() {
+	// the window wasn't shown by the hotkey, so there is nothing to paste into
+	forgetActiveWindow()
+	win.Show()
+
+	if currentPage == PAGE_MAIN {
+		searchInput.TakeFocus()
+	}
+}
+
+// Asks for confirmation, then deletes every entry that isn't pinned.
+func clearHistoryAction() {
+	if fltk.ChoiceDialog("Delete every entry that isn't pinned?", "Cancel", "Clear History") != 1 {
+		return
+	}
+
+	n := clearHistory()
+	reconstruct()
+
+	msg := fmt.Sprintf("%v items deleted", n)
+	setStatus(msg)
+	log.Println(msg)
+}
+
+// Returns a leaf item of the tray menu. Must be called with trayMu held.
+func trayMenuItem(id int32) trayMenuLayout {
+	props := map[string]dbus.Variant{}
+	switch {
+	case id == TRAY_ITEM_PAUSE:
+		label := "Pause Capture"
+		if trayPaused {
+			label = "Resume Capture"
+		}
+		props["label"] = dbus.MakeVariant(label)
+	case id == TRAY_ITEM_OPEN:
+		props["label"] = dbus.MakeVariant("Open Window")
+	case id == TRAY_ITEM_CLEAR:
+		props["label"] = dbus.MakeVariant("Clear History")
+	case id == TRAY_ITEM_QUIT:
+		props["label"] = dbus.MakeVariant("Quit")
+	case id == TRAY_ITEM_SEPARATOR:
+		props["type"] = dbus.MakeVariant("separator")
+	case id == TRAY_ITEM_EMPTY:
+		props["label"] = dbus.MakeVariant("(empty)")
+		props["enabled"] = dbus.MakeVariant(false)
+	case id >= TRAY_ITEM_ENTRY && int(id-TRAY_ITEM_ENTRY) < len(trayEntries):
+		props["label"] = dbus.MakeVariant(trayEntries[id-TRAY_ITEM_ENTRY].Label)
+	}
+
+	return trayMenuLayout{ID: id, Properties: props, Children: []dbus.Variant{}}
+}
+
+// Returns the ids of the root menu's items. Must be called with trayMu held.
+func trayMenuIDs() []int32 {
+	ids := []int32{}
+	for i := range trayEntries {
+		ids = append(ids, TRAY_ITEM_ENTRY+int32(i))
+	}
+	if len(ids) == 0 {
+		ids = append(ids, TRAY_ITEM_EMPTY)
+	}
+
+	return append(ids, TRAY_ITEM_SEPARATOR, TRAY_ITEM_PAUSE, TRAY_ITEM_OPEN, TRAY_ITEM_CLEAR, TRAY_ITEM_QUIT)
+}
+
+// Handles a click on a tray menu item.
+func trayClicked(id int32) {
+	switch id {
+	case TRAY_ITEM_PAUSE:
+		togglePause()
+	case TRAY_ITEM_OPEN:
+		showWindow()
+	case TRAY_ITEM_CLEAR:
+		clearHistoryAction()
+	case TRAY_ITEM_QUIT:
+		trayQuit()
+	default:
+		trayMu.Lock()
+		i := int(id - TRAY_ITEM_ENTRY)
+		if i < 0 || i >= len(trayEntries) {
+			trayMu.Unlock()
+			return
+		}
+		entryID := trayEntries[i].ID
+		trayMu.Unlock()
+
+		for _, e := range appConf.Log {
+			if e.ID != entryID {
+				continue
+			}
+
+			err := clipboard.WriteAll(entryValue(e))
+			if err != nil {
+				log.Printf("failed to write to clipboard: %v", err.Error())
+			}
+			return
+		}
+	}
+}
+
+// Shows or hides the window when the tray icon is clicked.
+func (trayItem) Activate(x, y int32) *dbus.Error {
+	fltk.Awake(func() {
+		if win.IsShown() && win.Visible() {
+			forgetActiveWindow()
+			win.Hide()
+			return
+		}
+
+		showWindow()
+	})
+
+	return nil
+}
+
+// Pauses or resumes capture when the tray icon is middle-clicked.
+func (trayItem) SecondaryActivate(x, y int32) *dbus.Error {
+	fltk.Awake(togglePause)
+
+	return nil
+}
+
+// Not used, since the menu is provided through dbusmenu.
+func (trayItem) ContextMenu(x, y int32) *dbus.Error {
+	return nil
+}
+
+// Scrolling over the tray icon does nothing.
+func (trayItem) Scroll(delta int32, orientation string) *dbus.Error {
+	return nil
+}
+
+// Returns the menu's items below parentID.
+func (trayMenu) GetLayout(parentID int32, recursionDepth int32, propertyNames []string) (uint32, trayMenuLayout, *dbus.Error) {
+	trayMu.Lock()
+	defer trayMu.Unlock()
+
+	if parentID != TRAY_ITEM_ROOT {
+		return trayRevision, trayMenuItem(parentID), nil
+	}
+
+	root := trayMenuLayout{
+		ID:         TRAY_ITEM_ROOT,
+		Properties: map[string]dbus.Variant{"children-display": dbus.MakeVariant("submenu")},
+		Children:   []dbus.Variant{},
+	}
+	if recursionDepth != 0 {
+		for _, id := range trayMenuIDs() {
+			root.Children = append(root.Children, dbus.MakeVariant(trayMenuItem(id)))
+		}
+	}
+
+	return trayRevision, root, nil
+}
+
+// Returns the properties of the items with the provided ids, or of every item
+// if ids is empty.
+func (trayMenu) GetGroupProperties(ids []int32, propertyNames []string) ([]trayMenuProps, *dbus.Error) {
+	trayMu.Lock()
+	defer trayMu.Unlock()
+
+	if len(ids) == 0 {
+		ids = trayMenuIDs()
+	}
+
+	result := []trayMenuProps{}
+	for _, id := range ids {
+		result = append(result, trayMenuProps{ID: id, Properties: trayMenuItem(id).Properties})
+	}
+
+	return result, nil
+}
+
+// Returns a single property of an item.
+func (trayMenu) GetProperty(id int32, name string) (dbus.Variant, *dbus.Error) {
+	trayMu.Lock()
+	defer trayMu.Unlock()
+
+	v, ok := trayMenuItem(id).Properties[name]
+	if !ok {
+		return dbus.MakeVariant(""), dbus.MakeFailedError(fmt.Errorf("no property %v on item %v", name, id))
+	}
+
+	return v, nil
+}
+
+// Handles clicks on the menu's items.
+func (m trayMenu) Event(id int32, eventID string, data dbus.Variant, timestamp uint32) *dbus.Error {
+	if eventID == "clicked" {
+		// widgets may only be modified from the ui thread
+		fltk.Awake(func() {
+			trayClicked(id)
+		})
+	}
+
+	return nil
+}
+
+// Handles several events at once.
+func (m trayMenu) EventGroup(events []trayMenuEvent) ([]int32, *dbus.Error) {
+	for _, e := range events {
+		_ = m.Event(e.ID, e.EventID, e.Data, e.Timestamp)
+	}
+
+	return []int32{}, nil
+}
+
+// Called before the menu is shown. The menu is always up to date, so no
+// update is needed.
+func (trayMenu) AboutToShow(id int32) (bool, *dbus.Error) {
+	return false, nil
+}
+
+// Like AboutToShow, for several items at once.
+func (trayMenu) AboutToShowGroup(ids []int32) ([]int32, []int32, *dbus.Error) {
+	return []int32{}, []int32{}, nil
+}
@@ -2,8 +2,8 @@ package main
 
 import (
 	"fmt"
-	"log"
 	"math"
+	"strings"
 
 	"github.com/pwiecz/go-fltk"
 )
@@ -18,6 +18,8 @@ const (
 
 	PAGE_MAIN     uint8 = 0
 	PAGE_SETTINGS uint8 = 1
+
+	WINDOW_TITLE = "Clipboard Manager FLTK"
 )
 
 // Positioning (x,y,w,h) for fltk elements
@@ -71,6 +73,167 @@ func (p *Pos) Translate(winW, winH int) {
 	p.H = tr(p.H, winW, winH, true)
 }
 
+// The most recent status message, such as the result of a copy or delete. It is
+// shown in the log browser's label alongside the history size and pause state.
+var statusMessage string
+
+// Runs f on the ui thread and waits for it to return. Goroutines such as the
+// capture loop and the ipc listener use it to change the history and widgets,
+// which are otherwise only touched by the ui thread. Must not be called from
+// the ui thread. Tests replace it to run f directly.
+var onUIThread = func(f func()) {
+	done := make(chan struct{})
+	fltk.Awake(func() {
+		defer close(done)
+		f()
+	})
+	<-done
+}
+
+// Sets the status message and refreshes the log browser's label.
+func setStatus(msg string) {
+	statusMessage = msg
+	refreshStatus()
+}
+
+// Updates the log browser's label to show the current history size, pause
+// state, and the most recent status message.
+func refreshStatus() {
+	if logBrowser == nil {
+		return
+	}
+
+	parts := []string{
+		fmt.Sprintf("%v items, %v", len(appConf.Log), formatBytes(historyBytes())),
+	}
+
+	if storeEnabled() {
+		total, size := storeStats(searchQuery, tagFilter)
+		parts[0] = fmt.Sprintf("%v-%v of %v items, %v", pageOffset+1, pageOffset+len(appConf.Log), total, formatBytes(size))
+	}
+
+	if isPausedNoExpire() {
+		parts = append(parts, fmt.Sprintf("capture %v", pauseStatus()))
+	}
+
+	if q := queueStatus(); q != "" {
+		parts = append(parts, q)
+	}
+
+	if statusMessage != "" {
+		parts = append(parts, statusMessage)
+	}
+
+	logBrowser.SetLabel(strings.Join(parts, " | "))
+	logBrowser.Redraw()
+
+	updateTray()
+}
+
+// Shows a native file chooser and returns the chosen file, or false if the
+// user cancelled.
+func chooseFile(title, filter string, save bool) (string, bool) {
+	fc := fltk.NewNativeFileChooser()
+	defer fc.Destroy()
+
+	fc.SetTitle(title)
+	fc.SetFilter(filter)
+	if save {
+		fc.SetType(fltk.NativeFileChooser_BROWSE_SAVE_FILE)
+		fc.SetOptions(fltk.NativeFileChooser_SAVEAS_CONFIRM | fltk.NativeFileChooser_USE_FILTER_EXT)
+	} else {
+		fc.SetType(fltk.NativeFileChooser_BROWSE_FILE)
+	}
+
+	if fc.Show() != 0 {
+		return "", false
+	}
+
+	files := fc.Filenames()
+	if len(files) == 0 {
+		return "", false
+	}
+
+	return files[0], true
+}
+
+// Maps each row in the log browser (starting at row 1, so rows[0] is row 1)
+// to its index in appConf.Log. Rows are shown newest first, and only entries
+// matching the search query and tag filter have a row.
+var rows []int
+
+// Returns the index in appConf.Log of the entry shown at row i of the log
+// browser, or -1 if there is no such row.
+func rowEntry(i int) int {
+	if i < 1 || i > len(rows) {
+		return -1
+	}
+
+	return rows[i-1]
+}
+
+// Returns the indices in appConf.Log of the entries selected in the log
+// browser, in ascending order.
+func selectedIndices() []int {
+	result := []int{}
+	for i := len(rows); i >= 1; i-- {
+		if logBrowser.IsSelected(i) {
+			result = append(result, rowEntry(i))
+		}
+	}
+
+	return result
+}
+
+// Rebuilds the log browser's rows from appConf.Log, after trimming the log to
+// the configured limits.
+func reconstruct() {
+	// the widgets don't exist until the window is built
+	if logBrowser == nil {
+		return
+	}
+
+	logBrowser.Clear()
+	enforceMaxEntries()
+	enforceHistoryBudget()
+	l := len(appConf.Log)
+	rows = []int{}
+	// initialize with the previously stored entries
+	if l > 0 {
+		i := 1
+		for j := l - 1; j >= 0; j-- {
+			if !matchesSearch(appConf.Log[j]) {
+				continue
+			}
+
+			v := strings.ReplaceAll(obscure(appConf.Log[j].Value, appConf.Secrets), "\n", "\\n")
+			// v = fmt.Sprintf("%v.  %v", j+1, v[:minz(len(v)-1, 200)])
+			v = v[0:minz(len(v), 200)]
+			if len(appConf.Log[j].Tags) > 0 {
+				v = fmt.Sprintf("#%v %v", strings.Join(appConf.Log[j].Tags, " #"), v)
+			}
+			if tag, ok := kindTags[entryKind(appConf.Log[j])]; ok {
+				v = fmt.Sprintf("%v %v", tag, v)
+			}
+			if appConf.Log[j].Pinned {
+				v = fmt.Sprintf("[pinned] %v", v)
+			}
+			if c := useCount(appConf.Log[j]); c > 1 {
+				v = fmt.Sprintf("%v.  (x%v) %v", i, c, v)
+			} else {
+				v = fmt.Sprintf("%v.  %v", i, v)
+			}
+			logBrowser.Add(v)
+			rows = append(rows, j)
+			_ = logBrowser.SetSelected(i, appConf.Log[j].Selected)
+			i++
+		}
+	}
+
+	tagFilterBtn.SetLabel(tagFilterLabel())
+	refreshStatus()
+}
+
 func switchPage(p uint8) {
 	currentPage = p
 	switch p {
@@ -81,31 +244,57 @@ func switchPage(p uint8) {
 		maxEntriesInput.Hide()
 		captureIntervalMsInput.Hide()
 		darkModeBtn.Hide()
+		themeChoice.Hide()
+		persistPauseBtn.Hide()
+		retentionBtn.Hide()
+		autoPasteBtn.Hide()
+		keybindingsBtn.Hide()
+		trashBtn.Hide()
+		syncBtn.Hide()
 		backBtn.Deactivate()
 		saveBtn.Deactivate()
 		maxEntriesInput.Deactivate()
 		captureIntervalMsInput.Deactivate()
 		darkModeBtn.Deactivate()
+		themeChoice.Deactivate()
+		persistPauseBtn.Deactivate()
+		retentionBtn.Deactivate()
+		autoPasteBtn.Deactivate()
+		keybindingsBtn.Deactivate()
+		trashBtn.Deactivate()
+		syncBtn.Deactivate()
 
 		// show main page content
 		settingsBtn.Activate()
+		pauseBtn.Activate()
 		deleteBtn.Activate()
 		copyBtn.Activate()
 		logBrowser.Activate()
+		searchInput.Activate()
+		tagFilterBtn.Activate()
 		settingsBtn.Show()
+		pauseBtn.Show()
 		deleteBtn.Show()
 		copyBtn.Show()
 		logBrowser.Show()
+		searchInput.Show()
+		tagFilterBtn.Show()
 	case PAGE_SETTINGS:
 		// hide main page content
 		settingsBtn.Hide()
+		pauseBtn.Hide()
 		deleteBtn.Hide()
 		copyBtn.Hide()
 		logBrowser.Hide()
+		searchInput.Hide()
+		tagFilterBtn.Hide()
 		settingsBtn.Deactivate()
+		pauseBtn.Deactivate()
 		deleteBtn.Deactivate()
 		copyBtn.Deactivate()
 		logBrowser.Deactivate()
+		searchInput.Deactivate()
+		tagFilterBtn.Deactivate()
 
 		// show settings page content
 		backBtn.Activate()
@@ -113,11 +302,25 @@ func switchPage(p uint8) {
 		maxEntriesInput.Activate()
 		captureIntervalMsInput.Activate()
 		darkModeBtn.Activate()
+		themeChoice.Activate()
+		persistPauseBtn.Activate()
+		retentionBtn.Activate()
+		autoPasteBtn.Activate()
+		keybindingsBtn.Activate()
+		trashBtn.Activate()
+		syncBtn.Activate()
 		backBtn.Show()
 		saveBtn.Show()
 		maxEntriesInput.Show()
 		captureIntervalMsInput.Show()
 		darkModeBtn.Show()
+		themeChoice.Show()
+		persistPauseBtn.Show()
+		retentionBtn.Show()
+		autoPasteBtn.Show()
+		keybindingsBtn.Show()
+		trashBtn.Show()
+		syncBtn.Show()
 	}
 }
 
@@ -138,41 +341,67 @@ func responsive(win *fltk.Window) {
 
 	switch currentPage {
 	case PAGE_MAIN:
-		logBrowserPos := Pos{X: 5, Y: 5, W: 140, H: 75}
-		settingsBtnPos := Pos{X: 5, Y: 85, W: 35, H: 10}
-		deleteBtnPos := Pos{X: 45, Y: 85, W: 35, H: 10}
-		copyBtnPos := Pos{X: 85, Y: 85, W: 60, H: 10}
+		searchInputPos := Pos{X: 5, Y: 5, W: 105, H: 10}
+		tagFilterBtnPos := Pos{X: 115, Y: 5, W: 30, H: 10}
+		logBrowserPos := Pos{X: 5, Y: 20, W: 140, H: 60}
+		settingsBtnPos := Pos{X: 5, Y: 85, W: 30, H: 10}
+		pauseBtnPos := Pos{X: 40, Y: 85, W: 30, H: 10}
+		deleteBtnPos := Pos{X: 75, Y: 85, W: 30, H: 10}
+		copyBtnPos := Pos{X: 110, Y: 85, W: 35, H: 10}
 
 		if portrait {
-			logBrowserPos = Pos{X: 5, Y: 5, W: 90, H: 95}
-			settingsBtnPos = Pos{X: 5, Y: 105, W: 90, H: 10}
+			searchInputPos = Pos{X: 5, Y: 5, W: 60, H: 10}
+			tagFilterBtnPos = Pos{X: 68, Y: 5, W: 27, H: 10}
+			logBrowserPos = Pos{X: 5, Y: 20, W: 90, H: 65}
+			settingsBtnPos = Pos{X: 5, Y: 90, W: 90, H: 10}
+			pauseBtnPos = Pos{X: 5, Y: 105, W: 90, H: 10}
 			deleteBtnPos = Pos{X: 5, Y: 120, W: 90, H: 10}
 			copyBtnPos = Pos{X: 5, Y: 135, W: 90, H: 10}
 		}
 
 		settingsBtnPos.Translate(winW, winH)
+		pauseBtnPos.Translate(winW, winH)
 		deleteBtnPos.Translate(winW, winH)
 		copyBtnPos.Translate(winW, winH)
 		logBrowserPos.Translate(winW, winH)
+		searchInputPos.Translate(winW, winH)
+		tagFilterBtnPos.Translate(winW, winH)
 
 		settingsBtn.Resize(settingsBtnPos.X, settingsBtnPos.Y, settingsBtnPos.W, settingsBtnPos.H)
+		pauseBtn.Resize(pauseBtnPos.X, pauseBtnPos.Y, pauseBtnPos.W, pauseBtnPos.H)
 		deleteBtn.Resize(deleteBtnPos.X, deleteBtnPos.Y, deleteBtnPos.W, deleteBtnPos.H)
 		copyBtn.Resize(copyBtnPos.X, copyBtnPos.Y, copyBtnPos.W, copyBtnPos.H)
 		logBrowser.Resize(logBrowserPos.X, logBrowserPos.Y, logBrowserPos.W, logBrowserPos.H)
+		searchInput.Resize(searchInputPos.X, searchInputPos.Y, searchInputPos.W, searchInputPos.H)
+		tagFilterBtn.Resize(tagFilterBtnPos.X, tagFilterBtnPos.Y, tagFilterBtnPos.W, tagFilterBtnPos.H)
 	// settings page
 	case PAGE_SETTINGS:
 		back := Pos{X: 5, Y: 85, W: 35, H: 10}
-		save := Pos{X: 45, Y: 85, W: 100, H: 10}
+		save := Pos{X: 45, Y: 85, W: 60, H: 10}
+		trash := Pos{X: 110, Y: 85, W: 35, H: 10}
 		entries := Pos{X: 5, Y: 15, W: 60, H: 10}
 		capture := Pos{X: 85, Y: 15, W: 60, H: 10}
 		dark := Pos{X: 5, Y: 30, W: 60, H: 10}
+		persistPause := Pos{X: 85, Y: 30, W: 60, H: 10}
+		retention := Pos{X: 5, Y: 45, W: 60, H: 10}
+		autoPaste := Pos{X: 85, Y: 45, W: 60, H: 10}
+		themeSel := Pos{X: 5, Y: 65, W: 45, H: 10}
+		keys := Pos{X: 55, Y: 65, W: 42, H: 10}
+		syncPos := Pos{X: 102, Y: 65, W: 43, H: 10}
 
 		if portrait {
 			back = Pos{X: 5, Y: 135, W: 90, H: 10}
 			save = Pos{X: 5, Y: 120, W: 90, H: 10}
 			entries = Pos{X: 5, Y: 15, W: 90, H: 10}
 			capture = Pos{X: 5, Y: 40, W: 90, H: 10}
-			dark = Pos{X: 5, Y: 55, W: 90, H: 10}
+			dark = Pos{X: 5, Y: 55, W: 43, H: 10}
+			themeSel = Pos{X: 52, Y: 55, W: 43, H: 10}
+			persistPause = Pos{X: 5, Y: 70, W: 43, H: 10}
+			syncPos = Pos{X: 52, Y: 70, W: 43, H: 10}
+			retention = Pos{X: 5, Y: 85, W: 43, H: 10}
+			keys = Pos{X: 52, Y: 85, W: 43, H: 10}
+			autoPaste = Pos{X: 5, Y: 100, W: 43, H: 10}
+			trash = Pos{X: 52, Y: 100, W: 43, H: 10}
 		}
 
 		back.Translate(winW, winH)
@@ -180,79 +409,104 @@ func responsive(win *fltk.Window) {
 		entries.Translate(winW, winH)
 		capture.Translate(winW, winH)
 		dark.Translate(winW, winH)
+		themeSel.Translate(winW, winH)
+		persistPause.Translate(winW, winH)
+		retention.Translate(winW, winH)
+		autoPaste.Translate(winW, winH)
+		keys.Translate(winW, winH)
+		trash.Translate(winW, winH)
+		syncPos.Translate(winW, winH)
 
 		backBtn.Resize(back.X, back.Y, back.W, back.H)
 		saveBtn.Resize(save.X, save.Y, save.W, save.H)
 		maxEntriesInput.Resize(entries.X, entries.Y, entries.W, entries.H)
 		captureIntervalMsInput.Resize(capture.X, capture.Y, capture.W, capture.H)
 		darkModeBtn.Resize(dark.X, dark.Y, dark.W, dark.H)
+		themeChoice.Resize(themeSel.X, themeSel.Y, themeSel.W, themeSel.H)
+		persistPauseBtn.Resize(persistPause.X, persistPause.Y, persistPause.W, persistPause.H)
+		retentionBtn.Resize(retention.X, retention.Y, retention.W, retention.H)
+		autoPasteBtn.Resize(autoPaste.X, autoPaste.Y, autoPaste.W, autoPaste.H)
+		keybindingsBtn.Resize(keys.X, keys.Y, keys.W, keys.H)
+		trashBtn.Resize(trash.X, trash.Y, trash.W, trash.H)
+		syncBtn.Resize(syncPos.X, syncPos.Y, syncPos.W, syncPos.H)
 	}
 }
 
-const (
-	DARK_COLOR_TEXT              fltk.Color = 0x9f9f9f00
-	DARK_COLOR_INPUT_BG          fltk.Color = 0x20202000
-	DARK_COLOR_INPUT_SELECTED_BG fltk.Color = 0xafafaf00
-	// DARK_COLOR_TEXT              fltk.Color = 0x20202000
-	// DARK_COLOR_INPUT_BG          fltk.Color = 0x9f9f9f00
-	// DARK_COLOR_INPUT_SELECTED_BG fltk.Color = 0xafafaf00
-
-	LIGHT_COLOR_TEXT              fltk.Color = 0x20030500
-	LIGHT_COLOR_INPUT_BG          fltk.Color = 0xFFFFFF00
-	LIGHT_COLOR_INPUT_SELECTED_BG fltk.Color = 0x00008000
-)
+// Applies the theme's colors to fltk's defaults and to every widget, and
+// redraws the window.
+func theme(t Theme) {
+	r, g, b, _ := parseColor(t.Background)
+	fltk.SetBackgroundColor(r, g, b)
+	r, g, b, _ = parseColor(t.Foreground)
+	fltk.SetForegroundColor(r, g, b)
+	r, g, b, _ = parseColor(t.Input)
+	fltk.SetBackground2Color(r, g, b)
 
-var (
-	COLOR_TEXT              fltk.Color = LIGHT_COLOR_TEXT
-	COLOR_INPUT_BG          fltk.Color = LIGHT_COLOR_INPUT_BG
-	COLOR_INPUT_SELECTED_BG fltk.Color = LIGHT_COLOR_INPUT_SELECTED_BG
-)
+	text := themeColor(t.Text)
+	button := themeColor(t.Button)
+	input := themeColor(t.Input)
+	browser := themeColor(t.Browser)
+	selection := themeColor(t.Selection)
 
-func theme(dark bool) {
-	if dark {
-		log.Println("dark mode activated")
-		COLOR_TEXT = DARK_COLOR_TEXT
-		COLOR_INPUT_BG = DARK_COLOR_INPUT_BG
-		COLOR_INPUT_SELECTED_BG = DARK_COLOR_INPUT_SELECTED_BG
-		fltk.SetForegroundColor(230, 230, 230)
-		fltk.SetBackgroundColor(40, 40, 40)
-	} else {
-		log.Println("light mode activated")
-		COLOR_TEXT = LIGHT_COLOR_TEXT
-		COLOR_INPUT_BG = LIGHT_COLOR_INPUT_BG
-		COLOR_INPUT_SELECTED_BG = LIGHT_COLOR_INPUT_SELECTED_BG
-		fltk.SetBackgroundColor(192, 192, 192)
-		fltk.SetForegroundColor(0, 0, 0)
-		return
-	}
+	settingsBtn.SetLabelColor(text)
+	pauseBtn.SetLabelColor(text)
+	deleteBtn.SetLabelColor(text)
+	copyBtn.SetLabelColor(text)
+	logBrowser.SetLabelColor(text)
+	searchInput.SetLabelColor(text)
+	tagFilterBtn.SetLabelColor(text)
+	maxEntriesInput.SetLabelColor(text)
+	captureIntervalMsInput.SetLabelColor(text)
+	backBtn.SetLabelColor(text)
+	saveBtn.SetLabelColor(text)
+	darkModeBtn.SetLabelColor(text)
+	themeChoice.SetLabelColor(text)
+	persistPauseBtn.SetLabelColor(text)
+	retentionBtn.SetLabelColor(text)
+	autoPasteBtn.SetLabelColor(text)
+	keybindingsBtn.SetLabelColor(text)
+	trashBtn.SetLabelColor(text)
+	syncBtn.SetLabelColor(text)
+
+	settingsBtn.SetColor(button)
+	pauseBtn.SetColor(button)
+	deleteBtn.SetColor(button)
+	copyBtn.SetColor(button)
+	logBrowser.SetColor(browser)
+	searchInput.SetColor(input)
+	tagFilterBtn.SetColor(button)
+	maxEntriesInput.SetColor(input)
+	captureIntervalMsInput.SetColor(input)
+	backBtn.SetColor(button)
+	saveBtn.SetColor(button)
+	darkModeBtn.SetColor(button)
+	themeChoice.SetColor(button)
+	persistPauseBtn.SetColor(button)
+	retentionBtn.SetColor(button)
+	autoPasteBtn.SetColor(button)
+	keybindingsBtn.SetColor(button)
+	trashBtn.SetColor(button)
+	syncBtn.SetColor(button)
+
+	settingsBtn.SetSelectionColor(selection)
+	pauseBtn.SetSelectionColor(selection)
+	deleteBtn.SetSelectionColor(selection)
+	copyBtn.SetSelectionColor(selection)
+	logBrowser.SetSelectionColor(selection)
+	searchInput.SetSelectionColor(selection)
+	tagFilterBtn.SetSelectionColor(selection)
+	maxEntriesInput.SetSelectionColor(selection)
+	captureIntervalMsInput.SetSelectionColor(selection)
+	backBtn.SetSelectionColor(selection)
+	saveBtn.SetSelectionColor(selection)
+	darkModeBtn.SetSelectionColor(selection)
+	themeChoice.SetSelectionColor(selection)
+	persistPauseBtn.SetSelectionColor(selection)
+	retentionBtn.SetSelectionColor(selection)
+	autoPasteBtn.SetSelectionColor(selection)
+	keybindingsBtn.SetSelectionColor(selection)
+	trashBtn.SetSelectionColor(selection)
+	syncBtn.SetSelectionColor(selection)
 
-	settingsBtn.SetLabelColor(COLOR_TEXT)
-	deleteBtn.SetLabelColor(COLOR_TEXT)
-	copyBtn.SetLabelColor(COLOR_TEXT)
-	logBrowser.SetLabelColor(COLOR_TEXT)
-	maxEntriesInput.SetLabelColor(COLOR_TEXT)
-	captureIntervalMsInput.SetLabelColor(COLOR_TEXT)
-	backBtn.SetLabelColor(COLOR_TEXT)
-	saveBtn.SetLabelColor(COLOR_TEXT)
-	darkModeBtn.SetLabelColor(COLOR_TEXT)
-
-	settingsBtn.SetColor(COLOR_INPUT_BG)
-	deleteBtn.SetColor(COLOR_INPUT_BG)
-	copyBtn.SetColor(COLOR_INPUT_BG)
-	logBrowser.SetColor(COLOR_INPUT_BG)
-	maxEntriesInput.SetColor(COLOR_INPUT_BG)
-	captureIntervalMsInput.SetColor(COLOR_INPUT_BG)
-	backBtn.SetColor(COLOR_INPUT_BG)
-	saveBtn.SetColor(COLOR_INPUT_BG)
-	darkModeBtn.SetColor(COLOR_INPUT_BG)
-
-	settingsBtn.SetSelectionColor(COLOR_INPUT_SELECTED_BG)
-	deleteBtn.SetSelectionColor(COLOR_INPUT_SELECTED_BG)
-	copyBtn.SetSelectionColor(COLOR_INPUT_SELECTED_BG)
-	logBrowser.SetSelectionColor(COLOR_INPUT_SELECTED_BG)
-	maxEntriesInput.SetSelectionColor(COLOR_INPUT_SELECTED_BG)
-	captureIntervalMsInput.SetSelectionColor(COLOR_INPUT_SELECTED_BG)
-	backBtn.SetSelectionColor(COLOR_INPUT_SELECTED_BG)
-	saveBtn.SetSelectionColor(COLOR_INPUT_SELECTED_BG)
-	darkModeBtn.SetSelectionColor(COLOR_INPUT_SELECTED_BG)
+	win.Redraw()
 }
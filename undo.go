@@ -0,0 +1,210 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+)
+
+const (
+	// The number of changes that can be undone.
+	MAX_UNDO_STEPS = 50
+
+	// Names of the changes that can be undone, shown in status messages.
+	UNDO_DELETE  = "delete"
+	UNDO_CLEAR   = "clear"
+	UNDO_EDIT    = "edit"
+	UNDO_PIN     = "pin"
+	UNDO_RESTORE = "restore"
+)
+
+// The state of one entry before and after a change. A nil state means that the
+// entry wasn't in the history.
+type entryChange struct {
+	ID     string
+	Before *ClipboardEntry
+	After  *ClipboardEntry
+}
+
+// A change to the history that can be undone and redone.
+type undoStep struct {
+	Name    string
+	Changes []entryChange
+}
+
+var (
+	// Changes that can be undone, oldest first.
+	undoStack []undoStep
+	// Changes that were undone and can be redone, most recently undone last.
+	// Making a new change clears it.
+	redoStack []undoStep
+)
+
+// Returns a description of the step for status messages, such as "delete of 3
+// items".
+func (s undoStep) String() string {
+	if len(s.Changes) == 1 {
+		return s.Name
+	}
+
+	return fmt.Sprintf("%v of %v items", s.Name, len(s.Changes))
+}
+
+// Records a change so that it can be undone, and forgets the changes that
+// could be redone.
+func pushUndo(name string, changes []entryChange) {
+	if len(changes) == 0 {
+		return
+	}
+
+	undoStack = append(undoStack, undoStep{Name: name, Changes: changes})
+	if len(undoStack) > MAX_UNDO_STEPS {
+		dropped := undoStack[0]
+		undoStack = slices.Delete(undoStack, 0, 1)
+		releaseStep(dropped)
+	}
+
+	dropped := redoStack
+	redoStack = nil
+	for _, s := range dropped {
+		releaseStep(s)
+	}
+}
+
+// Removes the blobs that only a forgotten step still referenced.
+func releaseStep(s undoStep) {
+	for _, c := range s.Changes {
+		if c.Before != nil {
+			removeBlob(*c.Before, appConf.Log)
+		}
+		if c.After != nil {
+			removeBlob(*c.After, appConf.Log)
+		}
+	}
+}
+
+// Forgets every change that could be undone or redone.
+func clearUndo() {
+	steps := append(undoStack, redoStack...)
+	undoStack, redoStack = nil, nil
+	for _, s := range steps {
+		releaseStep(s)
+	}
+}
+
+// Returns true if an entry in the trash or a change that can be undone or
+// redone uses the provided blob.
+func blobReferenced(blob string) bool {
+	for _, e := range appConf.Trash {
+		if e.Blob == blob {
+			return true
+		}
+	}
+
+	for _, s := range append(slices.Clone(undoStack), redoStack...) {
+		for _, c := range s.Changes {
+			if (c.Before != nil && c.Before.Blob == blob) || (c.After != nil && c.After.Blob == blob) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// Returns the index in appConf.Log of the entry with the provided id, or -1.
+func entryIndex(id string) int {
+	return slices.IndexFunc(appConf.Log, func(e ClipboardEntry) bool {
+		return e.ID == id
+	})
+}
+
+// Replaces the entry with the provided id by e, adding it if it isn't in the
+// history, or removes it if e is nil. Blobs are kept, since the trash or the
+// undo history may still need them. The change isn't synced.
+func setEntryState(id string, e *ClipboardEntry) {
+	i := entryIndex(id)
+	invalidateDedupeIndex()
+
+	if e == nil {
+		if i >= 0 {
+			appConf.Log = slices.Delete(appConf.Log, i, i+1)
+		}
+		storeDelete([]string{id})
+		return
+	}
+
+	if i >= 0 {
+		appConf.Log[i] = *e
+	} else {
+		appConf.Log = append(appConf.Log, *e)
+	}
+	storePut(*e)
+}
+
+// Applies the after states of changes, or the before states if undo is true.
+// Entries that are removed go to the trash, and entries that are added back
+// are taken out of it.
+func applyChanges(changes []entryChange, undo bool) {
+	for _, c := range changes {
+		from, to := c.Before, c.After
+		if undo {
+			from, to = to, from
+		}
+
+		setEntryState(c.ID, to)
+		syncLocal(c.ID, to)
+
+		switch {
+		case from != nil && to == nil:
+			trashEntry(*from)
+		case from == nil && to != nil:
+			untrashEntry(c.ID)
+		}
+	}
+
+	// restored entries go back to where they were in the history
+	sortHistory()
+
+	loadHistory()
+}
+
+// Deletes entries from the history, moving them to the trash unless it is
+// disabled, and records the deletion so that it can be undone.
+func deleteEntries(name string, entries []ClipboardEntry) {
+	changes := make([]entryChange, len(entries))
+	for i, e := range entries {
+		changes[i] = entryChange{ID: e.ID, Before: &e}
+	}
+
+	pushUndo(name, changes)
+	applyChanges(changes, false)
+}
+
+// Reverts the most recent change. Returns false if there is nothing to undo.
+func undo() (undoStep, bool) {
+	if len(undoStack) == 0 {
+		return undoStep{}, false
+	}
+
+	s := undoStack[len(undoStack)-1]
+	undoStack = undoStack[:len(undoStack)-1]
+	redoStack = append(redoStack, s)
+	applyChanges(s.Changes, true)
+
+	return s, true
+}
+
+// Makes the most recently undone change again. Returns false if there is
+// nothing to redo.
+func redo() (undoStep, bool) {
+	if len(redoStack) == 0 {
+		return undoStep{}, false
+	}
+
+	s := redoStack[len(redoStack)-1]
+	redoStack = redoStack[:len(redoStack)-1]
+	undoStack = append(undoStack, s)
+	applyChanges(s.Changes, false)
+
+	return s, true
+}
@@ -0,0 +1,225 @@
+//go:build linux
+
+package main
+
+/*
+#cgo LDFLAGS: -lX11 -ldl
+#include <dlfcn.h>
+#include <stdlib.h>
+#include <string.h>
+#include <X11/Xlib.h>
+#include <X11/Xatom.h>
+#include <X11/Xutil.h>
+
+// Returns the window that has focus according to the window manager, or 0.
+static Window x11ActiveWindow(Display *d) {
+	Atom prop = XInternAtom(d, "_NET_ACTIVE_WINDOW", False);
+	Atom type;
+	int format;
+	unsigned long n, after;
+	unsigned char *data = NULL;
+	Window w = 0;
+
+	if (XGetWindowProperty(d, DefaultRootWindow(d), prop, 0, 1, False, XA_WINDOW,
+			&type, &format, &n, &after, &data) == Success && data != NULL && n == 1) {
+		w = *(Window *)data;
+	}
+	if (data != NULL) {
+		XFree(data);
+	}
+
+	return w;
+}
+
+// Returns a copy of the window's WM_CLASS class, or NULL. It must be freed.
+static char *x11WindowClass(Display *d, Window w) {
+	XClassHint hint;
+	if (!XGetClassHint(d, w, &hint)) {
+		return NULL;
+	}
+
+	char *class = hint.res_class != NULL ? strdup(hint.res_class) : NULL;
+	if (hint.res_name != NULL) {
+		XFree(hint.res_name);
+	}
+	if (hint.res_class != NULL) {
+		XFree(hint.res_class);
+	}
+
+	return class;
+}
+
+// Asks the window manager to focus the window.
+static void x11ActivateWindow(Display *d, Window w) {
+	XEvent e;
+	memset(&e, 0, sizeof(e));
+	e.xclient.type = ClientMessage;
+	e.xclient.message_type = XInternAtom(d, "_NET_ACTIVE_WINDOW", False);
+	e.xclient.window = w;
+	e.xclient.format = 32;
+	// 2 means the request comes from a pager or similar tool, which window
+	// managers honor more readily than requests from regular apps
+	e.xclient.data.l[0] = 2;
+	e.xclient.data.l[1] = CurrentTime;
+
+	XSendEvent(d, DefaultRootWindow(d), False, SubstructureRedirectMask | SubstructureNotifyMask, &e);
+	XFlush(d);
+}
+
+typedef int (*fakeKeyEventFunc)(Display *, unsigned int, Bool, unsigned long);
+
+// Presses the keys in order and releases them in reverse order, using the
+// XTest extension. libXtst is loaded at runtime so that it is only required
+// when this is used. Returns 0 on success, 1 if libXtst is not available and
+// 2 if a key is unknown.
+static int x11FakeKeys(Display *d, char **keys, int n) {
+	void *lib = dlopen("libXtst.so.6", RTLD_LAZY);
+	if (lib == NULL) {
+		return 1;
+	}
+
+	fakeKeyEventFunc fakeKeyEvent = (fakeKeyEventFunc)dlsym(lib, "XTestFakeKeyEvent");
+	if (fakeKeyEvent == NULL) {
+		dlclose(lib);
+		return 1;
+	}
+
+	KeyCode codes[8];
+	if (n > 8) {
+		n = 8;
+	}
+	for (int i = 0; i < n; i++) {
+		codes[i] = XKeysymToKeycode(d, XStringToKeysym(keys[i]));
+		if (codes[i] == 0) {
+			dlclose(lib);
+			return 2;
+		}
+	}
+
+	for (int i = 0; i < n; i++) {
+		fakeKeyEvent(d, codes[i], True, CurrentTime);
+	}
+	for (int i = n - 1; i >= 0; i--) {
+		fakeKeyEvent(d, codes[i], False, CurrentTime);
+	}
+	XFlush(d);
+	dlclose(lib);
+
+	return 0;
+}
+*/
+import "C"
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"unsafe"
+)
+
+var (
+	x11Mu sync.Mutex
+	// A connection to the X11 display that is separate from fltk's, so that
+	// it can be used from any goroutine.
+	x11Display *C.Display
+)
+
+// Returns the shared X11 connection, opening it if needed. Must be called with
+// x11Mu held.
+func x11Open() (*C.Display, error) {
+	if x11Display != nil {
+		return x11Display, nil
+	}
+
+	x11Display = C.XOpenDisplay(nil)
+	if x11Display == nil {
+		return nil, fmt.Errorf("failed to open X11 display")
+	}
+
+	return x11Display, nil
+}
+
+// Returns the id and WM_CLASS of the window that currently has focus, or 0 if
+// it cannot be determined.
+func activeWindow() (uint64, string) {
+	x11Mu.Lock()
+	defer x11Mu.Unlock()
+
+	d, err := x11Open()
+	if err != nil {
+		return 0, ""
+	}
+
+	w := C.x11ActiveWindow(d)
+	if w == 0 {
+		return 0, ""
+	}
+
+	class := ""
+	c := C.x11WindowClass(d, w)
+	if c != nil {
+		class = C.GoString(c)
+		C.free(unsafe.Pointer(c))
+	}
+
+	return uint64(w), class
+}
+
+// Asks the window manager to focus the window with the provided id.
+func activateWindow(id uint64) error {
+	x11Mu.Lock()
+	defer x11Mu.Unlock()
+
+	d, err := x11Open()
+	if err != nil {
+		return err
+	}
+
+	C.x11ActivateWindow(d, C.Window(id))
+
+	return nil
+}
+
+// Synthesizes a key combination such as ctrl+shift+v using XTest.
+func fakeKeys(spec string) error {
+	x11Mu.Lock()
+	defer x11Mu.Unlock()
+
+	d, err := x11Open()
+	if err != nil {
+		return err
+	}
+
+	keys := []*C.char{}
+	for _, k := range strings.Split(spec, "+") {
+		switch k = strings.ToLower(strings.TrimSpace(k)); k {
+		case "ctrl", "control":
+			k = "Control_L"
+		case "shift":
+			k = "Shift_L"
+		case "alt":
+			k = "Alt_L"
+		case "super":
+			k = "Super_L"
+		case "insert":
+			k = "Insert"
+		}
+
+		c := C.CString(k)
+		defer C.free(unsafe.Pointer(c))
+		keys = append(keys, c)
+	}
+
+	if len(keys) == 0 {
+		return fmt.Errorf("no keys in %v", spec)
+	}
+
+	switch C.x11FakeKeys(d, &keys[0], C.int(len(keys))) {
+	case 0:
+		return nil
+	case 1:
+		return fmt.Errorf("the XTest extension (libXtst) is not available")
+	default:
+		return fmt.Errorf("unknown key in %v", spec)
+	}
+}
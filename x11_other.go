@@ -0,0 +1,20 @@
+//go:build !linux
+
+package main
+
+import "fmt"
+
+// Returns 0, since the active window can only be determined on X11.
+func activeWindow() (uint64, string) {
+	return 0, ""
+}
+
+// Focusing other windows is only supported on X11.
+func activateWindow(id uint64) error {
+	return fmt.Errorf("focusing other windows is not supported on this platform")
+}
+
+// Synthesizing key presses is only supported on X11.
+func fakeKeys(spec string) error {
+	return fmt.Errorf("synthesizing key presses is not supported on this platform")
+}